@@ -0,0 +1,134 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NotificationEvent is a transition of a Userland which is notified to NotificationSinks.
+type NotificationEvent string
+
+// These are the valid events of NotificationSinks.
+const (
+	// PendingEvent is sent when a Userland enters the Pending phase, e.g. when it is created or held.
+	PendingEvent NotificationEvent = "Pending"
+	// RunningEvent is sent when a Userland enters the Running phase and is ready to be used.
+	RunningEvent NotificationEvent = "Running"
+	// SuspendedEvent is sent when a Userland enters the Suspended phase.
+	SuspendedEvent NotificationEvent = "Suspended"
+	// ExpiringEvent is sent when a Userland will be deleted soon by its TTL, expiresAt or deleteAfterIdle.
+	ExpiringEvent NotificationEvent = "Expiring"
+	// ExpiredEvent is sent when a Userland is deleted by its expiry.
+	ExpiredEvent NotificationEvent = "Expired"
+)
+
+// NotificationSinkSpec defines the desired state of NotificationSink
+type NotificationSinkSpec struct {
+	// URL is the https endpoint which the JSON payloads of the events are POSTed to.
+	// It must resolve to a public address; loopback, link-local and private addresses are refused.
+	// +kubebuilder:validation:Pattern=`^https://`
+	URL string `json:"url" protobuf:"bytes,1,opt,name=url"`
+
+	// SecretRef selects a key of a Secret in the namespace of the NotificationSink. The payloads are signed by
+	// HMAC-SHA256 with its value, and the signature is sent in the X-ESC-Signature header as "sha256=<hex>".
+	// The payloads are not signed if it is empty.
+	// +optional
+	SecretRef *v1.SecretKeySelector `json:"secretRef,omitempty" protobuf:"bytes,2,opt,name=secretRef"`
+
+	// Events are the events to send, one of Pending, Running, Suspended, Expiring or Expired.
+	// All the events are sent if it is empty.
+	// +optional
+	Events []NotificationEvent `json:"events,omitempty" protobuf:"bytes,3,rep,name=events,casttype=NotificationEvent"`
+
+	// Selector is a label query over the Userlands in the namespace whose events are sent.
+	// The events of all the Userlands in the namespace are sent if it is empty.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty" protobuf:"bytes,4,opt,name=selector"`
+
+	// Retries is the number of retries of a failed delivery, with an exponential backoff. Default 5.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Retries *int32 `json:"retries,omitempty" protobuf:"varint,5,opt,name=retries"`
+}
+
+// NotificationDelivery is the result of the delivery of an event.
+type NotificationDelivery struct {
+	// Event is the event which is delivered.
+	Event NotificationEvent `json:"event" protobuf:"bytes,1,opt,name=event,casttype=NotificationEvent"`
+
+	// Userland is the name of the Userland of the event.
+	Userland string `json:"userland" protobuf:"bytes,2,opt,name=userland"`
+
+	// Time is the time when the delivery is finished.
+	Time metav1.Time `json:"time" protobuf:"bytes,3,opt,name=time"`
+
+	// Attempts is the number of the requests sent for the event.
+	Attempts int32 `json:"attempts" protobuf:"varint,4,opt,name=attempts"`
+
+	// StatusCode is the HTTP status code of the last response.
+	// +optional
+	StatusCode int32 `json:"statusCode,omitempty" protobuf:"varint,5,opt,name=statusCode"`
+
+	// Error is the reason why the delivery failed.
+	// +optional
+	Error string `json:"error,omitempty" protobuf:"bytes,6,opt,name=error"`
+}
+
+// NotificationSinkStatus defines the observed state of NotificationSink
+type NotificationSinkStatus struct {
+	// Delivered is the number of the events delivered successfully.
+	// +optional
+	Delivered int64 `json:"delivered,omitempty" protobuf:"varint,1,opt,name=delivered"`
+
+	// Failed is the number of the events which are not delivered after all the retries.
+	// +optional
+	Failed int64 `json:"failed,omitempty" protobuf:"varint,2,opt,name=failed"`
+
+	// LastDelivery is the last event delivered successfully.
+	// +optional
+	LastDelivery *NotificationDelivery `json:"lastDelivery,omitempty" protobuf:"bytes,3,opt,name=lastDelivery"`
+
+	// LastFailure is the last event which is not delivered.
+	// +optional
+	LastFailure *NotificationDelivery `json:"lastFailure,omitempty" protobuf:"bytes,4,opt,name=lastFailure"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// NotificationSink is the Schema for the notificationsinks API
+type NotificationSink struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NotificationSinkSpec   `json:"spec,omitempty"`
+	Status NotificationSinkStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// NotificationSinkList contains a list of NotificationSink
+type NotificationSinkList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NotificationSink `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NotificationSink{}, &NotificationSinkList{})
+}
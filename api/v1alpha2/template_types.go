@@ -17,21 +17,250 @@ package v1alpha2
 
 import (
 	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// VolumeScope is the scope which a PersistentVolumeClaim of a Userland is shared in.
+type VolumeScope string
+
+// These are the valid scopes of volumes.
+const (
+	// TemplateVolumeScope gives a PersistentVolumeClaim to each Userland and Template.
+	// The claim is not used after the Template of the Userland is switched.
+	TemplateVolumeScope VolumeScope = "Template"
+	// UserVolumeScope gives a PersistentVolumeClaim to each user, which is kept when the Template is switched.
+	UserVolumeScope VolumeScope = "User"
+)
+
+// VolumeSeed defines the initial content of a PersistentVolumeClaim of a Userland.
+// Exactly one of the sources is set. The seed is applied only when the claim is created.
+type VolumeSeed struct {
+	// VolumeSnapshot is the name of a VolumeSnapshot in the namespace of the claim, which the claim is restored from.
+	// +optional
+	VolumeSnapshot string `json:"volumeSnapshot,omitempty" protobuf:"bytes,1,opt,name=volumeSnapshot"`
+
+	// PersistentVolumeClaim is the name of a claim in the namespace of the claim, which the claim is cloned from.
+	// +optional
+	PersistentVolumeClaim string `json:"persistentVolumeClaim,omitempty" protobuf:"bytes,2,opt,name=persistentVolumeClaim"`
+
+	// ConfigMap selects a key of a ConfigMap in the namespace of the claim, whose binary data is a tarball extracted into the claim.
+	// +optional
+	ConfigMap *v1.ConfigMapKeySelector `json:"configMap,omitempty" protobuf:"bytes,3,opt,name=configMap"`
+
+	// Image is an image which has a tarball at Path extracted into the claim. It must contain sh and tar.
+	// +optional
+	Image string `json:"image,omitempty" protobuf:"bytes,4,opt,name=image"`
+
+	// Path of the tarball in Image.
+	// Default /seed.tar.
+	// +optional
+	Path string `json:"path,omitempty" protobuf:"bytes,5,opt,name=path"`
+}
+
 //VolumeSpec defines the volume of TemplateSpec
 type VolumeSpec struct {
 	//VolumeName is unified volume name.
 	Name string `json:"name" protobuf:"bytes,1,opt,name=name"`
 
+	//Scope of the PersistentVolumeClaim, one of Template or User.
+	//User scoped claims are named after the user and the volume name, so Templates which have a volume of the same name
+	//and a compatible pvcSpec share the claim.
+	//Default Template.
+	// +optional
+	// +kubebuilder:validation:Enum=Template;User
+	Scope VolumeScope `json:"scope,omitempty" protobuf:"bytes,2,opt,name=scope,casttype=VolumeScope"`
+
 	//PersistentVolumeClaimSpec stores to spec of required PersistentVolumeClaim
 	PersistentVolumeClaimSpec v1.PersistentVolumeClaimSpec `json:"pvcSpec" protobuf:"bytes,3,opt,name=pvcSpec"`
+
+	//Seed is the initial content of the PersistentVolumeClaim, applied when the claim is created.
+	// +optional
+	Seed *VolumeSeed `json:"seed,omitempty" protobuf:"bytes,4,opt,name=seed"`
+}
+
+// SizePreset defines a named size of the Userlands of a Template, e.g. small, medium or large.
+type SizePreset struct {
+	// Name of the preset.
+	Name string `json:"name" protobuf:"bytes,1,opt,name=name"`
+
+	// Resources replace the requests and limits of the same resources of the first container of the pod template.
+	// +optional
+	Resources v1.ResourceRequirements `json:"resources,omitempty" protobuf:"bytes,2,opt,name=resources"`
+
+	// Storage maps the names of volumes to the storage requested by their PersistentVolumeClaims.
+	// The claims of existing Userlands are expanded, but never shrunk.
+	// +optional
+	Storage map[string]resource.Quantity `json:"storage,omitempty" protobuf:"bytes,3,rep,name=storage"`
+}
+
+// UserRoleBinding defines a RoleBinding created in the namespace of each user.
+type UserRoleBinding struct {
+	// Name is the name of the RoleBinding.
+	Name string `json:"name" protobuf:"bytes,1,opt,name=name"`
+
+	// RoleRef can reference a Role in the user namespace or a ClusterRole.
+	RoleRef rbacv1.RoleRef `json:"roleRef" protobuf:"bytes,2,opt,name=roleRef"`
+
+	// Subjects holds references to the objects the role applies to.
+	// "$(USER)" in the name of a subject is replaced with the user name of the Userland,
+	// and "$(OWNER)" with the owner of the Userland.
+	// +optional
+	Subjects []rbacv1.Subject `json:"subjects,omitempty" protobuf:"bytes,3,rep,name=subjects"`
+
+	// Collaborators adds the collaborators of the Userland to the subjects.
+	// +optional
+	Collaborators bool `json:"collaborators,omitempty" protobuf:"varint,4,opt,name=collaborators"`
+}
+
+// NamespacePerUserSpec defines the namespace created for each user.
+type NamespacePerUserSpec struct {
+	// ResourceQuota is applied to the namespace of each user.
+	// +optional
+	ResourceQuota *v1.ResourceQuotaSpec `json:"resourceQuota,omitempty" protobuf:"bytes,1,opt,name=resourceQuota"`
+
+	// LimitRange is applied to the namespace of each user.
+	// +optional
+	LimitRange *v1.LimitRangeSpec `json:"limitRange,omitempty" protobuf:"bytes,2,opt,name=limitRange"`
+
+	// RoleBindings are created in the namespace of each user.
+	// +optional
+	RoleBindings []UserRoleBinding `json:"roleBindings,omitempty" protobuf:"bytes,3,rep,name=roleBindings"`
+}
+
+// UserServiceAccountSpec defines the ServiceAccount created for each Userland.
+type UserServiceAccountSpec struct {
+	// Rules are granted to the ServiceAccount by a Role in the namespace where owned resources are placed.
+	// +optional
+	Rules []rbacv1.PolicyRule `json:"rules,omitempty" protobuf:"bytes,1,rep,name=rules"`
+}
+
+// TemplatePatchType is the type of TemplatePatch.
+type TemplatePatchType string
+
+// These are the valid types of TemplatePatch.
+const (
+	// StrategicMergePatchType is a strategic merge patch to TemplateSpec.
+	StrategicMergePatchType TemplatePatchType = "StrategicMerge"
+	// JSONPatchType is a JSON patch (RFC 6902) to TemplateSpec.
+	JSONPatchType TemplatePatchType = "JSON"
+)
+
+// TemplatePatch is a patch applied to the spec of the base Template.
+type TemplatePatch struct {
+	// Type of the patch, one of StrategicMerge or JSON.
+	// Default StrategicMerge.
+	// +optional
+	// +kubebuilder:validation:Enum=StrategicMerge;JSON
+	Type TemplatePatchType `json:"type,omitempty" protobuf:"bytes,1,opt,name=type,casttype=TemplatePatchType"`
+
+	// Patch is the content of the patch in YAML or JSON.
+	Patch string `json:"patch" protobuf:"bytes,2,opt,name=patch"`
+}
+
+// WarmPoolSpec defines the pods kept running before they are claimed by Userlands.
+type WarmPoolSpec struct {
+	//Size is the number of unclaimed pods.
+	// +kubebuilder:validation:Minimum=0
+	Size int32 `json:"size" protobuf:"varint,1,opt,name=size"`
+}
+
+// CertificateIssuerRef refers to the cert-manager issuer which signs the certificates of Userlands.
+type CertificateIssuerRef struct {
+	// Name of the issuer.
+	Name string `json:"name" protobuf:"bytes,1,opt,name=name"`
+
+	// Kind of the issuer, Issuer or ClusterIssuer.
+	// Default Issuer.
+	// +optional
+	Kind string `json:"kind,omitempty" protobuf:"bytes,2,opt,name=kind"`
+
+	// Group of the issuer.
+	// Default cert-manager.io.
+	// +optional
+	Group string `json:"group,omitempty" protobuf:"bytes,3,opt,name=group"`
+}
+
+// CertificateSpec defines the cert-manager Certificate created for each Userland.
+type CertificateSpec struct {
+	// IssuerRef is the issuer which signs the certificate.
+	IssuerRef CertificateIssuerRef `json:"issuerRef" protobuf:"bytes,1,opt,name=issuerRef"`
+
+	// DNSNames are the DNS names of the certificate.
+	// $(TEMPLATE), $(NAME), $(NAMESPACE) and $(USER) are replaced with the values of the Userland.
+	// +kubebuilder:validation:MinItems=1
+	DNSNames []string `json:"dnsNames" protobuf:"bytes,2,rep,name=dnsNames"`
+}
+
+// RouteParentRef refers to the Gateway which the routes of Userlands are attached to.
+type RouteParentRef struct {
+	// Name of the Gateway.
+	Name string `json:"name" protobuf:"bytes,1,opt,name=name"`
+
+	// Namespace of the Gateway.
+	// Default the namespace of the route.
+	// +optional
+	Namespace string `json:"namespace,omitempty" protobuf:"bytes,2,opt,name=namespace"`
+
+	// SectionName is the name of the listener of the Gateway.
+	// +optional
+	SectionName string `json:"sectionName,omitempty" protobuf:"bytes,3,opt,name=sectionName"`
+}
+
+// RouteSpec defines the Gateway API HTTPRoute created for each Userland.
+// $(TEMPLATE), $(NAME), $(NAMESPACE) and $(USER) in Hostnames and Path are replaced with the values of the Userland.
+type RouteSpec struct {
+	// ParentRefs are the Gateways which the route is attached to.
+	// +kubebuilder:validation:MinItems=1
+	ParentRefs []RouteParentRef `json:"parentRefs" protobuf:"bytes,1,rep,name=parentRefs"`
+
+	// Hostnames of the route.
+	// +optional
+	Hostnames []string `json:"hostnames,omitempty" protobuf:"bytes,2,rep,name=hostnames"`
+
+	// Path is the path prefix routed to the Userland.
+	// Default "/".
+	// +optional
+	Path string `json:"path,omitempty" protobuf:"bytes,3,opt,name=path"`
+
+	// Port of the Service routed to.
+	// Default the first port of the Service.
+	// +optional
+	Port *int32 `json:"port,omitempty" protobuf:"varint,4,opt,name=port"`
+}
+
+// VolumeMigration maps a volume of the previous Template of a Userland to a volume of this Template.
+type VolumeMigration struct {
+	// FromTemplate is the name of the previous Template which the mapping is applied to.
+	// Default any Template.
+	// +optional
+	FromTemplate string `json:"fromTemplate,omitempty" protobuf:"bytes,1,opt,name=fromTemplate"`
+
+	// From is the name of the volume of the previous Template.
+	From string `json:"from" protobuf:"bytes,2,opt,name=from"`
+
+	// To is the name of the volume of this Template.
+	To string `json:"to" protobuf:"bytes,3,opt,name=to"`
+}
+
+// MigrationSpec defines how the data of Userlands is copied when they are switched to this Template.
+type MigrationSpec struct {
+	// Volumes are the mappings of the volumes copied.
+	// +kubebuilder:validation:MinItems=1
+	Volumes []VolumeMigration `json:"volumes" protobuf:"bytes,1,rep,name=volumes"`
+
+	// Image of the Job which copies the data. It must contain sh and cp.
+	// Default busybox.
+	// +optional
+	Image string `json:"image,omitempty" protobuf:"bytes,2,opt,name=image"`
 }
 
 // TemplateSpec defines the desired state of Template
 type TemplateSpec struct {
 	//Template stores to spec of required create containers.
+	//It is required unless BaseTemplate is set.
+	// +optional
 	Template v1.PodTemplateSpec `json:"template" protobuf:"bytes,1,opt,name=template"`
 
 	//ServiceSpec stores to spec for expose containers.
@@ -41,16 +270,140 @@ type TemplateSpec struct {
 	//VolumeSpecs defines volumes used to containers.
 	// +optional
 	VolumeSpecs []VolumeSpec `json:"volumes,omitempty" protobuf:"bytes,3,opt,name=volumes"`
+
+	//NamespacePerUser creates a dedicated namespace for each Userland and places owned resources in it.
+	// +optional
+	NamespacePerUser *NamespacePerUserSpec `json:"namespacePerUser,omitempty" protobuf:"bytes,4,opt,name=namespacePerUser"`
+
+	//ServiceAccount creates a ServiceAccount for each Userland and uses it in the pod.
+	// +optional
+	ServiceAccount *UserServiceAccountSpec `json:"serviceAccount,omitempty" protobuf:"bytes,5,opt,name=serviceAccount"`
+
+	//BaseTemplate is the name of a Template in the same namespace which this Template extends.
+	//When it is set, the effective spec is the effective spec of the base Template with Patches applied in order,
+	//and the other fields of this spec are ignored except AllowedSubjects.
+	// +optional
+	BaseTemplate string `json:"baseTemplate,omitempty" protobuf:"bytes,6,opt,name=baseTemplate"`
+
+	//Patches are applied in order to the spec of the base Template.
+	// +optional
+	Patches []TemplatePatch `json:"patches,omitempty" protobuf:"bytes,7,rep,name=patches"`
+
+	//DisableConfigHash stops rolling the pods of Userlands when ConfigMaps or Secrets referenced by the pod template are changed.
+	// +optional
+	DisableConfigHash bool `json:"disableConfigHash,omitempty" protobuf:"varint,8,opt,name=disableConfigHash"`
+
+	//PrePull maintains a DaemonSet which pulls the images of the pod template on the nodes where Userlands can run,
+	//so that new Userlands start without waiting for the images. The images must contain sh.
+	// +optional
+	PrePull bool `json:"prePull,omitempty" protobuf:"varint,9,opt,name=prePull"`
+
+	//WarmPool keeps pods of this Template running, which are claimed by Userlands while their own pods are starting.
+	// +optional
+	WarmPool *WarmPoolSpec `json:"warmPool,omitempty" protobuf:"bytes,10,opt,name=warmPool"`
+
+	//Certificate creates a cert-manager Certificate for each Userland. cert-manager must be installed.
+	//The certificate is stored in a Secret of the same name, whose name is in status.resourceNames.certificate.
+	// +optional
+	Certificate *CertificateSpec `json:"certificate,omitempty" protobuf:"bytes,11,opt,name=certificate"`
+
+	//Route creates a Gateway API HTTPRoute to the Service of each Userland. The Gateway API CRDs must be installed.
+	// +optional
+	Route *RouteSpec `json:"route,omitempty" protobuf:"bytes,12,opt,name=route"`
+
+	//Migration copies the data of a Userland from the volumes of its previous Template when templateName is changed to this Template.
+	//The Deployment of the Userland is held until the copy is completed.
+	// +optional
+	Migration *MigrationSpec `json:"migration,omitempty" protobuf:"bytes,13,opt,name=migration"`
+
+	//Sizes are the presets of the resources of Userlands, selected by spec.size of a Userland.
+	// +optional
+	Sizes []SizePreset `json:"sizes,omitempty" protobuf:"bytes,14,rep,name=sizes"`
+
+	// AllowedSubjects are the users, groups and service accounts allowed to create Userlands of this Template.
+	// The namespace of a service account defaults to the namespace of the Template. All users are allowed if it is empty.
+	// When BaseTemplate is set, it replaces the allowed subjects of the base Template if it is not empty,
+	// otherwise those of the base Template are inherited.
+	// +optional
+	AllowedSubjects []rbacv1.Subject `json:"allowedSubjects,omitempty" protobuf:"bytes,15,rep,name=allowedSubjects"`
+
+	//Bootstrap restricts spec.bootstrap of the Userlands of this Template.
+	// +optional
+	Bootstrap *BootstrapPolicy `json:"bootstrap,omitempty" protobuf:"bytes,16,opt,name=bootstrap"`
+}
+
+// BootstrapPolicy defines how the volumes of the Userlands of a Template are bootstrapped.
+// It is set by the author of the Template, because the bootstrap container runs with the credentials
+// and in the namespace of the pod.
+type BootstrapPolicy struct {
+	// Image of the init container which clones the repositories. It must contain git and sh.
+	// Default alpine/git.
+	// +optional
+	Image string `json:"image,omitempty" protobuf:"bytes,1,opt,name=image"`
+
+	// AllowedCredentialsSecrets are the names of the Secrets which Userlands can use in credentialsSecretName.
+	// No Secret can be used if it is empty.
+	// +optional
+	AllowedCredentialsSecrets []string `json:"allowedCredentialsSecrets,omitempty" protobuf:"bytes,2,rep,name=allowedCredentialsSecrets"`
+}
+
+// TemplateConditionType is a valid value for TemplateCondition.Type
+type TemplateConditionType string
+
+// These are valid conditions of a Template.
+const (
+	// TemplateResolved means the effective spec of the Template is resolved from its base Templates.
+	TemplateResolved TemplateConditionType = "Resolved"
+)
+
+// TemplateCondition describes the state of a Template at a certain point.
+type TemplateCondition struct {
+	// Type of Template condition.
+	Type TemplateConditionType `json:"type" protobuf:"bytes,1,opt,name=type,casttype=TemplateConditionType"`
+
+	// Status of the condition, one of True, False, Unknown.
+	Status v1.ConditionStatus `json:"status" protobuf:"bytes,2,opt,name=status,casttype=k8s.io/api/core/v1.ConditionStatus"`
+
+	// Last time the condition transitioned from one status to another.
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty" protobuf:"bytes,3,opt,name=lastTransitionTime"`
+
+	// The reason for the condition's last transition.
+	// +optional
+	Reason string `json:"reason,omitempty" protobuf:"bytes,4,opt,name=reason"`
+
+	// A human readable message indicating details about the transition.
+	// +optional
+	Message string `json:"message,omitempty" protobuf:"bytes,5,opt,name=message"`
 }
 
 // TemplateStatus defines the observed state of Template
 type TemplateStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Conditions represent the latest available observations of the Template's state.
+	// +optional
+	Conditions []TemplateCondition `json:"conditions,omitempty" protobuf:"bytes,1,rep,name=conditions"`
+
+	// Bases is the chain of base Templates, starting from the nearest one.
+	// +optional
+	Bases []string `json:"bases,omitempty" protobuf:"bytes,2,rep,name=bases"`
+
+	// ObservedGeneration is the most recent generation observed by the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty" protobuf:"varint,3,opt,name=observedGeneration"`
+
+	// WarmPoolReady is the number of ready pods in the warm pool.
+	// +optional
+	WarmPoolReady int32 `json:"warmPoolReady,omitempty" protobuf:"varint,4,opt,name=warmPoolReady"`
+
+	// AllowedSubjects are the subjects allowed to create Userlands of the Template, resolved from the base Templates.
+	// It is the group system:authenticated if all users are allowed.
+	// +optional
+	AllowedSubjects []rbacv1.Subject `json:"allowedSubjects,omitempty" protobuf:"bytes,5,rep,name=allowedSubjects"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:storageversion
+// +kubebuilder:subresource:status
 
 // Template is the Schema for the templates API
 type Template struct {
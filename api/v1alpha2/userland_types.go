@@ -16,13 +16,81 @@ limitations under the License.
 package v1alpha2
 
 import (
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// UserlandOwner identifies the user who owns a Userland.
+type UserlandOwner struct {
+	// User is the name of the user authenticated by the API server.
+	User string `json:"user" protobuf:"bytes,1,opt,name=user"`
+
+	// Groups are the groups of the user authenticated by the API server.
+	// +optional
+	Groups []string `json:"groups,omitempty" protobuf:"bytes,2,rep,name=groups"`
+}
+
+// CollaboratorKind is the kind of a collaborator of a Userland.
+type CollaboratorKind string
+
+// These are the valid kinds of collaborators.
+const (
+	// UserCollaborator is a user.
+	UserCollaborator CollaboratorKind = "User"
+	// GroupCollaborator is all the members of a group.
+	GroupCollaborator CollaboratorKind = "Group"
+)
+
+// Collaborator is a user or group who is allowed to use a Userland in addition to its owner.
+type Collaborator struct {
+	// Kind of the collaborator, one of User or Group.
+	// Default User.
+	// +kubebuilder:validation:Enum=User;Group
+	// +optional
+	Kind CollaboratorKind `json:"kind,omitempty" protobuf:"bytes,1,opt,name=kind,casttype=CollaboratorKind"`
+
+	// Name of the user or group.
+	Name string `json:"name" protobuf:"bytes,2,opt,name=name"`
+}
+
+// BootstrapSpec defines the content cloned into a volume of the Userland on its first start.
+type BootstrapSpec struct {
+	// Repository is the URL of a git repository cloned into the volume.
+	// +optional
+	Repository string `json:"repository,omitempty" protobuf:"bytes,1,opt,name=repository"`
+
+	// Branch of the repository.
+	// Default the default branch of the repository.
+	// +optional
+	Branch string `json:"branch,omitempty" protobuf:"bytes,2,opt,name=branch"`
+
+	// Path is the directory in the volume where the repository is cloned.
+	// Default the name of the repository.
+	// +optional
+	Path string `json:"path,omitempty" protobuf:"bytes,3,opt,name=path"`
+
+	// DotfilesRepository is the URL of a git repository cloned into .dotfiles in the volume.
+	// Its install.sh is run with HOME set to the volume if it exists, otherwise its dotfiles are linked from the volume.
+	// +optional
+	DotfilesRepository string `json:"dotfilesRepository,omitempty" protobuf:"bytes,4,opt,name=dotfilesRepository"`
+
+	// CredentialsSecretName is the name of a Secret in the namespace of the pod, used to clone the repositories.
+	// It has the keys username and password, or ssh-privatekey.
+	// It must be one of bootstrap.allowedCredentialsSecrets of the Template.
+	// +optional
+	CredentialsSecretName string `json:"credentialsSecretName,omitempty" protobuf:"bytes,5,opt,name=credentialsSecretName"`
+
+	// Volume is the name of the volume of the Template to bootstrap.
+	// Default the first volume of the Template.
+	// +optional
+	Volume string `json:"volume,omitempty" protobuf:"bytes,6,opt,name=volume"`
+}
+
 // UserlandSpec defines the desired state of Userland
 type UserlandSpec struct {
 
 	// Name is the name of this resource. It used to naming owned resources.
+	// Default is the name of the Userland.
 	// +optional
 	Name string `json:"Name,omitempty" protobuf:"bytes,1,opt,name=Name"`
 
@@ -33,16 +101,293 @@ type UserlandSpec struct {
 	// Default true.
 	// +optional
 	Enabled *bool `json:"enabled,omitempty" protobuf:"varint,3,opt,name=enabled"`
+
+	// TTLSecondsAfterCreation deletes the Userland when the seconds have passed since it was created.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TTLSecondsAfterCreation *int32 `json:"ttlSecondsAfterCreation,omitempty" protobuf:"varint,4,opt,name=ttlSecondsAfterCreation"`
+
+	// ExpiresAt deletes the Userland at the time.
+	// +optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty" protobuf:"bytes,5,opt,name=expiresAt"`
+
+	// DeleteAfterIdle deletes the Userland when it has been idle for the duration.
+	// The Userland is idle since the time in the esc.k06.in/last-activity annotation if it is set,
+	// otherwise since it has stopped running.
+	// +optional
+	DeleteAfterIdle *metav1.Duration `json:"deleteAfterIdle,omitempty" protobuf:"bytes,6,opt,name=deleteAfterIdle"`
+
+	// Bootstrap clones git repositories into a volume of the Userland on its first start.
+	// It runs until it succeeds once, which is recorded by a marker file .esc-bootstrapped in the volume.
+	// +optional
+	Bootstrap *BootstrapSpec `json:"bootstrap,omitempty" protobuf:"bytes,7,opt,name=bootstrap"`
+
+	// Size is the name of a size preset of the Template, which sets the resources of the pod and the storage of the volumes.
+	// Default the resources in the Template.
+	// +optional
+	Size string `json:"size,omitempty" protobuf:"bytes,8,opt,name=size"`
+
+	// Owner is the user who owns the Userland. It is set to the user who creates the Userland by the webhook,
+	// and can't be changed afterwards. Only trusted users can create Userlands on behalf of others.
+	// The user in the esc.k06.in/user label, or the name of the Userland, is the owner if it is not set.
+	// +optional
+	Owner *UserlandOwner `json:"owner,omitempty" protobuf:"bytes,9,opt,name=owner"`
+
+	// Collaborators are the users and groups allowed to use the Userland in addition to its owner.
+	// +optional
+	Collaborators []Collaborator `json:"collaborators,omitempty" protobuf:"bytes,10,rep,name=collaborators"`
+}
+
+// LastActivityAnnotation records the last time the Userland was used, in RFC3339.
+// It is set by the proxy and tools which observe the activity of users, and used by deleteAfterIdle.
+const LastActivityAnnotation = "esc.k06.in/last-activity"
+
+// UserlandPhase is a label for the condition of a Userland at the current time.
+type UserlandPhase string
+
+// These are the valid phases of a Userland.
+const (
+	// UserlandPending means the owned resources are created but no pod is available yet.
+	UserlandPending UserlandPhase = "Pending"
+	// UserlandRunning means the Userland has an available pod.
+	UserlandRunning UserlandPhase = "Running"
+	// UserlandSuspended means the Userland is disabled by Spec.Enabled.
+	UserlandSuspended UserlandPhase = "Suspended"
+)
+
+// UserlandConditionType is a valid value for UserlandCondition.Type
+type UserlandConditionType string
+
+// These are valid conditions of a Userland.
+const (
+	// UserlandReady means the Deployment of the Userland has an available pod.
+	UserlandReady UserlandConditionType = "Ready"
+	// UserlandExpiring means the Userland will be deleted soon by its TTL, expiresAt or deleteAfterIdle.
+	UserlandExpiring UserlandConditionType = "Expiring"
+	// UserlandCertificateReady means the cert-manager Certificate of the Userland is issued.
+	UserlandCertificateReady UserlandConditionType = "CertificateReady"
+	// UserlandRouteAccepted means the HTTPRoute of the Userland is accepted by all of its Gateways.
+	UserlandRouteAccepted UserlandConditionType = "RouteAccepted"
+	// UserlandBootstrapped means the volume of the Userland is bootstrapped by spec.bootstrap.
+	UserlandBootstrapped UserlandConditionType = "Bootstrapped"
+)
+
+// UserlandCondition describes the state of a Userland at a certain point.
+type UserlandCondition struct {
+	// Type of Userland condition.
+	Type UserlandConditionType `json:"type" protobuf:"bytes,1,opt,name=type,casttype=UserlandConditionType"`
+
+	// Status of the condition, one of True, False, Unknown.
+	Status v1.ConditionStatus `json:"status" protobuf:"bytes,2,opt,name=status,casttype=k8s.io/api/core/v1.ConditionStatus"`
+
+	// Last time the condition transitioned from one status to another.
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty" protobuf:"bytes,3,opt,name=lastTransitionTime"`
+
+	// The reason for the condition's last transition.
+	// +optional
+	Reason string `json:"reason,omitempty" protobuf:"bytes,4,opt,name=reason"`
+
+	// A human readable message indicating details about the transition.
+	// +optional
+	Message string `json:"message,omitempty" protobuf:"bytes,5,opt,name=message"`
+}
+
+// MigrationPhase is the phase of a migration.
+type MigrationPhase string
+
+// These are the valid phases of a migration.
+const (
+	// MigrationRunning means the Job which copies the data is running.
+	MigrationRunning MigrationPhase = "Running"
+	// MigrationSucceeded means all the data is copied.
+	MigrationSucceeded MigrationPhase = "Succeeded"
+	// MigrationFailed means the Job has failed, or the migration is not possible.
+	// The Userland is started without waiting for the data.
+	MigrationFailed MigrationPhase = "Failed"
+)
+
+// MigratedVolume is a copy from a PersistentVolumeClaim of the previous Template to the current one.
+type MigratedVolume struct {
+	// From is the name of the PersistentVolumeClaim copied from.
+	From string `json:"from" protobuf:"bytes,1,opt,name=from"`
+
+	// To is the name of the PersistentVolumeClaim copied to.
+	To string `json:"to" protobuf:"bytes,2,opt,name=to"`
+
+	// Copied is true when the copy is completed.
+	// +optional
+	Copied bool `json:"copied,omitempty" protobuf:"varint,3,opt,name=copied"`
+}
+
+// MigrationStatus is the state of the migration of the data of a Userland between Templates.
+type MigrationStatus struct {
+	// FromTemplate is the name of the previous Template.
+	FromTemplate string `json:"fromTemplate" protobuf:"bytes,1,opt,name=fromTemplate"`
+
+	// ToTemplate is the name of the Template migrated to.
+	ToTemplate string `json:"toTemplate" protobuf:"bytes,2,opt,name=toTemplate"`
+
+	// Phase of the migration.
+	Phase MigrationPhase `json:"phase" protobuf:"bytes,3,opt,name=phase,casttype=MigrationPhase"`
+
+	// Job is the name of the Job which copies the data. It is deleted when the migration is finished.
+	// +optional
+	Job string `json:"job,omitempty" protobuf:"bytes,4,opt,name=job"`
+
+	// Volumes are the copies of the migration.
+	// +optional
+	Volumes []MigratedVolume `json:"volumes,omitempty" protobuf:"bytes,5,rep,name=volumes"`
+
+	// StartTime is the time when the migration was started.
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty" protobuf:"bytes,6,opt,name=startTime"`
+
+	// CompletionTime is the time when the migration was finished.
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty" protobuf:"bytes,7,opt,name=completionTime"`
+
+	// Message is the reason of the failure.
+	// +optional
+	Message string `json:"message,omitempty" protobuf:"bytes,8,opt,name=message"`
+}
+
+// OrphanedVolume is a PersistentVolumeClaim owned by a Userland but no longer used by its Template.
+type OrphanedVolume struct {
+	// Name of the PersistentVolumeClaim.
+	Name string `json:"name" protobuf:"bytes,1,opt,name=name"`
+
+	// Namespace of the PersistentVolumeClaim.
+	Namespace string `json:"namespace" protobuf:"bytes,2,opt,name=namespace"`
+
+	// OrphanedAt is the time when the PersistentVolumeClaim was found to be unused.
+	OrphanedAt metav1.Time `json:"orphanedAt" protobuf:"bytes,3,opt,name=orphanedAt"`
+
+	// DeleteAfter is the time after which the PersistentVolumeClaim is deleted.
+	// It is not set if the PersistentVolumeClaim is retained by the annotation.
+	// +optional
+	DeleteAfter *metav1.Time `json:"deleteAfter,omitempty" protobuf:"bytes,4,opt,name=deleteAfter"`
+}
+
+// ResourceNames are the names of resources owned by a Userland.
+type ResourceNames struct {
+	// Deployment is the name of the Deployment.
+	// +optional
+	Deployment string `json:"deployment,omitempty" protobuf:"bytes,1,opt,name=deployment"`
+
+	// Service is the name of the Service.
+	// +optional
+	Service string `json:"service,omitempty" protobuf:"bytes,2,opt,name=service"`
+
+	// PersistentVolumeClaims maps the volume names of the Template to the names of PersistentVolumeClaims.
+	// +optional
+	PersistentVolumeClaims map[string]string `json:"persistentVolumeClaims,omitempty" protobuf:"bytes,3,rep,name=persistentVolumeClaims"`
+
+	// ServiceAccount is the name of the ServiceAccount.
+	// +optional
+	ServiceAccount string `json:"serviceAccount,omitempty" protobuf:"bytes,4,opt,name=serviceAccount"`
+
+	// Certificate is the name of the cert-manager Certificate and the Secret which stores it.
+	// +optional
+	Certificate string `json:"certificate,omitempty" protobuf:"bytes,5,opt,name=certificate"`
+
+	// Route is the name of the Gateway API HTTPRoute.
+	// +optional
+	Route string `json:"route,omitempty" protobuf:"bytes,6,opt,name=route"`
+}
+
+// ResourceUsage is the amount of resources consumed by a Userland.
+type ResourceUsage struct {
+	// RunningSeconds is the time while the Userland has been enabled.
+	// +optional
+	RunningSeconds int64 `json:"runningSeconds,omitempty" protobuf:"varint,1,opt,name=runningSeconds"`
+
+	// CPUMillicoreSeconds is the requested CPU in millicores multiplied by the running time.
+	// +optional
+	CPUMillicoreSeconds int64 `json:"cpuMillicoreSeconds,omitempty" protobuf:"varint,2,opt,name=cpuMillicoreSeconds"`
+
+	// MemoryMebibyteSeconds is the requested memory in mebibytes multiplied by the running time.
+	// +optional
+	MemoryMebibyteSeconds int64 `json:"memoryMebibyteSeconds,omitempty" protobuf:"varint,3,opt,name=memoryMebibyteSeconds"`
+}
+
+// DailyUsage is the amount of resources consumed by a Userland in a day.
+type DailyUsage struct {
+	// Date is the day in UTC, formatted as YYYY-MM-DD.
+	Date string `json:"date" protobuf:"bytes,1,opt,name=date"`
+
+	// Usage is the amount of resources consumed in the day.
+	Usage ResourceUsage `json:"usage" protobuf:"bytes,2,opt,name=usage"`
+}
+
+// UserlandUsage is the accounting of resources consumed by a Userland.
+type UserlandUsage struct {
+	// Total is the amount of resources consumed since the Userland was created.
+	// +optional
+	Total ResourceUsage `json:"total,omitempty" protobuf:"bytes,1,opt,name=total"`
+
+	// Daily is the amount of resources consumed in each of the recent days.
+	// +optional
+	Daily []DailyUsage `json:"daily,omitempty" protobuf:"bytes,2,rep,name=daily"`
+
+	// AccountedUntil is the time until which the usage is accounted while the Userland is running.
+	// It is not set while the Userland is disabled.
+	// +optional
+	AccountedUntil *metav1.Time `json:"accountedUntil,omitempty" protobuf:"bytes,3,opt,name=accountedUntil"`
 }
 
 // UserlandStatus defines the observed state of Userland
 type UserlandStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Phase is a simple, high-level summary of where the Userland is in its lifecycle.
+	// +optional
+	Phase UserlandPhase `json:"phase,omitempty" protobuf:"bytes,1,opt,name=phase,casttype=UserlandPhase"`
+
+	// Conditions represent the latest available observations of the Userland's state.
+	// +optional
+	Conditions []UserlandCondition `json:"conditions,omitempty" protobuf:"bytes,2,rep,name=conditions"`
+
+	// ObservedGeneration is the most recent generation observed by the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty" protobuf:"varint,3,opt,name=observedGeneration"`
+
+	// Namespace is the namespace where owned resources are placed.
+	// +optional
+	Namespace string `json:"namespace,omitempty" protobuf:"bytes,4,opt,name=namespace"`
+
+	// OrphanedVolumes are PersistentVolumeClaims which are waiting to be deleted.
+	// +optional
+	OrphanedVolumes []OrphanedVolume `json:"orphanedVolumes,omitempty" protobuf:"bytes,5,rep,name=orphanedVolumes"`
+
+	// ResourceNames are the names of resources owned by the Userland.
+	// +optional
+	ResourceNames ResourceNames `json:"resourceNames,omitempty" protobuf:"bytes,6,opt,name=resourceNames"`
+
+	// ExpiresAt is the time when the Userland will be deleted by its TTL, expiresAt or deleteAfterIdle.
+	// +optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty" protobuf:"bytes,7,opt,name=expiresAt"`
+
+	// Usage is the accounting of resources consumed by the Userland.
+	// +optional
+	Usage UserlandUsage `json:"usage,omitempty" protobuf:"bytes,8,opt,name=usage"`
+
+	// TemplateName is the name of the Template which the owned resources are rendered from.
+	// It differs from spec.templateName while the data is migrated from the previous Template.
+	// +optional
+	TemplateName string `json:"templateName,omitempty" protobuf:"bytes,9,opt,name=templateName"`
+
+	// Migration is the state of the last migration of the data between Templates.
+	// +optional
+	Migration *MigrationStatus `json:"migration,omitempty" protobuf:"bytes,10,opt,name=migration"`
+
+	// QuotaUsage is the usage of the Userland counted by UserlandQuotas. requests.cpu and requests.memory of
+	// the pod are counted while the Userland is allowed to run.
+	// +optional
+	QuotaUsage v1.ResourceList `json:"quotaUsage,omitempty" protobuf:"bytes,11,rep,name=quotaUsage,casttype=ResourceList,castkey=ResourceName"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:storageversion
+// +kubebuilder:subresource:status
 
 // Userland is the Schema for the userlands API
 type Userland struct {
@@ -0,0 +1,117 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// These are the resources limited by a UserlandQuota in addition to
+// requests.cpu, requests.memory and requests.storage.
+const (
+	// ResourceUserlands is the number of Userlands.
+	ResourceUserlands v1.ResourceName = "userlands"
+	// ResourceRunning is the number of Userlands which are allowed to run their pods.
+	ResourceRunning v1.ResourceName = "running"
+)
+
+// QuotaScope is the scope which the limits of a UserlandQuota apply to.
+type QuotaScope string
+
+// These are the valid scopes of UserlandQuotas.
+const (
+	// NamespaceQuotaScope applies the limits to all the selected Userlands together.
+	NamespaceQuotaScope QuotaScope = "Namespace"
+	// UserQuotaScope applies the limits to the selected Userlands of each user separately.
+	UserQuotaScope QuotaScope = "User"
+)
+
+// UserlandQuotaSpec defines the desired state of UserlandQuota
+type UserlandQuotaSpec struct {
+	// Hard is the set of limits of the selected Userlands: userlands, running, requests.cpu, requests.memory and requests.storage.
+	// requests.cpu and requests.memory are counted for the running Userlands, and requests.storage for all the Userlands.
+	Hard v1.ResourceList `json:"hard" protobuf:"bytes,1,rep,name=hard,casttype=ResourceList,castkey=ResourceName"`
+
+	// Selector is a label query over the Userlands in the namespace. All the Userlands in the namespace are
+	// selected if it is empty. The labels are set by the creators of the Userlands, who can remove a Userland
+	// from the quota by changing them, so the selector is not a security boundary. Use Groups to limit a group.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty" protobuf:"bytes,2,opt,name=selector"`
+
+	// Scope of the limits, one of Namespace or User. User applies the limits to the Userlands of each owner,
+	// who is spec.owner.user, or the user in the esc.k06.in/user label or the name of the Userland.
+	// Default Namespace.
+	// +kubebuilder:validation:Enum=Namespace;User
+	// +optional
+	Scope QuotaScope `json:"scope,omitempty" protobuf:"bytes,3,opt,name=scope,casttype=QuotaScope"`
+
+	// Groups selects the Userlands whose owner is a member of one of the groups in spec.owner.groups,
+	// which is set and protected by the webhook. It is ANDed with Selector.
+	// Userlands without spec.owner, e.g. those created before the owner was introduced, are not selected.
+	// +optional
+	Groups []string `json:"groups,omitempty" protobuf:"bytes,4,rep,name=groups"`
+}
+
+// UserQuotaUsage is the usage of the Userlands of a user.
+type UserQuotaUsage struct {
+	// User is the name of the user.
+	User string `json:"user" protobuf:"bytes,1,opt,name=user"`
+
+	// Used is the current usage of the Userlands of the user.
+	// +optional
+	Used v1.ResourceList `json:"used,omitempty" protobuf:"bytes,2,rep,name=used,casttype=ResourceList,castkey=ResourceName"`
+}
+
+// UserlandQuotaStatus defines the observed state of UserlandQuota
+type UserlandQuotaStatus struct {
+	// Used is the current usage of all the selected Userlands.
+	// +optional
+	Used v1.ResourceList `json:"used,omitempty" protobuf:"bytes,1,rep,name=used,casttype=ResourceList,castkey=ResourceName"`
+
+	// Users is the current usage of each user, which is set when the scope is User.
+	// +optional
+	Users []UserQuotaUsage `json:"users,omitempty" protobuf:"bytes,2,rep,name=users"`
+
+	// ObservedGeneration is the most recent generation observed by the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty" protobuf:"varint,3,opt,name=observedGeneration"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// UserlandQuota is the Schema for the userlandquotas API
+type UserlandQuota struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   UserlandQuotaSpec   `json:"spec,omitempty"`
+	Status UserlandQuotaStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// UserlandQuotaList contains a list of UserlandQuota
+type UserlandQuotaList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []UserlandQuota `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&UserlandQuota{}, &UserlandQuotaList{})
+}
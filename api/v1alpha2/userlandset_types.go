@@ -0,0 +1,116 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// UserRegistry selects users from a ConfigMap.
+// Each key of the ConfigMap data is a user name, and each value is a comma separated
+// list of labels for the user (e.g. "team=infra,cohort=2021-04").
+type UserRegistry struct {
+	// ConfigMapName is the name of a ConfigMap in the same namespace as this resource.
+	ConfigMapName string `json:"configMapName" protobuf:"bytes,1,opt,name=configMapName"`
+
+	// Selector is a label query over users in the registry.
+	// All users in the registry are selected if it is empty.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty" protobuf:"bytes,2,opt,name=selector"`
+}
+
+// UserlandOverrides defines the values shared by all Userlands owned by a UserlandSet.
+type UserlandOverrides struct {
+	// Labels are added to each Userland.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty" protobuf:"bytes,1,rep,name=labels"`
+
+	// Annotations are added to each Userland.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty" protobuf:"bytes,2,rep,name=annotations"`
+
+	// Enabled to create pod from each Userland.
+	// Default true.
+	// +optional
+	Enabled *bool `json:"enabled,omitempty" protobuf:"varint,3,opt,name=enabled"`
+}
+
+// UserlandSetSpec defines the desired state of UserlandSet
+type UserlandSetSpec struct {
+	// TemplateName is the name of a Template used by each Userland.
+	TemplateName string `json:"templateName" protobuf:"bytes,1,opt,name=templateName"`
+
+	// Users is the list of user names. A Userland is created for each user.
+	// +optional
+	Users []string `json:"users,omitempty" protobuf:"bytes,2,rep,name=users"`
+
+	// UserRegistry selects additional users from a ConfigMap.
+	// +optional
+	UserRegistry *UserRegistry `json:"userRegistry,omitempty" protobuf:"bytes,3,opt,name=userRegistry"`
+
+	// Overrides are applied to each Userland owned by this resource.
+	// +optional
+	Overrides UserlandOverrides `json:"overrides,omitempty" protobuf:"bytes,4,opt,name=overrides"`
+}
+
+// UserlandSetStatus defines the observed state of UserlandSet
+type UserlandSetStatus struct {
+	// Replicas is the number of Userlands owned by this resource.
+	// +optional
+	Replicas int32 `json:"replicas,omitempty" protobuf:"varint,1,opt,name=replicas"`
+
+	// ReadyReplicas is the number of Userlands which have the Ready condition.
+	// +optional
+	ReadyReplicas int32 `json:"readyReplicas,omitempty" protobuf:"varint,2,opt,name=readyReplicas"`
+
+	// NotReadyUsers is the list of users whose Userland is not ready.
+	// +optional
+	NotReadyUsers []string `json:"notReadyUsers,omitempty" protobuf:"bytes,3,rep,name=notReadyUsers"`
+
+	// ObservedGeneration is the most recent generation observed by the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty" protobuf:"varint,4,opt,name=observedGeneration"`
+
+	// ExpiredUsers is the list of users whose Userland has been deleted by its expiry.
+	// Their Userlands are not recreated until they are removed from the users of this resource.
+	// +optional
+	ExpiredUsers []string `json:"expiredUsers,omitempty" protobuf:"bytes,5,rep,name=expiredUsers"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// UserlandSet is the Schema for the userlandsets API
+type UserlandSet struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   UserlandSetSpec   `json:"spec,omitempty"`
+	Status UserlandSetStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// UserlandSetList contains a list of UserlandSet
+type UserlandSetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []UserlandSet `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&UserlandSet{}, &UserlandSetList{})
+}
@@ -0,0 +1,192 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/types"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/naming"
+	"github.com/koba1t/ESC/pkg/render"
+)
+
+// stringsFlag is a flag which can be repeated.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// runRender prints the resources rendered for each Userland as YAML, without connecting to a cluster.
+func runRender(args []string, out io.Writer) error {
+	var templateFiles, userlandFiles stringsFlag
+	var namespace, namingPattern string
+
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	fs.Var(&templateFiles, "t", "A file of Templates, including their base Templates. Can be repeated.")
+	fs.Var(&userlandFiles, "u", "A file of Userlands to render. Can be repeated.")
+	fs.StringVar(&namespace, "n", "default", "The namespace of Templates and Userlands which don't have it.")
+	fs.StringVar(&namingPattern, "naming-pattern", naming.DefaultPattern,
+		"The pattern of names of resources owned by Userlands. It must match the flag of the manager.")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: esc render -t template.yaml -u userland.yaml [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(templateFiles) == 0 || len(userlandFiles) == 0 {
+		fs.Usage()
+		return errors.New("both -t and -u are required")
+	}
+
+	// Load Templates into a fake client, so that base Templates are resolved in the same way as in the cluster
+	var templates []runtime.Object
+	for _, file := range templateFiles {
+		objs, err := readObjects(file)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objs {
+			template, ok := obj.(*escv1alpha2.Template)
+			if !ok {
+				return fmt.Errorf("%s: expected Template, got %T", file, obj)
+			}
+			if template.Namespace == "" {
+				template.Namespace = namespace
+			}
+			templates = append(templates, template)
+		}
+	}
+	c := fake.NewFakeClientWithScheme(scheme, templates...)
+
+	var userlands []*escv1alpha2.Userland
+	for _, file := range userlandFiles {
+		objs, err := readObjects(file)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objs {
+			userland, ok := obj.(*escv1alpha2.Userland)
+			if !ok {
+				return fmt.Errorf("%s: expected Userland, got %T", file, obj)
+			}
+			if userland.Namespace == "" {
+				userland.Namespace = namespace
+			}
+			userlands = append(userlands, userland)
+		}
+	}
+
+	ctx := context.Background()
+	namer := naming.Namer{Pattern: namingPattern}
+	for _, userland := range userlands {
+		var template escv1alpha2.Template
+		if err := c.Get(ctx, types.NamespacedName{Namespace: userland.Namespace, Name: userland.Spec.TemplateName}, &template); err != nil {
+			return fmt.Errorf("userland %q: %v", userland.Name, err)
+		}
+
+		spec, _, err := render.ResolveTemplate(ctx, c, &template)
+		if err != nil {
+			return fmt.Errorf("userland %q: template %q: %v", userland.Name, template.Name, err)
+		}
+
+		objects, err := render.Render(userland, spec, namer)
+		if err != nil {
+			return fmt.Errorf("userland %q: %v", userland.Name, err)
+		}
+
+		for _, obj := range objects.List() {
+			if err := writeObject(out, obj); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readObjects decodes the objects in a YAML file which may contain multiple documents.
+func readObjects(file string) ([]runtime.Object, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	decoder := serializer.NewCodecFactory(scheme).UniversalDeserializer()
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(f))
+
+	var objs []runtime.Object
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+
+		obj, _, err := decoder.Decode(doc, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		objs = append(objs, obj)
+	}
+	return objs, nil
+}
+
+// writeObject writes the object as a YAML document.
+func writeObject(out io.Writer, obj runtime.Object) error {
+	gvk, err := apiutil.GVKForObject(obj, scheme)
+	if err != nil {
+		return err
+	}
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(out, "---\n%s", data); err != nil {
+		return err
+	}
+	return nil
+}
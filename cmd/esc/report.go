@@ -0,0 +1,172 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/access"
+	"github.com/koba1t/ESC/pkg/accounting"
+)
+
+// runReport prints the resources consumed by each owner in the date range as CSV.
+// The usage is read from the usage records, which are kept after Userlands are deleted, and from the status of
+// the Userlands in the cluster of the current kubeconfig for the days recorded before the usage records were written.
+func runReport(args []string, out io.Writer) error {
+	now := time.Now().UTC()
+	var namespace, from, to string
+
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	fs.StringVar(&namespace, "n", "", "The namespace of Userlands. All namespaces if it is empty.")
+	fs.StringVar(&from, "from", now.AddDate(0, 0, 1-now.Day()).Format(accounting.DateFormat), "The first day of the report in UTC, formatted as YYYY-MM-DD.")
+	fs.StringVar(&to, "to", now.Format(accounting.DateFormat), "The last day of the report in UTC, formatted as YYYY-MM-DD.")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: esc report [--from YYYY-MM-DD] [--to YYYY-MM-DD] [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	for _, date := range []string{from, to} {
+		if _, err := time.Parse(accounting.DateFormat, date); err != nil {
+			return fmt.Errorf("invalid date %q: %v", date, err)
+		}
+	}
+
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		return err
+	}
+	c, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var configMaps corev1.ConfigMapList
+	recorded, err := labels.Parse(accounting.Label)
+	if err != nil {
+		return err
+	}
+	if err := c.List(ctx, &configMaps, client.InNamespace(namespace), client.MatchingLabelsSelector{Selector: recorded}); err != nil {
+		return err
+	}
+	var userlands escv1alpha2.UserlandList
+	if err := c.List(ctx, &userlands, client.InNamespace(namespace)); err != nil {
+		return err
+	}
+
+	return writeReport(out, report(configMaps.Items, userlands.Items, from, to))
+}
+
+// reportKey is the unit of a report.
+type reportKey struct {
+	namespace, owner, template string
+}
+
+// report sums the usage in the date range by the namespace, the owner and the Template.
+func report(configMaps []corev1.ConfigMap, userlands []escv1alpha2.Userland, from, to string) map[reportKey]*escv1alpha2.ResourceUsage {
+	totals := map[reportKey]*escv1alpha2.ResourceUsage{}
+	add := func(key reportKey, u escv1alpha2.ResourceUsage) {
+		total, ok := totals[key]
+		if !ok {
+			total = &escv1alpha2.ResourceUsage{}
+			totals[key] = total
+		}
+		total.RunningSeconds += u.RunningSeconds
+		total.CPUMillicoreSeconds += u.CPUMillicoreSeconds
+		total.MemoryMebibyteSeconds += u.MemoryMebibyteSeconds
+	}
+
+	// dates in the same format are ordered as strings
+	inRange := func(date string) bool { return date >= from && date <= to }
+
+	recorded := map[string]bool{}
+	for i := range configMaps {
+		cm := &configMaps[i]
+		date := cm.Labels[accounting.Label]
+		if !inRange(date) {
+			continue
+		}
+		for _, record := range accounting.Records(cm) {
+			recorded[cm.Namespace+"/"+record.UID+"/"+date] = true
+			add(reportKey{cm.Namespace, record.Owner, record.Template}, record.Usage)
+		}
+	}
+
+	for i := range userlands {
+		userland := &userlands[i]
+		for _, daily := range userland.Status.Usage.Daily {
+			if !inRange(daily.Date) || recorded[userland.Namespace+"/"+string(userland.UID)+"/"+daily.Date] {
+				continue
+			}
+			add(reportKey{userland.Namespace, access.Owner(userland), userland.Spec.TemplateName}, daily.Usage)
+		}
+	}
+	return totals
+}
+
+// writeReport writes the report as CSV, ordered by the namespace, the owner and the Template.
+func writeReport(out io.Writer, totals map[reportKey]*escv1alpha2.ResourceUsage) error {
+	keys := make([]reportKey, 0, len(totals))
+	for key := range totals {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.namespace != b.namespace {
+			return a.namespace < b.namespace
+		}
+		if a.owner != b.owner {
+			return a.owner < b.owner
+		}
+		return a.template < b.template
+	})
+
+	w := csv.NewWriter(out)
+	w.Write([]string{"namespace", "owner", "template", "running_hours", "cpu_core_hours", "memory_gib_hours"})
+	for _, key := range keys {
+		total := totals[key]
+		w.Write([]string{
+			key.namespace,
+			key.owner,
+			key.template,
+			formatHours(float64(total.RunningSeconds)),
+			formatHours(float64(total.CPUMillicoreSeconds) / 1000),
+			formatHours(float64(total.MemoryMebibyteSeconds) / 1024),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// formatHours formats seconds as hours.
+func formatHours(seconds float64) string {
+	return strconv.FormatFloat(seconds/3600, 'f', 2, 64)
+}
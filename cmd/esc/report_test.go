@@ -0,0 +1,64 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/accounting"
+)
+
+func TestReport(t *testing.T) {
+	hour := escv1alpha2.ResourceUsage{RunningSeconds: 3600}
+	record := func(uid, owner string) string {
+		value, _ := json.Marshal(accounting.Record{Userland: "x", UID: uid, Owner: owner, Template: "vscode", Usage: hour})
+		return string(value)
+	}
+	configMap := func(date string, data map[string]string) corev1.ConfigMap {
+		return corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "esc", Name: accounting.ConfigMapName(date), Labels: map[string]string{accounting.Label: date}},
+			Data:       data,
+		}
+	}
+
+	configMaps := []corev1.ConfigMap{
+		// "deleted" is no longer in the cluster
+		configMap("2021-01-01", map[string]string{"deleted.1": record("1", "alice"), "live.2": record("2", "bob")}),
+		configMap("2021-02-01", map[string]string{"deleted.1": record("1", "alice")}),
+	}
+	userlands := []escv1alpha2.Userland{{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "esc", Name: "live", UID: "2"},
+		Spec:       escv1alpha2.UserlandSpec{TemplateName: "vscode", Owner: &escv1alpha2.UserlandOwner{User: "bob"}},
+		Status: escv1alpha2.UserlandStatus{Usage: escv1alpha2.UserlandUsage{Daily: []escv1alpha2.DailyUsage{
+			{Date: "2020-12-31", Usage: hour},
+			{Date: "2021-01-01", Usage: hour},
+		}}},
+	}}
+
+	totals := report(configMaps, userlands, "2020-12-01", "2021-01-31")
+	if got := totals[reportKey{"esc", "alice", "vscode"}]; got == nil || got.RunningSeconds != 3600 {
+		t.Errorf("usage of the deleted userland = %+v, want an hour", got)
+	}
+	// the day recorded in the ConfigMap is not counted twice
+	if got := totals[reportKey{"esc", "bob", "vscode"}]; got == nil || got.RunningSeconds != 7200 {
+		t.Errorf("usage of the live userland = %+v, want two hours", got)
+	}
+}
@@ -0,0 +1,133 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+)
+
+// fieldManager is the field manager of resources applied by the controller.
+const fieldManager = "esc"
+
+// getOwned gets the existing object of obj into current, which is left empty if it doesn't exist.
+// It returns an error if the object exists and is not owned by the Userland.
+func (r *UserlandReconciler) getOwned(ctx context.Context, userland *escv1alpha2.Userland, obj, current runtime.Object) error {
+	key, err := client.ObjectKeyFromObject(obj)
+	if err != nil {
+		return err
+	}
+	if err := r.Get(ctx, key, current); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	accessor, err := meta.Accessor(current)
+	if err != nil {
+		return err
+	}
+	return r.checkOwner(userland, accessor)
+}
+
+// apply creates or updates obj owned by the Userland with server-side apply.
+// Only the fields set in obj are asserted, so that fields set by other controllers and
+// mutating webhooks (e.g. injected sidecars) are kept. obj is updated with the result.
+func (r *UserlandReconciler) apply(ctx context.Context, userland *escv1alpha2.Userland, obj runtime.Object) error {
+	gvk, err := apiutil.GVKForObject(obj, r.Scheme)
+	if err != nil {
+		return err
+	}
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	if err := r.setOwner(userland, accessor); err != nil {
+		return err
+	}
+
+	return r.Patch(ctx, obj, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership)
+}
+
+// applyUnstructured applies obj of a kind defined by an optional CRD, such as cert-manager Certificates.
+// The object of the kind named previousName in the namespace of the Userland status is deleted
+// when it differs from obj, and obj may be nil to only delete it.
+// It returns false if the kind is not served by the cluster.
+func (r *UserlandReconciler) applyUnstructured(ctx context.Context, log logr.Logger, userland *escv1alpha2.Userland, gvk schema.GroupVersionKind, previousName string, obj *unstructured.Unstructured) (bool, error) {
+	if previousName != "" && (obj == nil || obj.GetName() != previousName || obj.GetNamespace() != userland.Status.Namespace) {
+		previous := &unstructured.Unstructured{}
+		previous.SetGroupVersionKind(gvk)
+		previous.SetName(previousName)
+		previous.SetNamespace(userland.Status.Namespace)
+		err := r.Delete(ctx, previous)
+		switch {
+		case err == nil:
+			log.Info("delete " + gvk.Kind + " resource: " + previousName)
+		case meta.IsNoMatchError(err):
+			return false, nil
+		case client.IgnoreNotFound(err) != nil:
+			return true, err
+		}
+	}
+	if obj == nil {
+		return true, nil
+	}
+
+	current := &unstructured.Unstructured{}
+	current.SetGroupVersionKind(gvk)
+	if err := r.getOwned(ctx, userland, obj, current); err != nil {
+		return !meta.IsNoMatchError(err), client.IgnoreNotFound(ignoreNoMatch(err))
+	}
+	if err := r.apply(ctx, userland, obj); err != nil {
+		return !meta.IsNoMatchError(err), ignoreNoMatch(err)
+	}
+	return true, nil
+}
+
+func ignoreNoMatch(err error) error {
+	if meta.IsNoMatchError(err) {
+		return nil
+	}
+	return err
+}
+
+// ownsIfInstalled watches the kind defined by an optional CRD as owned by Userlands if it is served by the cluster,
+// because the controller fails to start watching a kind which is not served.
+func (r *UserlandReconciler) ownsIfInstalled(mgr ctrl.Manager, b *builder.Builder, gvk schema.GroupVersionKind) *builder.Builder {
+	if _, err := mgr.GetRESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+		r.Log.Info(gvk.Kind + " is not installed, it is not watched until the manager is restarted")
+		return b
+	}
+
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	// Resources in the dedicated namespace of a Userland are watched by labels instead of ownerReferences.
+	return b.Owns(obj).
+		Watches(&source.Kind{Type: obj}, &handler.EnqueueRequestsFromMapFunc{ToRequests: mapLabelledToUserland})
+}
@@ -0,0 +1,89 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+)
+
+var _ = Describe("Server-side apply", func() {
+	ctx := context.Background()
+
+	It("keeps the fields set by other managers", func() {
+		namespace := createNamespace()
+		Expect(k8sClient.Create(ctx, newTemplate(namespace, "vscode"))).To(Succeed())
+		Expect(k8sClient.Create(ctx, newUserland(namespace, "koba1t", "vscode"))).To(Succeed())
+		key := types.NamespacedName{Namespace: namespace, Name: "koba1t"}
+
+		var names escv1alpha2.ResourceNames
+		Eventually(func() string {
+			var current escv1alpha2.Userland
+			Expect(k8sClient.Get(ctx, key, &current)).To(Succeed())
+			names = current.Status.ResourceNames
+			return names.Service
+		}, timeout, interval).ShouldNot(BeEmpty())
+		deployKey := types.NamespacedName{Namespace: namespace, Name: names.Deployment}
+		serviceKey := types.NamespacedName{Namespace: namespace, Name: names.Service}
+
+		By("changing the resources by another manager")
+		var deploy appsv1.Deployment
+		Expect(k8sClient.Get(ctx, deployKey, &deploy)).To(Succeed())
+		patch := client.MergeFrom(deploy.DeepCopy())
+		deploy.Annotations = map[string]string{"example.com/note": "kept"}
+		deploy.Spec.Template.Spec.Containers = append(deploy.Spec.Template.Spec.Containers, corev1.Container{Name: "sidecar", Image: "example.com/sidecar"})
+		Expect(k8sClient.Patch(ctx, &deploy, patch, client.FieldOwner("other"))).To(Succeed())
+		revisionHistoryLimit := deploy.Spec.RevisionHistoryLimit
+
+		var service corev1.Service
+		Expect(k8sClient.Get(ctx, serviceKey, &service)).To(Succeed())
+		patch = client.MergeFrom(service.DeepCopy())
+		service.Annotations = map[string]string{"example.com/note": "kept"}
+		Expect(k8sClient.Patch(ctx, &service, patch, client.FieldOwner("other"))).To(Succeed())
+		clusterIP := service.Spec.ClusterIP
+
+		By("applying the resources again")
+		Eventually(func() error {
+			var current escv1alpha2.Userland
+			if err := k8sClient.Get(ctx, key, &current); err != nil {
+				return err
+			}
+			enabled := false
+			current.Spec.Enabled = &enabled
+			return k8sClient.Update(ctx, &current)
+		}, timeout, interval).Should(Succeed())
+
+		Eventually(func() int32 {
+			Expect(k8sClient.Get(ctx, deployKey, &deploy)).To(Succeed())
+			return *deploy.Spec.Replicas
+		}, timeout, interval).Should(BeZero())
+		Expect(deploy.Annotations).To(HaveKeyWithValue("example.com/note", "kept"))
+		Expect(deploy.Spec.Template.Spec.Containers).To(ContainElement(WithTransform(func(c corev1.Container) string { return c.Name }, Equal("sidecar"))))
+		Expect(deploy.Spec.RevisionHistoryLimit).To(Equal(revisionHistoryLimit))
+
+		Expect(k8sClient.Get(ctx, serviceKey, &service)).To(Succeed())
+		Expect(service.Annotations).To(HaveKeyWithValue("example.com/note", "kept"))
+		Expect(service.Spec.ClusterIP).To(Equal(clusterIP))
+	})
+})
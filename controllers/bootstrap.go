@@ -0,0 +1,91 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/render"
+)
+
+// bootstrapRequeueAfter is the interval to check the bootstrap container while it is running,
+// because the pods of Deployments are not watched.
+const bootstrapRequeueAfter = 30 * time.Second
+
+// maxConditionMessageLength limits the output of the bootstrap container copied to the condition.
+const maxConditionMessageLength = 1024
+
+// reconcileBootstrap sets the Bootstrapped condition of the Userland from the bootstrap init container of its pods.
+// It returns the duration to check it again, or zero if it is not required.
+func (r *UserlandReconciler) reconcileBootstrap(ctx context.Context, userland *escv1alpha2.Userland, deploy *appsv1.Deployment) (time.Duration, error) {
+	if userland.Spec.Bootstrap == nil {
+		removeUserlandCondition(&userland.Status, escv1alpha2.UserlandBootstrapped)
+		return 0, nil
+	}
+	// the marker in the volume keeps it bootstrapped
+	if isUserlandConditionTrue(&userland.Status, escv1alpha2.UserlandBootstrapped) {
+		return 0, nil
+	}
+	if deploy.Spec.Replicas != nil && *deploy.Spec.Replicas == 0 {
+		setUserlandCondition(&userland.Status, escv1alpha2.UserlandBootstrapped, corev1.ConditionUnknown, "Disabled", "Userland is bootstrapped when it is enabled")
+		return 0, nil
+	}
+
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(deploy.Namespace), client.MatchingLabels(deploy.Spec.Selector.MatchLabels)); err != nil {
+		return 0, err
+	}
+
+	for _, pod := range pods.Items {
+		for _, status := range pod.Status.InitContainerStatuses {
+			if status.Name != render.BootstrapContainerName {
+				continue
+			}
+			terminated := status.State.Terminated
+			if terminated == nil && status.State.Waiting != nil {
+				// restarting after a failure
+				terminated = status.LastTerminationState.Terminated
+			}
+			switch {
+			case terminated == nil:
+			case terminated.ExitCode == 0:
+				setUserlandCondition(&userland.Status, escv1alpha2.UserlandBootstrapped, corev1.ConditionTrue, "Bootstrapped", "")
+				return 0, nil
+			default:
+				message := terminated.Message
+				if len(message) > maxConditionMessageLength {
+					message = message[len(message)-maxConditionMessageLength:]
+				}
+				if c := getUserlandCondition(&userland.Status, escv1alpha2.UserlandBootstrapped); c == nil || c.Reason != "BootstrapFailed" {
+					r.Recorder.Eventf(userland, corev1.EventTypeWarning, "BootstrapFailed", "Bootstrap of pod %q failed: %s", pod.Name, terminated.Reason)
+				}
+				setUserlandCondition(&userland.Status, escv1alpha2.UserlandBootstrapped, corev1.ConditionFalse, "BootstrapFailed", message)
+				return bootstrapRequeueAfter, nil
+			}
+		}
+	}
+
+	if c := getUserlandCondition(&userland.Status, escv1alpha2.UserlandBootstrapped); c == nil || c.Reason != "BootstrapFailed" {
+		setUserlandCondition(&userland.Status, escv1alpha2.UserlandBootstrapped, corev1.ConditionUnknown, "Pending", "Bootstrap container has not finished")
+	}
+	return bootstrapRequeueAfter, nil
+}
@@ -0,0 +1,80 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/render"
+)
+
+// +kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch;delete
+
+// reconcileCertificate applies the cert-manager Certificate of the Userland and sets the CertificateReady condition
+// from its Ready condition. The Certificate applied before is deleted when its name is changed or the Template
+// no longer requires it.
+// A missing cert-manager is reported by the condition instead of an error, so that the Userland still runs.
+func (r *UserlandReconciler) reconcileCertificate(ctx context.Context, log logr.Logger, userland *escv1alpha2.Userland, objects *render.Objects) error {
+	cert := objects.Certificate
+	installed, err := r.applyUnstructured(ctx, log, userland, render.CertificateGVK, userland.Status.ResourceNames.Certificate, cert)
+	if err != nil {
+		log.Error(err, "unable to ensure certificate is correct")
+		return err
+	}
+
+	switch {
+	case cert == nil:
+		removeUserlandCondition(&userland.Status, escv1alpha2.UserlandCertificateReady)
+	case !installed:
+		if c := getUserlandCondition(&userland.Status, escv1alpha2.UserlandCertificateReady); c == nil || c.Reason != "CertManagerNotInstalled" {
+			r.Recorder.Event(userland, corev1.EventTypeWarning, "CertManagerNotInstalled", "Template requires a certificate, but cert-manager is not installed")
+		}
+		setUserlandCondition(&userland.Status, escv1alpha2.UserlandCertificateReady, corev1.ConditionFalse, "CertManagerNotInstalled", "Certificates of cert-manager.io/v1 are not served")
+	default:
+		// cert was updated with the applied object, including its status
+		conditions, _, _ := unstructured.NestedSlice(cert.Object, "status", "conditions")
+		if c := findCondition(conditions, "Ready"); c != nil {
+			setUserlandCondition(&userland.Status, escv1alpha2.UserlandCertificateReady, corev1.ConditionStatus(c["status"]), c["reason"], c["message"])
+		} else {
+			setUserlandCondition(&userland.Status, escv1alpha2.UserlandCertificateReady, corev1.ConditionUnknown, "Pending", "Certificate "+cert.GetName()+" is not reported by cert-manager yet")
+		}
+	}
+	return nil
+}
+
+// findCondition returns the string fields of the condition with the given type in unstructured conditions,
+// or nil if it is not found.
+func findCondition(conditions []interface{}, condType string) map[string]string {
+	for _, c := range conditions {
+		c, ok := c.(map[string]interface{})
+		if !ok || c["type"] != condType {
+			continue
+		}
+		fields := map[string]string{}
+		for k, v := range c {
+			if s, ok := v.(string); ok {
+				fields[k] = s
+			}
+		}
+		return fields
+	}
+	return nil
+}
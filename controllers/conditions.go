@@ -0,0 +1,98 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+)
+
+// getUserlandCondition returns the condition with the given type, or nil if it is not set.
+func getUserlandCondition(status *escv1alpha2.UserlandStatus, condType escv1alpha2.UserlandConditionType) *escv1alpha2.UserlandCondition {
+	for i := range status.Conditions {
+		if status.Conditions[i].Type == condType {
+			return &status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// setUserlandCondition adds or updates the condition of the same type.
+// LastTransitionTime is only changed when the status of the condition changes.
+func setUserlandCondition(status *escv1alpha2.UserlandStatus, condType escv1alpha2.UserlandConditionType, condStatus corev1.ConditionStatus, reason, message string) {
+	if c := getUserlandCondition(status, condType); c != nil {
+		if c.Status != condStatus {
+			c.Status = condStatus
+			c.LastTransitionTime = metav1.Now()
+		}
+		c.Reason = reason
+		c.Message = message
+		return
+	}
+
+	status.Conditions = append(status.Conditions, escv1alpha2.UserlandCondition{
+		Type:               condType,
+		Status:             condStatus,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// removeUserlandCondition removes the condition with the given type.
+func removeUserlandCondition(status *escv1alpha2.UserlandStatus, condType escv1alpha2.UserlandConditionType) {
+	var conditions []escv1alpha2.UserlandCondition
+	for _, c := range status.Conditions {
+		if c.Type != condType {
+			conditions = append(conditions, c)
+		}
+	}
+	status.Conditions = conditions
+}
+
+// isUserlandConditionTrue returns true if the condition with the given type has status True.
+func isUserlandConditionTrue(status *escv1alpha2.UserlandStatus, condType escv1alpha2.UserlandConditionType) bool {
+	c := getUserlandCondition(status, condType)
+	return c != nil && c.Status == corev1.ConditionTrue
+}
+
+// setTemplateCondition adds or updates the condition of the same type.
+// LastTransitionTime is only changed when the status of the condition changes.
+func setTemplateCondition(status *escv1alpha2.TemplateStatus, condType escv1alpha2.TemplateConditionType, condStatus corev1.ConditionStatus, reason, message string) {
+	for i := range status.Conditions {
+		c := &status.Conditions[i]
+		if c.Type != condType {
+			continue
+		}
+		if c.Status != condStatus {
+			c.Status = condStatus
+			c.LastTransitionTime = metav1.Now()
+		}
+		c.Reason = reason
+		c.Message = message
+		return
+	}
+
+	status.Conditions = append(status.Conditions, escv1alpha2.TemplateCondition{
+		Type:               condType,
+		Status:             condStatus,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            message,
+	})
+}
@@ -0,0 +1,155 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/koba1t/ESC/pkg/render"
+)
+
+const (
+	// configHashAnnotation is set to the pod template of a Deployment, the value is the hash of
+	// ConfigMaps and Secrets referenced by the pods. The pods are rolled when it is changed.
+	configHashAnnotation = "esc.k06.in/config-hash"
+
+	// configRefKey indexes Deployments by the ConfigMaps and Secrets included in their config hash.
+	configRefKey = ".spec.template.configRefs"
+
+	// WatchSecretLabel marks Secrets whose changes roll the pods referring to them immediately.
+	// Other Secrets are not watched nor cached, and their changes are found when the Userlands are resynced.
+	WatchSecretLabel = "esc.k06.in/watch"
+)
+
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+
+// secretReader returns the reader of Secrets. Secrets are read from the API server, so that the manager
+// doesn't cache every Secret of the cluster.
+func (r *UserlandReconciler) secretReader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+	return r.Client
+}
+
+// watchSecrets returns the source of the Secrets labelled with WatchSecretLabel, which is started by the manager.
+func watchSecrets(mgr ctrl.Manager) (source.Source, error) {
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return nil, err
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+		options.LabelSelector = WatchSecretLabel + "=true"
+	}))
+	informer := factory.Core().V1().Secrets().Informer()
+	if err := mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		factory.Start(stop)
+		<-stop
+		return nil
+	})); err != nil {
+		return nil, err
+	}
+	return &source.Informer{Informer: informer}, nil
+}
+
+// configHash returns the hash of the content of ConfigMaps and Secrets referenced by the pod spec.
+// References to missing objects are hashed as empty, so that the pods are rolled when they are created.
+func (r *UserlandReconciler) configHash(ctx context.Context, namespace string, podSpec *corev1.PodSpec) (string, error) {
+	configMapNames, secretNames := render.ConfigReferences(podSpec)
+	content := map[string]interface{}{}
+
+	for _, name := range configMapNames {
+		var configMap corev1.ConfigMap
+		if err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &configMap); client.IgnoreNotFound(err) != nil {
+			return "", err
+		}
+		content["ConfigMap/"+name] = []interface{}{configMap.Data, configMap.BinaryData}
+	}
+	for _, name := range secretNames {
+		var secret corev1.Secret
+		if err := r.secretReader().Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &secret); client.IgnoreNotFound(err) != nil {
+			return "", err
+		}
+		content["Secret/"+name] = secret.Data
+	}
+
+	// json.Marshal sorts the keys of maps, so the hash is stable
+	data, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
+
+// indexConfigRefs indexes the Deployment by the ConfigMaps and Secrets referenced by its pods,
+// only if its pods are rolled by the config hash.
+func indexConfigRefs(rawObj runtime.Object) []string {
+	deployment := rawObj.(*appsv1.Deployment)
+	if _, ok := deployment.Spec.Template.Annotations[configHashAnnotation]; !ok {
+		return nil
+	}
+
+	configMapNames, secretNames := render.ConfigReferences(&deployment.Spec.Template.Spec)
+	var refs []string
+	for _, name := range configMapNames {
+		refs = append(refs, "ConfigMap/"+name)
+	}
+	for _, name := range secretNames {
+		refs = append(refs, "Secret/"+name)
+	}
+	return refs
+}
+
+// mapConfigToUserland returns a function which enqueues the Userlands whose Deployments refer to
+// the changed ConfigMap or Secret of the kind.
+func (r *UserlandReconciler) mapConfigToUserland(kind string) handler.ToRequestsFunc {
+	return func(obj handler.MapObject) []reconcile.Request {
+		var deployments appsv1.DeploymentList
+		if err := r.List(context.Background(), &deployments, client.InNamespace(obj.Meta.GetNamespace()), client.MatchingFields(map[string]string{configRefKey: kind + "/" + obj.Meta.GetName()})); err != nil {
+			r.Log.Error(err, "unable to list Deployments for "+kind, "name", obj.Meta.GetName())
+			return nil
+		}
+
+		var requests []reconcile.Request
+		for i := range deployments.Items {
+			deployment := &deployments.Items[i]
+			if owner := metav1.GetControllerOf(deployment); owner != nil && owner.APIVersion == apiGVStr && owner.Kind == "Userland" {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: deployment.Namespace, Name: owner.Name}})
+				continue
+			}
+			requests = append(requests, mapLabelledToUserland(handler.MapObject{Meta: deployment, Object: deployment})...)
+		}
+		return requests
+	}
+}
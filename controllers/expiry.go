@@ -0,0 +1,156 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/naming"
+)
+
+// +kubebuilder:rbac:groups=esc.k06.in,resources=userlandsets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=esc.k06.in,resources=userlandsets/status,verbs=get;update;patch
+
+// expiry returns the time when the Userland is deleted and the reason, or a zero time if it never expires.
+func expiry(userland *escv1alpha2.Userland) (time.Time, string) {
+	var deadline time.Time
+	var reason string
+	earlier := func(t time.Time, r string) {
+		if deadline.IsZero() || t.Before(deadline) {
+			deadline, reason = t, r
+		}
+	}
+
+	if ttl := userland.Spec.TTLSecondsAfterCreation; ttl != nil {
+		earlier(userland.CreationTimestamp.Add(time.Duration(*ttl)*time.Second), "TTL")
+	}
+	if expiresAt := userland.Spec.ExpiresAt; expiresAt != nil {
+		earlier(expiresAt.Time, "ExpiresAt")
+	}
+	if idle := userland.Spec.DeleteAfterIdle; idle != nil {
+		if since, ok := idleSince(userland); ok {
+			earlier(since.Add(idle.Duration), "Idle")
+		}
+	}
+	return deadline, reason
+}
+
+// idleSince returns the time since when the Userland is idle, and false if it is in use.
+func idleSince(userland *escv1alpha2.Userland) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, userland.Annotations[escv1alpha2.LastActivityAnnotation]); err == nil {
+		return t, true
+	}
+	if userland.Status.Phase == escv1alpha2.UserlandRunning {
+		return time.Time{}, false
+	}
+	if c := getUserlandCondition(&userland.Status, escv1alpha2.UserlandReady); c != nil {
+		return c.LastTransitionTime.Time, true
+	}
+	return userland.CreationTimestamp.Time, true
+}
+
+// reconcileExpiry deletes the Userland when it is expired, and warns by the Expiring condition and an event
+// during the warning period before that.
+// It returns true if the Userland is deleted, and the duration until the expiry needs to be checked again.
+func (r *UserlandReconciler) reconcileExpiry(ctx context.Context, log logr.Logger, userland *escv1alpha2.Userland) (bool, time.Duration, error) {
+	deadline, reason := expiry(userland)
+	if deadline.IsZero() {
+		removeUserlandCondition(&userland.Status, escv1alpha2.UserlandExpiring)
+		userland.Status.ExpiresAt = nil
+		return false, 0, nil
+	}
+
+	now := time.Now()
+	if !now.Before(deadline) {
+		// PersistentVolumeClaims pinned by the annotation must survive the Userland.
+		if err := r.releaseRetainedVolumes(ctx, log, userland); err != nil {
+			return false, 0, err
+		}
+		// Otherwise the UserlandSet would create the Userland again.
+		if err := r.recordExpiredUser(ctx, userland); err != nil {
+			return false, 0, err
+		}
+		if err := r.Delete(ctx, userland); client.IgnoreNotFound(err) != nil {
+			log.Error(err, "failed to delete expired Userland resource")
+			return false, 0, err
+		}
+
+		log.Info("delete expired userland resource: " + userland.Name)
+		r.Recorder.Eventf(userland, corev1.EventTypeNormal, "Expired", "Deleted expired userland (%s)", reason)
+		r.notify(ctx, log, userland, escv1alpha2.ExpiredEvent, "Deleted expired userland ("+reason+")")
+		return true, 0, nil
+	}
+
+	expiresAt := metav1.NewTime(deadline)
+	userland.Status.ExpiresAt = &expiresAt
+	message := "Userland will be deleted at " + deadline.Format(time.RFC3339)
+
+	warnAt := deadline.Add(-r.ExpiryWarningPeriod)
+	if now.Before(warnAt) {
+		setUserlandCondition(&userland.Status, escv1alpha2.UserlandExpiring, corev1.ConditionFalse, reason, message)
+		return false, warnAt.Sub(now), nil
+	}
+
+	if !isUserlandConditionTrue(&userland.Status, escv1alpha2.UserlandExpiring) {
+		r.Recorder.Eventf(userland, corev1.EventTypeWarning, "Expiring", "%s (%s)", message, reason)
+		r.notify(ctx, log, userland, escv1alpha2.ExpiringEvent, message)
+	}
+	setUserlandCondition(&userland.Status, escv1alpha2.UserlandExpiring, corev1.ConditionTrue, reason, message)
+	return false, deadline.Sub(now), nil
+}
+
+// recordExpiredUser adds the user of the Userland to status.expiredUsers of the UserlandSet which owns it.
+func (r *UserlandReconciler) recordExpiredUser(ctx context.Context, userland *escv1alpha2.Userland) error {
+	owner := metav1.GetControllerOf(userland)
+	if owner == nil || owner.APIVersion != apiGVStr || owner.Kind != "UserlandSet" {
+		return nil
+	}
+	user := userland.Labels[naming.UserLabel]
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var set escv1alpha2.UserlandSet
+		if err := r.Get(ctx, types.NamespacedName{Namespace: userland.Namespace, Name: owner.Name}, &set); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		if set.UID != owner.UID || containsString(set.Status.ExpiredUsers, user) {
+			return nil
+		}
+		set.Status.ExpiredUsers = append(set.Status.ExpiredUsers, user)
+		sort.Strings(set.Status.ExpiredUsers)
+		return r.Status().Update(ctx, &set)
+	})
+}
+
+// minRequeueAfter returns the shortest non-zero duration.
+func minRequeueAfter(durations ...time.Duration) time.Duration {
+	var min time.Duration
+	for _, d := range durations {
+		if min == 0 || (d != 0 && d < min) {
+			min = d
+		}
+	}
+	return min
+}
@@ -0,0 +1,103 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+)
+
+var _ = Describe("Userland expiry", func() {
+	ctx := context.Background()
+
+	var namespace string
+	BeforeEach(func() {
+		namespace = createNamespace()
+		Expect(k8sClient.Create(ctx, newTemplate(namespace, "vscode"))).To(Succeed())
+	})
+
+	It("records the expiry in the status until the Userland is deleted", func() {
+		// The finalizer of the dedicated namespace is added in the same reconciliation as the status.
+		template := newTemplate(namespace, "isolated")
+		template.Spec.NamespacePerUser = &escv1alpha2.NamespacePerUserSpec{}
+		Expect(k8sClient.Create(ctx, template)).To(Succeed())
+		userland := newUserland(namespace, "alice", "isolated")
+		expiresAt := metav1.NewTime(time.Now().Add(time.Minute).Truncate(time.Second))
+		userland.Spec.ExpiresAt = &expiresAt
+		Expect(k8sClient.Create(ctx, userland)).To(Succeed())
+		key := types.NamespacedName{Namespace: namespace, Name: "alice"}
+
+		Eventually(func() bool {
+			var current escv1alpha2.Userland
+			Expect(k8sClient.Get(ctx, key, &current)).To(Succeed())
+			return current.Status.ExpiresAt != nil && isUserlandConditionTrue(&current.Status, escv1alpha2.UserlandExpiring)
+		}, timeout, interval).Should(BeTrue())
+
+		By("moving expiresAt to the past")
+		Eventually(func() error {
+			var current escv1alpha2.Userland
+			if err := k8sClient.Get(ctx, key, &current); err != nil {
+				return err
+			}
+			past := metav1.NewTime(time.Now().Add(-time.Minute))
+			current.Spec.ExpiresAt = &past
+			return k8sClient.Update(ctx, &current)
+		}, timeout, interval).Should(Succeed())
+
+		Eventually(func() bool {
+			return apierrors.IsNotFound(k8sClient.Get(ctx, key, &escv1alpha2.Userland{}))
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("doesn't recreate the expired Userland of a UserlandSet", func() {
+		set := &escv1alpha2.UserlandSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "workshop", Namespace: namespace},
+			Spec:       escv1alpha2.UserlandSetSpec{TemplateName: "vscode", Users: []string{"alice"}},
+		}
+		Expect(k8sClient.Create(ctx, set)).To(Succeed())
+		key := types.NamespacedName{Namespace: namespace, Name: "workshop-alice"}
+
+		Eventually(func() error {
+			var current escv1alpha2.Userland
+			if err := k8sClient.Get(ctx, key, &current); err != nil {
+				return err
+			}
+			past := metav1.NewTime(time.Now().Add(-time.Minute))
+			current.Spec.ExpiresAt = &past
+			return k8sClient.Update(ctx, &current)
+		}, timeout, interval).Should(Succeed())
+
+		Eventually(func() []string {
+			var current escv1alpha2.UserlandSet
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "workshop"}, &current)).To(Succeed())
+			return current.Status.ExpiredUsers
+		}, timeout, interval).Should(ConsistOf("alice"))
+		Eventually(func() bool {
+			return apierrors.IsNotFound(k8sClient.Get(ctx, key, &escv1alpha2.Userland{}))
+		}, timeout, interval).Should(BeTrue())
+		Consistently(func() bool {
+			return apierrors.IsNotFound(k8sClient.Get(ctx, key, &escv1alpha2.Userland{}))
+		}, "2s", interval).Should(BeTrue())
+	})
+})
@@ -0,0 +1,188 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/render"
+)
+
+// holdRequeueAfter is the interval to update the progress of the Jobs which hold the Deployment,
+// because the pods of Jobs are not watched.
+const holdRequeueAfter = 30 * time.Second
+
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;delete
+
+// reconcileMigration copies the data of the Userland from the claims of its previous Template by a Job
+// when spec.templateName is changed to a Template with migration. status.templateName is updated to
+// spec.templateName when the migration is finished or not required.
+// It returns a hold while the Deployment of the Userland must be held.
+func (r *UserlandReconciler) reconcileMigration(ctx context.Context, log logr.Logger, userland *escv1alpha2.Userland, spec *escv1alpha2.TemplateSpec, objects *render.Objects) (*hold, error) {
+	status := &userland.Status
+	from, to := status.TemplateName, userland.Spec.TemplateName
+	m := status.Migration
+
+	// switched back while the migration is running
+	if m != nil && m.Phase == escv1alpha2.MigrationRunning && (m.FromTemplate != from || m.ToTemplate != to) {
+		if err := r.deleteJob(ctx, status.Namespace, m.Job); err != nil {
+			return nil, err
+		}
+		r.finishMigration(userland, escv1alpha2.MigrationFailed, "Cancelled by the change of templateName to "+to)
+	}
+
+	if from == "" || from == to {
+		status.TemplateName = to
+		return nil, nil
+	}
+
+	if m == nil || m.FromTemplate != from || m.ToTemplate != to {
+		volumes := render.MigrationVolumes(from, spec.Migration, status.ResourceNames.PersistentVolumeClaims, objects.Names.PersistentVolumeClaims)
+		if len(volumes) == 0 {
+			status.TemplateName = to
+			return nil, nil
+		}
+
+		now := metav1.Now()
+		m = &escv1alpha2.MigrationStatus{
+			FromTemplate: from,
+			ToTemplate:   to,
+			Phase:        escv1alpha2.MigrationRunning,
+			Job:          render.MigrationName(objects.Names),
+			Volumes:      volumes,
+			StartTime:    &now,
+		}
+		status.Migration = m
+
+		if status.Namespace != objects.Namespace {
+			r.finishMigration(userland, escv1alpha2.MigrationFailed, "Volumes can't be copied between namespaces "+status.Namespace+" and "+objects.Namespace)
+			return nil, nil
+		}
+	}
+	if m.Phase != escv1alpha2.MigrationRunning {
+		status.TemplateName = to
+		return nil, nil
+	}
+
+	job := render.MigrationJob(m.Job, objects.Namespace, spec.Migration, m.Volumes)
+	var current batchv1.Job
+	if err := r.getOwned(ctx, userland, job, &current); err != nil {
+		return nil, err
+	}
+	if current.CreationTimestamp.IsZero() {
+		if err := r.setOwner(userland, job); err != nil {
+			return nil, err
+		}
+		if err := r.Create(ctx, job); err != nil {
+			log.Error(err, "unable to create migration job")
+			return nil, err
+		}
+		log.Info("create migration job: " + job.Name)
+		r.Recorder.Eventf(userland, corev1.EventTypeNormal, "Migrating", "Copying volumes from Template %q by job %q", from, job.Name)
+		return migrationHold(m), nil
+	}
+
+	// progress of the copies
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(current.Namespace), client.MatchingLabels{render.MigrationLabel: current.Name}); err != nil {
+		return nil, err
+	}
+	var failure string
+	for _, pod := range pods.Items {
+		for _, s := range pod.Status.InitContainerStatuses {
+			for i := range m.Volumes {
+				if s.Name != render.MigrationContainerName(i) || s.State.Terminated == nil {
+					continue
+				}
+				if s.State.Terminated.ExitCode == 0 {
+					m.Volumes[i].Copied = true
+				} else {
+					failure = s.State.Terminated.Message
+				}
+			}
+		}
+	}
+
+	for _, c := range current.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			for i := range m.Volumes {
+				m.Volumes[i].Copied = true
+			}
+			r.finishMigration(userland, escv1alpha2.MigrationSucceeded, "")
+			return nil, r.deleteJob(ctx, current.Namespace, current.Name)
+		case batchv1.JobFailed:
+			message := c.Message
+			if failure != "" {
+				message += ": " + failure
+			}
+			if len(message) > maxConditionMessageLength {
+				message = message[len(message)-maxConditionMessageLength:]
+			}
+			r.finishMigration(userland, escv1alpha2.MigrationFailed, message)
+			return nil, r.deleteJob(ctx, current.Namespace, current.Name)
+		}
+	}
+	return migrationHold(m), nil
+}
+
+// migrationHold returns the hold of the Deployment while the migration is running.
+func migrationHold(m *escv1alpha2.MigrationStatus) *hold {
+	copied := 0
+	for _, v := range m.Volumes {
+		if v.Copied {
+			copied++
+		}
+	}
+	return &hold{
+		Reason:  "Migrating",
+		Message: fmt.Sprintf("Copying volumes from Template %q: %d of %d copied", m.FromTemplate, copied, len(m.Volumes)),
+	}
+}
+
+// finishMigration records the result of the migration, and switches the Userland to the Template migrated to.
+func (r *UserlandReconciler) finishMigration(userland *escv1alpha2.Userland, phase escv1alpha2.MigrationPhase, message string) {
+	m := userland.Status.Migration
+	now := metav1.Now()
+	m.Phase = phase
+	m.Message = message
+	m.CompletionTime = &now
+	userland.Status.TemplateName = m.ToTemplate
+
+	if phase == escv1alpha2.MigrationSucceeded {
+		r.Recorder.Eventf(userland, corev1.EventTypeNormal, "Migrated", "Copied volumes from Template %q", m.FromTemplate)
+	} else {
+		r.Recorder.Eventf(userland, corev1.EventTypeWarning, "MigrationFailed", "Failed to copy volumes from Template %q: %s", m.FromTemplate, message)
+	}
+}
+
+// deleteJob deletes the migration Job and its pods.
+func (r *UserlandReconciler) deleteJob(ctx context.Context, namespace, name string) error {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	return client.IgnoreNotFound(r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)))
+}
@@ -0,0 +1,103 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+)
+
+var _ = Describe("Migration", func() {
+	ctx := context.Background()
+
+	It("keeps the claims copied by a running migration", func() {
+		namespace := createNamespace()
+		Expect(k8sClient.Create(ctx, withVolume(newTemplate(namespace, "old")))).To(Succeed())
+		migrated := withVolume(newTemplate(namespace, "new"))
+		migrated.Spec.Migration = &escv1alpha2.MigrationSpec{Volumes: []escv1alpha2.VolumeMigration{{From: "data", To: "data"}}}
+		Expect(k8sClient.Create(ctx, migrated)).To(Succeed())
+
+		Expect(k8sClient.Create(ctx, newUserland(namespace, "koba1t", "old"))).To(Succeed())
+		key := types.NamespacedName{Namespace: namespace, Name: "koba1t"}
+		var source string
+		Eventually(func() string {
+			var current escv1alpha2.Userland
+			Expect(k8sClient.Get(ctx, key, &current)).To(Succeed())
+			source = current.Status.ResourceNames.PersistentVolumeClaims["data"]
+			return current.Status.TemplateName
+		}, timeout, interval).Should(Equal("old"))
+		Expect(source).NotTo(BeEmpty())
+
+		By("switching to the Template with the migration")
+		Eventually(func() error {
+			var current escv1alpha2.Userland
+			if err := k8sClient.Get(ctx, key, &current); err != nil {
+				return err
+			}
+			current.Spec.TemplateName = "new"
+			return k8sClient.Update(ctx, &current)
+		}, timeout, interval).Should(Succeed())
+
+		var job string
+		Eventually(func() string {
+			var current escv1alpha2.Userland
+			Expect(k8sClient.Get(ctx, key, &current)).To(Succeed())
+			if current.Status.Migration != nil {
+				job = current.Status.Migration.Job
+			}
+			return job
+		}, timeout, interval).ShouldNot(BeEmpty())
+
+		// the grace period of the suite is zero, so the claim would be deleted at once if it were orphaned
+		Consistently(func() *metav1.Time {
+			var pvc corev1.PersistentVolumeClaim
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: source}, &pvc)).To(Succeed())
+			return pvc.DeletionTimestamp
+		}, "2s", interval).Should(BeNil())
+
+		By("completing the migration Job")
+		Eventually(func() error {
+			var current batchv1.Job
+			if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: job}, &current); err != nil {
+				return err
+			}
+			now := metav1.Now()
+			current.Status.StartTime = &now
+			current.Status.CompletionTime = &now
+			current.Status.Succeeded = 1
+			current.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue, LastTransitionTime: now}}
+			return k8sClient.Status().Update(ctx, &current)
+		}, timeout, interval).Should(Succeed())
+
+		Eventually(func() bool {
+			var pvc corev1.PersistentVolumeClaim
+			err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: source}, &pvc)
+			return apierrors.IsNotFound(err) || (err == nil && pvc.DeletionTimestamp != nil)
+		}, timeout, interval).Should(BeTrue())
+		var current escv1alpha2.Userland
+		Expect(k8sClient.Get(ctx, key, &current)).To(Succeed())
+		Expect(current.Status.Migration.Phase).To(Equal(escv1alpha2.MigrationSucceeded))
+	})
+})
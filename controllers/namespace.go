@@ -0,0 +1,341 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/naming"
+	"github.com/koba1t/ESC/pkg/render"
+)
+
+const (
+	// userlandNameLabel and userlandNamespaceLabel are set to resources which belong to a Userland
+	// but can't have an ownerReference, because they are in the dedicated namespace of the user.
+	userlandNameLabel      = "esc.k06.in/userland"
+	userlandNamespaceLabel = "esc.k06.in/userland-namespace"
+
+	// userNamespaceFinalizer is set to a Userland while its dedicated namespace exists.
+	userNamespaceFinalizer = "esc.k06.in/namespace"
+)
+
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups="",resources=resourcequotas,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups="",resources=limitranges,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=rolebindings,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;clusterroles,verbs=bind
+
+// userlandLabels returns the labels which refer to the Userland from its dedicated namespace.
+func userlandLabels(userland *escv1alpha2.Userland) map[string]string {
+	return map[string]string{
+		userlandNameLabel:      userland.Name,
+		userlandNamespaceLabel: userland.Namespace,
+	}
+}
+
+// setOwner sets the Userland as the controller of obj.
+// Objects outside the namespace of the Userland can't have an ownerReference, so they are labelled instead.
+// It returns an error if obj already exists and is not owned by the Userland, so that names colliding
+// with other resources are detected instead of adopting them.
+func (r *UserlandReconciler) setOwner(userland *escv1alpha2.Userland, obj metav1.Object) error {
+	if err := r.checkOwner(userland, obj); err != nil {
+		return err
+	}
+
+	if obj.GetNamespace() == userland.Namespace {
+		return ctrl.SetControllerReference(userland, obj, r.Scheme)
+	}
+
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	for k, v := range userlandLabels(userland) {
+		labels[k] = v
+	}
+	obj.SetLabels(labels)
+	return nil
+}
+
+// checkOwner returns an error if obj exists and is not owned by the Userland.
+func (r *UserlandReconciler) checkOwner(userland *escv1alpha2.Userland, obj metav1.Object) error {
+	if created := obj.GetCreationTimestamp(); !created.IsZero() && !isOwnedBy(userland, obj) {
+		r.Recorder.Eventf(userland, corev1.EventTypeWarning, "NameConflict", "Resource %q already exists and is not owned by this userland", obj.GetName())
+		return fmt.Errorf("resource %q in namespace %q already exists and is not owned by userland %q", obj.GetName(), obj.GetNamespace(), userland.Name)
+	}
+	return nil
+}
+
+// deleteOwned deletes the objects which exist and are owned by the Userland. Objects are looked up in the cache first,
+// so that nothing is requested from the API server for objects which were never created.
+func (r *UserlandReconciler) deleteOwned(ctx context.Context, log logr.Logger, userland *escv1alpha2.Userland, objs ...runtime.Object) error {
+	for _, obj := range objs {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return err
+		}
+		key := types.NamespacedName{Namespace: accessor.GetNamespace(), Name: accessor.GetName()}
+		if err := r.Get(ctx, key, obj); apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if !isOwnedBy(userland, accessor) {
+			continue
+		}
+		if err := r.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		log.Info("delete resource: " + key.String())
+	}
+	return nil
+}
+
+// ownedListOptions returns the options to list the resources owned by the Userland in the namespace.
+func (r *UserlandReconciler) ownedListOptions(userland *escv1alpha2.Userland, namespace string) []client.ListOption {
+	if namespace == userland.Namespace {
+		return []client.ListOption{client.InNamespace(namespace), client.MatchingFields(map[string]string{resourceOwnerKey: userland.Name})}
+	}
+	return []client.ListOption{client.InNamespace(namespace), client.MatchingLabels(userlandLabels(userland))}
+}
+
+// reconcileUserNamespace ensures the dedicated namespace of the Userland and the resources in it
+// when the Template requires it.
+func (r *UserlandReconciler) reconcileUserNamespace(ctx context.Context, log logr.Logger, userland *escv1alpha2.Userland, objects *render.Objects) error {
+	if objects.UserNamespace == nil {
+		// Remove the namespace created while namespacePerUser was enabled.
+		return r.finalizeUserNamespace(ctx, log, userland)
+	}
+
+	// Never take over a namespace which is not owned by the Userland, the finalizer would delete it.
+	namespace := &corev1.Namespace{ObjectMeta: objects.UserNamespace.ObjectMeta}
+	var current corev1.Namespace
+	if err := r.Get(ctx, types.NamespacedName{Name: namespace.Name}, &current); err == nil {
+		if err := r.checkOwner(userland, &current); err != nil {
+			return err
+		}
+	} else if client.IgnoreNotFound(err) != nil {
+		return err
+	}
+
+	// Set the finalizer before creating the namespace, so that it is never left behind.
+	if !containsString(userland.Finalizers, userNamespaceFinalizer) {
+		if err := r.updateFinalizers(ctx, userland, append(userland.Finalizers, userNamespaceFinalizer)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := ctrl.CreateOrUpdate(ctx, r.Client, namespace, func() error {
+		return r.setOwner(userland, namespace)
+	}); err != nil {
+		log.Error(err, "unable to ensure namespace is correct")
+		return err
+	}
+
+	// ResourceQuota
+	quota := &corev1.ResourceQuota{ObjectMeta: metav1.ObjectMeta{Name: render.ResourceQuotaName, Namespace: objects.Namespace}}
+	if desired := objects.ResourceQuota; desired != nil {
+		if _, err := ctrl.CreateOrUpdate(ctx, r.Client, quota, func() error {
+			quota.Spec = desired.Spec
+			return r.setOwner(userland, quota)
+		}); err != nil {
+			log.Error(err, "unable to ensure resourceQuota is correct")
+			return err
+		}
+	} else if err := r.deleteOwned(ctx, log, userland, quota); err != nil {
+		return err
+	}
+
+	// LimitRange
+	limitRange := &corev1.LimitRange{ObjectMeta: metav1.ObjectMeta{Name: render.LimitRangeName, Namespace: objects.Namespace}}
+	if desired := objects.LimitRange; desired != nil {
+		if _, err := ctrl.CreateOrUpdate(ctx, r.Client, limitRange, func() error {
+			limitRange.Spec = desired.Spec
+			return r.setOwner(userland, limitRange)
+		}); err != nil {
+			log.Error(err, "unable to ensure limitRange is correct")
+			return err
+		}
+	} else if err := r.deleteOwned(ctx, log, userland, limitRange); err != nil {
+		return err
+	}
+
+	// RoleBindings
+	return r.reconcileUserRoleBindings(ctx, log, userland, objects.Namespace, objects.RoleBindings)
+}
+
+// reconcileUserRoleBindings creates the RoleBindings defined in the Template and deletes the others.
+func (r *UserlandReconciler) reconcileUserRoleBindings(ctx context.Context, log logr.Logger, userland *escv1alpha2.Userland, namespace string, bindings []*rbacv1.RoleBinding) error {
+	desired := map[string]bool{}
+	for _, b := range bindings {
+		desired[b.Name] = true
+
+		if b.RoleRef.Kind == "ClusterRole" && !containsString(r.BindableClusterRoles, b.RoleRef.Name) {
+			r.Recorder.Eventf(userland, corev1.EventTypeWarning, "RoleNotAllowed", "ClusterRole %q of roleBinding %q is not bindable", b.RoleRef.Name, b.Name)
+			return fmt.Errorf("clusterRole %q of roleBinding %q is not in the bindable clusterRoles", b.RoleRef.Name, b.Name)
+		}
+
+		// roleRef is immutable, so the RoleBinding is recreated when it changes.
+		var current rbacv1.RoleBinding
+		if err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: b.Name}, &current); err == nil {
+			if isOwnedBy(userland, &current) && current.RoleRef != b.RoleRef {
+				if err := r.Delete(ctx, &current); client.IgnoreNotFound(err) != nil {
+					return err
+				}
+			}
+		} else if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+
+		roleBinding := &rbacv1.RoleBinding{ObjectMeta: b.ObjectMeta}
+		if _, err := ctrl.CreateOrUpdate(ctx, r.Client, roleBinding, func() error {
+			roleBinding.RoleRef = b.RoleRef
+			roleBinding.Subjects = b.Subjects
+			return r.setOwner(userland, roleBinding)
+		}); err != nil {
+			log.Error(err, "unable to ensure roleBinding is correct")
+			return err
+		}
+	}
+
+	var roleBindings rbacv1.RoleBindingList
+	if err := r.List(ctx, &roleBindings, r.ownedListOptions(userland, namespace)...); err != nil {
+		return err
+	}
+	for _, roleBinding := range roleBindings.Items {
+		if desired[roleBinding.Name] {
+			continue
+		}
+		if err := r.Delete(ctx, &roleBinding); client.IgnoreNotFound(err) != nil {
+			log.Error(err, "failed to delete RoleBinding resource")
+			return err
+		}
+		log.Info("delete roleBinding resource: " + roleBinding.Name)
+	}
+
+	return nil
+}
+
+// finalizeUserNamespace deletes the dedicated namespace of the Userland and removes the finalizer.
+func (r *UserlandReconciler) finalizeUserNamespace(ctx context.Context, log logr.Logger, userland *escv1alpha2.Userland) error {
+	if !containsString(userland.Finalizers, userNamespaceFinalizer) {
+		return nil
+	}
+
+	if err := r.deleteUserNamespace(ctx, log, userland); err != nil {
+		return err
+	}
+
+	return r.updateFinalizers(ctx, userland, removeString(userland.Finalizers, userNamespaceFinalizer))
+}
+
+// updateFinalizers updates the finalizers of the Userland. The status changed in this reconciliation is kept,
+// which would be overwritten by the response of the update otherwise.
+func (r *UserlandReconciler) updateFinalizers(ctx context.Context, userland *escv1alpha2.Userland, finalizers []string) error {
+	updated := userland.DeepCopy()
+	updated.Finalizers = finalizers
+	if err := r.Update(ctx, updated); err != nil {
+		return err
+	}
+	userland.Finalizers = updated.Finalizers
+	userland.ResourceVersion = updated.ResourceVersion
+	return nil
+}
+
+// deleteUserNamespace deletes the dedicated namespace of the Userland unless it is not owned by the Userland
+// or has retained PersistentVolumeClaims.
+func (r *UserlandReconciler) deleteUserNamespace(ctx context.Context, log logr.Logger, userland *escv1alpha2.Userland) error {
+	var namespace corev1.Namespace
+	if err := r.Get(ctx, types.NamespacedName{Name: naming.Namespace(userland)}, &namespace); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !isOwnedBy(userland, &namespace) {
+		r.Recorder.Eventf(userland, corev1.EventTypeWarning, "NameConflict", "Kept namespace %q which is not owned by this userland", namespace.Name)
+		return nil
+	}
+
+	// Keep the namespace if it has PersistentVolumeClaims pinned by the annotation, they would be deleted with it.
+	retained, err := r.hasRetainedVolumes(ctx, namespace.Name)
+	if err != nil {
+		return err
+	}
+	if retained {
+		r.Recorder.Eventf(userland, corev1.EventTypeNormal, "Retained", "Kept namespace %q which has retained persistentVolumeClaims", namespace.Name)
+		return nil
+	}
+
+	if err := r.Delete(ctx, &namespace); client.IgnoreNotFound(err) != nil {
+		log.Error(err, "failed to delete Namespace resource")
+		return err
+	}
+	log.Info("delete namespace resource: " + namespace.Name)
+	r.Recorder.Eventf(userland, corev1.EventTypeNormal, "Deleted", "Deleted namespace %q", namespace.Name)
+	return nil
+}
+
+// isOwnedBy returns true if obj is controlled by the Userland.
+func isOwnedBy(userland *escv1alpha2.Userland, obj metav1.Object) bool {
+	if obj.GetNamespace() == userland.Namespace {
+		owner := metav1.GetControllerOf(obj)
+		return owner != nil && owner.UID == userland.UID
+	}
+	labels := obj.GetLabels()
+	return labels[userlandNameLabel] == userland.Name && labels[userlandNamespaceLabel] == userland.Namespace
+}
+
+// mapLabelledToUserland enqueues the Userland which the labels of the object refer to.
+var mapLabelledToUserland = handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+	labels := obj.Meta.GetLabels()
+	name, namespace := labels[userlandNameLabel], labels[userlandNamespaceLabel]
+	if name == "" || namespace == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}}
+})
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(slice []string, s string) []string {
+	result := make([]string, 0, len(slice))
+	for _, item := range slice {
+		if item != s {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,130 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/naming"
+)
+
+var _ = Describe("Userland controller with namespacePerUser", func() {
+	ctx := context.Background()
+
+	var namespace string
+	BeforeEach(func() {
+		namespace = createNamespace()
+		template := newTemplate(namespace, "isolated")
+		template.Spec.NamespacePerUser = &escv1alpha2.NamespacePerUserSpec{
+			RoleBindings: []escv1alpha2.UserRoleBinding{{
+				Name:     "user-edit",
+				RoleRef:  rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "edit"},
+				Subjects: []rbacv1.Subject{{APIGroup: rbacv1.GroupName, Kind: rbacv1.UserKind, Name: "$(USER)"}},
+			}},
+		}
+		Expect(k8sClient.Create(ctx, template)).To(Succeed())
+	})
+
+	// deleted returns true once the namespace is gone or terminating; envtest has no namespace controller to finish it.
+	deleted := func(name string) func() bool {
+		return func() bool {
+			var ns corev1.Namespace
+			err := k8sClient.Get(ctx, types.NamespacedName{Name: name}, &ns)
+			return apierrors.IsNotFound(err) || (err == nil && ns.DeletionTimestamp != nil)
+		}
+	}
+
+	It("creates the dedicated namespace and deletes it with the Userland", func() {
+		userland := newUserland(namespace, "alice", "isolated")
+		Expect(k8sClient.Create(ctx, userland)).To(Succeed())
+		name := naming.Namespace(userland)
+
+		var ns corev1.Namespace
+		Eventually(func() error {
+			return k8sClient.Get(ctx, types.NamespacedName{Name: name}, &ns)
+		}, timeout, interval).Should(Succeed())
+		Expect(ns.Labels).To(HaveKeyWithValue(userlandNameLabel, "alice"))
+		Expect(ns.Labels).To(HaveKeyWithValue(userlandNamespaceLabel, namespace))
+
+		Eventually(func() error {
+			return k8sClient.Get(ctx, types.NamespacedName{Namespace: name, Name: "user-edit"}, &rbacv1.RoleBinding{})
+		}, timeout, interval).Should(Succeed())
+
+		Expect(k8sClient.Delete(ctx, userland)).To(Succeed())
+		Eventually(deleted(name), timeout, interval).Should(BeTrue())
+		Eventually(func() bool {
+			err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "alice"}, &escv1alpha2.Userland{})
+			return apierrors.IsNotFound(err)
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("neither adopts nor deletes a namespace which is not owned by the Userland", func() {
+		userland := newUserland(namespace, "bob", "isolated")
+		name := naming.Namespace(userland)
+		Expect(k8sClient.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}})).To(Succeed())
+
+		Expect(k8sClient.Create(ctx, userland)).To(Succeed())
+		Consistently(func() []string {
+			var current escv1alpha2.Userland
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "bob"}, &current)).To(Succeed())
+			return current.Finalizers
+		}, "2s", interval).ShouldNot(ContainElement(userNamespaceFinalizer))
+
+		Expect(k8sClient.Delete(ctx, userland)).To(Succeed())
+		Eventually(func() bool {
+			err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "bob"}, &escv1alpha2.Userland{})
+			return apierrors.IsNotFound(err)
+		}, timeout, interval).Should(BeTrue())
+
+		var ns corev1.Namespace
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name}, &ns)).To(Succeed())
+		Expect(ns.DeletionTimestamp).To(BeNil())
+		Expect(ns.Labels).NotTo(HaveKey(userlandNameLabel))
+	})
+
+	It("doesn't bind ClusterRoles which are not bindable", func() {
+		template := newTemplate(namespace, "admin")
+		template.Spec.NamespacePerUser = &escv1alpha2.NamespacePerUserSpec{
+			RoleBindings: []escv1alpha2.UserRoleBinding{{
+				Name:     "user-admin",
+				RoleRef:  rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "cluster-admin"},
+				Subjects: []rbacv1.Subject{{APIGroup: rbacv1.GroupName, Kind: rbacv1.UserKind, Name: "$(USER)"}},
+			}},
+		}
+		Expect(k8sClient.Create(ctx, template)).To(Succeed())
+
+		userland := newUserland(namespace, "carol", "admin")
+		Expect(k8sClient.Create(ctx, userland)).To(Succeed())
+		name := naming.Namespace(userland)
+
+		Eventually(func() error {
+			return k8sClient.Get(ctx, types.NamespacedName{Name: name}, &corev1.Namespace{})
+		}, timeout, interval).Should(Succeed())
+		Consistently(func() bool {
+			err := k8sClient.Get(ctx, types.NamespacedName{Namespace: name, Name: "user-admin"}, &rbacv1.RoleBinding{})
+			return apierrors.IsNotFound(err)
+		}, "2s", interval).Should(BeTrue())
+	})
+})
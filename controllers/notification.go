@@ -0,0 +1,96 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/notify"
+)
+
+// +kubebuilder:rbac:groups=esc.k06.in,resources=notificationsinks,verbs=get;list;watch
+// +kubebuilder:rbac:groups=esc.k06.in,resources=notificationsinks/status,verbs=get;update;patch
+
+// notify enqueues the event of the Userland to the NotificationSinks in its namespace which select it.
+// Notifications are best effort, so errors are reported by events of the NotificationSinks and don't fail
+// the reconciliation of the Userland.
+func (r *UserlandReconciler) notify(ctx context.Context, log logr.Logger, userland *escv1alpha2.Userland, event escv1alpha2.NotificationEvent, message string) {
+	if r.Notifier == nil {
+		return
+	}
+
+	var sinks escv1alpha2.NotificationSinkList
+	if err := r.List(ctx, &sinks, client.InNamespace(userland.Namespace)); err != nil {
+		log.Error(err, "unable to list NotificationSinks")
+		return
+	}
+
+	payload := notify.NewPayload(userland, event, message, time.Now())
+	for i := range sinks.Items {
+		sink := &sinks.Items[i]
+		selected, err := notify.Selects(sink, userland, event)
+		if err != nil {
+			r.Recorder.Event(sink, corev1.EventTypeWarning, "InvalidSelector", err.Error())
+			continue
+		}
+		if !selected {
+			continue
+		}
+
+		secret, err := r.sinkSecret(ctx, sink)
+		if err != nil {
+			r.Recorder.Event(sink, corev1.EventTypeWarning, "InvalidSecret", err.Error())
+			continue
+		}
+		r.Notifier.Enqueue(notify.NewNotification(sink, secret, payload))
+	}
+}
+
+// notifyPhase notifies the transition of the Userland to its current phase.
+func (r *UserlandReconciler) notifyPhase(ctx context.Context, log logr.Logger, userland *escv1alpha2.Userland) {
+	var message string
+	if c := getUserlandCondition(&userland.Status, escv1alpha2.UserlandReady); c != nil {
+		message = c.Message
+	}
+	// the events of the phases have the same names as the phases
+	r.notify(ctx, log, userland, escv1alpha2.NotificationEvent(userland.Status.Phase), message)
+}
+
+// sinkSecret returns the key signing the payloads of the NotificationSink, or nil if they are not signed.
+func (r *UserlandReconciler) sinkSecret(ctx context.Context, sink *escv1alpha2.NotificationSink) ([]byte, error) {
+	ref := sink.Spec.SecretRef
+	if ref == nil {
+		return nil, nil
+	}
+
+	var secret corev1.Secret
+	if err := r.secretReader().Get(ctx, types.NamespacedName{Namespace: sink.Namespace, Name: ref.Name}, &secret); err != nil {
+		return nil, err
+	}
+	value, ok := secret.Data[ref.Key]
+	if !ok || len(value) == 0 {
+		return nil, fmt.Errorf("key %q is not found in secret %q", ref.Key, ref.Name)
+	}
+	return value, nil
+}
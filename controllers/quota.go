@@ -0,0 +1,89 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/quota"
+	"github.com/koba1t/ESC/pkg/render"
+)
+
+// +kubebuilder:rbac:groups=esc.k06.in,resources=userlandquotas,verbs=get;list;watch
+
+// reconcileQuota records the usage of the Userland counted by UserlandQuotas in its status,
+// and returns a hold if starting the pod of the Userland, or increasing its requests, exceeds a quota.
+// The number of Userlands and the storage are limited by the webhook, and the pods which are already
+// allowed to run are not stopped when a quota is lowered.
+func (r *UserlandReconciler) reconcileQuota(ctx context.Context, log logr.Logger, userland *escv1alpha2.Userland, objects *render.Objects) (*hold, error) {
+	deploy := objects.Deployment
+	running := deploy.Spec.Replicas == nil || *deploy.Spec.Replicas > 0
+	usage := quota.ObjectsUsage(&deploy.Spec.Template.Spec, objects.PersistentVolumeClaims, running)
+
+	previous := userland.Status.QuotaUsage
+	increased := func(name corev1.ResourceName) bool {
+		if name != escv1alpha2.ResourceRunning && name != corev1.ResourceRequestsCPU && name != corev1.ResourceRequestsMemory {
+			return false
+		}
+		q, p := usage[name], previous[name]
+		return q.Cmp(p) > 0
+	}
+	checked := false
+	for name := range usage {
+		checked = checked || increased(name)
+	}
+	if !checked {
+		userland.Status.QuotaUsage = usage
+		return nil, nil
+	}
+
+	var quotas escv1alpha2.UserlandQuotaList
+	if err := r.List(ctx, &quotas, client.InNamespace(userland.Namespace)); err != nil {
+		return nil, err
+	}
+	if len(quotas.Items) == 0 {
+		userland.Status.QuotaUsage = usage
+		return nil, nil
+	}
+	var userlands escv1alpha2.UserlandList
+	if err := r.List(ctx, &userlands, client.InNamespace(userland.Namespace)); err != nil {
+		return nil, err
+	}
+
+	messages, err := quota.Check(quotas.Items, userlands.Items, userland, usage, increased)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		userland.Status.QuotaUsage = usage
+		return nil, nil
+	}
+
+	// the pod is not started, so only the claims are counted
+	userland.Status.QuotaUsage = quota.ObjectsUsage(&deploy.Spec.Template.Spec, objects.PersistentVolumeClaims, false)
+	message := strings.Join(messages, "; ")
+	if c := getUserlandCondition(&userland.Status, escv1alpha2.UserlandReady); c == nil || c.Reason != "QuotaExceeded" {
+		log.Info("hold userland by quota: " + message)
+		r.Recorder.Event(userland, corev1.EventTypeWarning, "QuotaExceeded", message)
+	}
+	return &hold{Reason: "QuotaExceeded", Message: message}, nil
+}
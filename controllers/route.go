@@ -0,0 +1,84 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/render"
+)
+
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes,verbs=get;list;watch;create;update;patch;delete
+
+// reconcileRoute applies the Gateway API HTTPRoute of the Userland and sets the RouteAccepted condition
+// from the Accepted conditions reported by its Gateways. The HTTPRoute applied before is deleted when its name is changed
+// or the Template no longer requires it.
+// Missing Gateway API CRDs are reported by the condition instead of an error, so that the Userland still runs.
+func (r *UserlandReconciler) reconcileRoute(ctx context.Context, log logr.Logger, userland *escv1alpha2.Userland, objects *render.Objects) error {
+	route := objects.Route
+	installed, err := r.applyUnstructured(ctx, log, userland, render.HTTPRouteGVK, userland.Status.ResourceNames.Route, route)
+	if err != nil {
+		log.Error(err, "unable to ensure route is correct")
+		return err
+	}
+
+	switch {
+	case route == nil:
+		removeUserlandCondition(&userland.Status, escv1alpha2.UserlandRouteAccepted)
+	case !installed:
+		if c := getUserlandCondition(&userland.Status, escv1alpha2.UserlandRouteAccepted); c == nil || c.Reason != "GatewayAPINotInstalled" {
+			r.Recorder.Event(userland, corev1.EventTypeWarning, "GatewayAPINotInstalled", "Template requires a route, but Gateway API is not installed")
+		}
+		setUserlandCondition(&userland.Status, escv1alpha2.UserlandRouteAccepted, corev1.ConditionFalse, "GatewayAPINotInstalled", "HTTPRoutes of gateway.networking.k8s.io/v1 are not served")
+	default:
+		condStatus, reason, message := routeAccepted(route)
+		setUserlandCondition(&userland.Status, escv1alpha2.UserlandRouteAccepted, condStatus, reason, message)
+	}
+	return nil
+}
+
+// routeAccepted aggregates the Accepted conditions of the parents in the status of the HTTPRoute.
+// The route is accepted when all of its parents accept it, and the first parent which doesn't is reported otherwise.
+func routeAccepted(route *unstructured.Unstructured) (corev1.ConditionStatus, string, string) {
+	parents, _, _ := unstructured.NestedSlice(route.Object, "status", "parents")
+	refs, _, _ := unstructured.NestedSlice(route.Object, "spec", "parentRefs")
+	if len(parents) < len(refs) {
+		return corev1.ConditionUnknown, "Pending", fmt.Sprintf("HTTPRoute %s is reported by %d of %d Gateways", route.GetName(), len(parents), len(refs))
+	}
+
+	for _, p := range parents {
+		p, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _, _ := unstructured.NestedString(p, "parentRef", "name")
+		conditions, _, _ := unstructured.NestedSlice(p, "conditions")
+		c := findCondition(conditions, "Accepted")
+		if c == nil {
+			return corev1.ConditionUnknown, "Pending", "Gateway " + name + " has not reported the route yet"
+		}
+		if corev1.ConditionStatus(c["status"]) != corev1.ConditionTrue {
+			return corev1.ConditionStatus(c["status"]), c["reason"], "Gateway " + name + ": " + c["message"]
+		}
+	}
+	return corev1.ConditionTrue, "Accepted", ""
+}
@@ -0,0 +1,155 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/render"
+)
+
+// seedAnnotation records the state of the seed of a PersistentVolumeClaim extracted from a tarball.
+const seedAnnotation = "esc.k06.in/seed"
+
+// These are the states of seeds.
+const (
+	seedPending   = "Pending"
+	seedSucceeded = "Succeeded"
+	seedFailed    = "Failed"
+	// seedSkipped means the claim is filled by a migration instead.
+	seedSkipped = "Skipped"
+)
+
+// volumeSeeds maps the names of the claims to the seeds of their volumes.
+func volumeSeeds(spec *escv1alpha2.TemplateSpec, names escv1alpha2.ResourceNames) map[string]*escv1alpha2.VolumeSeed {
+	seeds := map[string]*escv1alpha2.VolumeSeed{}
+	for _, v := range spec.VolumeSpecs {
+		if v.Seed != nil {
+			seeds[names.PersistentVolumeClaims[v.Name]] = v.Seed
+		}
+	}
+	return seeds
+}
+
+// migrationTargets returns the names of the claims which are filled by a migration from the previous Template.
+// These claims are not seeded, so that the seed doesn't take precedence over the migrated data.
+func migrationTargets(userland *escv1alpha2.Userland, spec *escv1alpha2.TemplateSpec, names escv1alpha2.ResourceNames) map[string]bool {
+	targets := map[string]bool{}
+	status := &userland.Status
+	if from := status.TemplateName; from != "" && from != userland.Spec.TemplateName {
+		for _, v := range render.MigrationVolumes(from, spec.Migration, status.ResourceNames.PersistentVolumeClaims, names.PersistentVolumeClaims) {
+			targets[v.To] = true
+		}
+	}
+	if m := status.Migration; m != nil && m.Phase == escv1alpha2.MigrationRunning {
+		for _, v := range m.Volumes {
+			targets[v.To] = true
+		}
+	}
+	return targets
+}
+
+// markSeed records the state of the seed in the annotation of the claim.
+// A claim is created with the pending state, and the server-side apply of the claim keeps the recorded state.
+func (r *UserlandReconciler) markSeed(ctx context.Context, pvc *corev1.PersistentVolumeClaim, state string) error {
+	patch := client.MergeFrom(pvc.DeepCopy())
+	if pvc.Annotations == nil {
+		pvc.Annotations = map[string]string{}
+	}
+	pvc.Annotations[seedAnnotation] = state
+	return r.Patch(ctx, pvc, patch)
+}
+
+// reconcileSeeds runs a Job for each claim waiting to be seeded from a tarball, and records the result in the claim.
+// It returns a hold while the Jobs are running.
+func (r *UserlandReconciler) reconcileSeeds(ctx context.Context, log logr.Logger, userland *escv1alpha2.Userland, objects *render.Objects, seeds map[string]*escv1alpha2.VolumeSeed) (*hold, error) {
+	var seeding []string
+	for _, pvc := range objects.PersistentVolumeClaims {
+		seed := seeds[pvc.Name]
+		if pvc.Annotations[seedAnnotation] != seedPending || !render.HasTarballSeed(seed) {
+			continue
+		}
+
+		// the data copied by a migration is not overwritten
+		if m := userland.Status.Migration; m != nil && m.Phase == escv1alpha2.MigrationRunning {
+			migrated := false
+			for _, v := range m.Volumes {
+				migrated = migrated || v.To == pvc.Name
+			}
+			if migrated {
+				if err := r.markSeed(ctx, pvc, seedSkipped); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+
+		job := render.SeedJob(pvc.Namespace, pvc.Name, seed)
+		var current batchv1.Job
+		if err := r.getOwned(ctx, userland, job, &current); err != nil {
+			return nil, err
+		}
+		if current.CreationTimestamp.IsZero() {
+			if err := r.setOwner(userland, job); err != nil {
+				return nil, err
+			}
+			if err := r.Create(ctx, job); err != nil {
+				log.Error(err, "unable to create seed job")
+				return nil, err
+			}
+			log.Info("create seed job: " + job.Name)
+			seeding = append(seeding, pvc.Name)
+			continue
+		}
+
+		state := ""
+		for _, c := range current.Status.Conditions {
+			if c.Status != corev1.ConditionTrue {
+				continue
+			}
+			switch c.Type {
+			case batchv1.JobComplete:
+				state = seedSucceeded
+				r.Recorder.Eventf(userland, corev1.EventTypeNormal, "Seeded", "Seeded persistentVolumeClaim %q", pvc.Name)
+			case batchv1.JobFailed:
+				state = seedFailed
+				r.Recorder.Eventf(userland, corev1.EventTypeWarning, "SeedFailed", "Failed to seed persistentVolumeClaim %q by job %q: %s", pvc.Name, current.Name, c.Message)
+			}
+		}
+		if state == "" {
+			seeding = append(seeding, pvc.Name)
+			continue
+		}
+		if err := r.markSeed(ctx, pvc, state); err != nil {
+			return nil, err
+		}
+		if err := r.deleteJob(ctx, current.Namespace, current.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(seeding) == 0 {
+		return nil, nil
+	}
+	return &hold{Reason: "Seeding", Message: fmt.Sprintf("Seeding persistentVolumeClaims %v", seeding)}, nil
+}
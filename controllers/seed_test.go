@@ -0,0 +1,102 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/render"
+)
+
+var _ = Describe("Seed", func() {
+	ctx := context.Background()
+
+	It("creates a claim with the pending seed", func() {
+		namespace := createNamespace()
+		template := withVolume(newTemplate(namespace, "vscode"))
+		template.Spec.VolumeSpecs[0].Seed = &escv1alpha2.VolumeSeed{Image: "example.com/seed:v1"}
+		Expect(k8sClient.Create(ctx, template)).To(Succeed())
+		Expect(k8sClient.Create(ctx, newUserland(namespace, "koba1t", "vscode"))).To(Succeed())
+
+		var claim string
+		Eventually(func() string {
+			var current escv1alpha2.Userland
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "koba1t"}, &current)).To(Succeed())
+			claim = current.Status.ResourceNames.PersistentVolumeClaims["data"]
+			return claim
+		}, timeout, interval).ShouldNot(BeEmpty())
+
+		var pvc corev1.PersistentVolumeClaim
+		Eventually(func() error {
+			return k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: claim}, &pvc)
+		}, timeout, interval).Should(Succeed())
+		Expect(pvc.Annotations).To(HaveKeyWithValue(seedAnnotation, seedPending))
+		Eventually(func() error {
+			return k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: render.SeedName(claim)}, &batchv1.Job{})
+		}, timeout, interval).Should(Succeed())
+	})
+
+	It("doesn't seed a claim which is filled by a migration", func() {
+		namespace := createNamespace()
+		Expect(k8sClient.Create(ctx, withVolume(newTemplate(namespace, "old")))).To(Succeed())
+		seeded := withVolume(newTemplate(namespace, "new"))
+		seeded.Spec.VolumeSpecs[0].Seed = &escv1alpha2.VolumeSeed{Image: "example.com/seed:v1"}
+		seeded.Spec.Migration = &escv1alpha2.MigrationSpec{Volumes: []escv1alpha2.VolumeMigration{{From: "data", To: "data"}}}
+		Expect(k8sClient.Create(ctx, seeded)).To(Succeed())
+
+		Expect(k8sClient.Create(ctx, newUserland(namespace, "koba1t", "old"))).To(Succeed())
+		key := types.NamespacedName{Namespace: namespace, Name: "koba1t"}
+		Eventually(func() string {
+			var current escv1alpha2.Userland
+			Expect(k8sClient.Get(ctx, key, &current)).To(Succeed())
+			return current.Status.TemplateName
+		}, timeout, interval).Should(Equal("old"))
+
+		By("switching to the Template with the seed")
+		Eventually(func() error {
+			var current escv1alpha2.Userland
+			if err := k8sClient.Get(ctx, key, &current); err != nil {
+				return err
+			}
+			current.Spec.TemplateName = "new"
+			return k8sClient.Update(ctx, &current)
+		}, timeout, interval).Should(Succeed())
+
+		var claim string
+		Eventually(func() *escv1alpha2.MigrationStatus {
+			var current escv1alpha2.Userland
+			Expect(k8sClient.Get(ctx, key, &current)).To(Succeed())
+			claim = current.Status.ResourceNames.PersistentVolumeClaims["data"]
+			return current.Status.Migration
+		}, timeout, interval).ShouldNot(BeNil())
+
+		var pvc corev1.PersistentVolumeClaim
+		Eventually(func() error {
+			return k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: claim}, &pvc)
+		}, timeout, interval).Should(Succeed())
+		Expect(pvc.Annotations[seedAnnotation]).NotTo(Equal(seedPending))
+		Consistently(func() error {
+			return k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: render.SeedName(claim)}, &batchv1.Job{})
+		}, "2s", interval).ShouldNot(Succeed())
+	})
+})
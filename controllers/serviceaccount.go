@@ -0,0 +1,79 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/naming"
+	"github.com/koba1t/ESC/pkg/render"
+)
+
+// +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles,verbs=get;list;watch;create;update;delete
+
+// reconcileServiceAccount ensures the ServiceAccount of the Userland and the Role granted to it,
+// and deletes them if the Template doesn't require them.
+// The manager isn't allowed to escalate, so the rules of the Role are limited to the permissions of the manager.
+func (r *UserlandReconciler) reconcileServiceAccount(ctx context.Context, log logr.Logger, userland *escv1alpha2.Userland, objects *render.Objects) error {
+	meta := metav1.ObjectMeta{Name: naming.ServiceAccount(userland), Namespace: objects.Namespace}
+
+	serviceAccount := &corev1.ServiceAccount{ObjectMeta: meta}
+	role := &rbacv1.Role{ObjectMeta: meta}
+	roleBinding := &rbacv1.RoleBinding{ObjectMeta: meta}
+
+	if objects.ServiceAccount == nil {
+		// Delete the resources created while serviceAccount was set in the Template.
+		return r.deleteOwned(ctx, log, userland, roleBinding, role, serviceAccount)
+	}
+
+	if _, err := ctrl.CreateOrUpdate(ctx, r.Client, serviceAccount, func() error {
+		return r.setOwner(userland, serviceAccount)
+	}); err != nil {
+		log.Error(err, "unable to ensure serviceAccount is correct")
+		return err
+	}
+
+	if objects.Role == nil {
+		return r.deleteOwned(ctx, log, userland, roleBinding, role)
+	}
+
+	if _, err := ctrl.CreateOrUpdate(ctx, r.Client, role, func() error {
+		role.Rules = objects.Role.Rules
+		return r.setOwner(userland, role)
+	}); err != nil {
+		log.Error(err, "unable to ensure role is correct")
+		return err
+	}
+
+	if _, err := ctrl.CreateOrUpdate(ctx, r.Client, roleBinding, func() error {
+		roleBinding.RoleRef = objects.RoleBinding.RoleRef
+		roleBinding.Subjects = objects.RoleBinding.Subjects
+		return r.setOwner(userland, roleBinding)
+	}); err != nil {
+		log.Error(err, "unable to ensure roleBinding is correct")
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/naming"
+)
+
+var _ = Describe("Userland controller with serviceAccount", func() {
+	ctx := context.Background()
+
+	var namespace string
+	BeforeEach(func() {
+		namespace = createNamespace()
+	})
+
+	exists := func(key types.NamespacedName, obj runtime.Object) func() error {
+		return func() error { return k8sClient.Get(ctx, key, obj) }
+	}
+	gone := func(key types.NamespacedName, obj runtime.Object) func() bool {
+		return func() bool { return apierrors.IsNotFound(k8sClient.Get(ctx, key, obj)) }
+	}
+
+	It("deletes the ServiceAccount and the Role when they are removed from the Template", func() {
+		template := newTemplate(namespace, "sa")
+		template.Spec.ServiceAccount = &escv1alpha2.UserServiceAccountSpec{
+			Rules: []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}},
+		}
+		Expect(k8sClient.Create(ctx, template)).To(Succeed())
+		userland := newUserland(namespace, "alice", "sa")
+		Expect(k8sClient.Create(ctx, userland)).To(Succeed())
+		key := types.NamespacedName{Namespace: namespace, Name: naming.ServiceAccount(userland)}
+
+		Eventually(exists(key, &corev1.ServiceAccount{}), timeout, interval).Should(Succeed())
+		Eventually(exists(key, &rbacv1.Role{}), timeout, interval).Should(Succeed())
+		Eventually(exists(key, &rbacv1.RoleBinding{}), timeout, interval).Should(Succeed())
+
+		By("removing serviceAccount from the Template")
+		Eventually(func() error {
+			var current escv1alpha2.Template
+			if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "sa"}, &current); err != nil {
+				return err
+			}
+			current.Spec.ServiceAccount = nil
+			return k8sClient.Update(ctx, &current)
+		}, timeout, interval).Should(Succeed())
+
+		Eventually(gone(key, &rbacv1.RoleBinding{}), timeout, interval).Should(BeTrue())
+		Eventually(gone(key, &rbacv1.Role{}), timeout, interval).Should(BeTrue())
+		Eventually(gone(key, &corev1.ServiceAccount{}), timeout, interval).Should(BeTrue())
+	})
+
+	It("doesn't delete a ServiceAccount which is not owned by the Userland", func() {
+		Expect(k8sClient.Create(ctx, newTemplate(namespace, "plain"))).To(Succeed())
+		userland := newUserland(namespace, "bob", "plain")
+		key := types.NamespacedName{Namespace: namespace, Name: naming.ServiceAccount(userland)}
+		Expect(k8sClient.Create(ctx, &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: namespace}})).To(Succeed())
+
+		Expect(k8sClient.Create(ctx, userland)).To(Succeed())
+		Eventually(func() string {
+			var current escv1alpha2.Userland
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "bob"}, &current)).To(Succeed())
+			return current.Status.TemplateName
+		}, timeout, interval).Should(Equal("plain"))
+		Consistently(exists(key, &corev1.ServiceAccount{}), "2s", interval).Should(Succeed())
+	})
+})
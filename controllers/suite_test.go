@@ -16,16 +16,22 @@ limitations under the License.
 package controllers
 
 import (
+	"context"
 	"path/filepath"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
 	escv1alpha1 "github.com/koba1t/ESC/api/v1alpha1"
 	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -39,6 +45,13 @@ import (
 var cfg *rest.Config
 var k8sClient client.Client
 var testEnv *envtest.Environment
+var stopManager chan struct{}
+
+// timeout and interval of Eventually in the specs, which wait for the reconcilers of the manager.
+const (
+	timeout  = 10 * time.Second
+	interval = 100 * time.Millisecond
+)
 
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
@@ -79,11 +92,95 @@ var _ = BeforeSuite(func(done Done) {
 	Expect(err).ToNot(HaveOccurred())
 	Expect(k8sClient).ToNot(BeNil())
 
+	// run the reconcilers in a manager, as they are run by main
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{Scheme: scheme.Scheme, MetricsBindAddress: "0"})
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&TemplateReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("Template"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("template-controller"),
+	}).SetupWithManager(mgr)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&UserlandReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("Userland"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("userland-controller"),
+
+		// orphaned claims are deleted at once, unless they are retained
+		OrphanedVolumeGracePeriod: 0,
+		ExpiryWarningPeriod:       time.Hour,
+		APIReader:                 mgr.GetAPIReader(),
+		BindableClusterRoles:      []string{"edit"},
+	}).SetupWithManager(mgr)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&UserlandSetReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("UserlandSet"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("userlandset-controller"),
+
+		APIReader: mgr.GetAPIReader(),
+	}).SetupWithManager(mgr)
+	Expect(err).ToNot(HaveOccurred())
+
+	stopManager = make(chan struct{})
+	go func() {
+		defer GinkgoRecover()
+		Expect(mgr.Start(stopManager)).To(Succeed())
+	}()
+
 	close(done)
 }, 60)
 
 var _ = AfterSuite(func() {
 	By("tearing down the test environment")
+	close(stopManager)
 	err := testEnv.Stop()
 	Expect(err).ToNot(HaveOccurred())
 })
+
+// createNamespace creates a namespace with a generated name, so that the objects of each spec are isolated.
+func createNamespace() string {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "test-"}}
+	Expect(k8sClient.Create(context.Background(), ns)).To(Succeed())
+	return ns.Name
+}
+
+// newTemplate returns a Template with a single container.
+func newTemplate(namespace, name string) *escv1alpha2.Template {
+	return &escv1alpha2.Template{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: escv1alpha2.TemplateSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "code-server", Image: "codercom/code-server"}}},
+			},
+		},
+	}
+}
+
+// withVolume adds the volume "data" to the Template.
+func withVolume(template *escv1alpha2.Template) *escv1alpha2.Template {
+	template.Spec.VolumeSpecs = []escv1alpha2.VolumeSpec{{
+		Name: "data",
+		PersistentVolumeClaimSpec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+			},
+		},
+	}}
+	return template
+}
+
+// newUserland returns a Userland of the Template.
+func newUserland(namespace, name, template string) *escv1alpha2.Userland {
+	return &escv1alpha2.Userland{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       escv1alpha2.UserlandSpec{TemplateName: template},
+	}
+}
@@ -17,38 +17,266 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
-	escv1alpha1 "github.com/koba1t/ESC/api/v1alpha1"
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/access"
+	"github.com/koba1t/ESC/pkg/naming"
+	"github.com/koba1t/ESC/pkg/render"
 )
 
 // TemplateReconciler reconciles a Template object
 type TemplateReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// PauseImage is the image of the container which keeps pre-pull pods running.
+	PauseImage string
 }
 
 // +kubebuilder:rbac:groups=esc.k06.in,resources=templates,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=esc.k06.in,resources=templates/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;update;delete
 
 // Reconcile loop for Template resource
 func (r *TemplateReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
-	_ = context.Background()
-	_ = r.Log.WithValues("template", req.NamespacedName)
+	ctx := context.Background()
+	log := r.Log.WithValues("template", req.NamespacedName)
+
+	// 1: Load the Template resource by name
+	var template escv1alpha2.Template
+	if err := r.Get(ctx, req.NamespacedName, &template); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			log.Error(err, "unable to fetch Template")
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// 2: Resolve the effective spec from base Templates
+	before := template.Status.DeepCopy()
+
+	spec, bases, err := render.ResolveTemplate(ctx, r.Client, &template)
+	var rerr *render.ResolveError
+	switch {
+	case err == nil:
+		setTemplateCondition(&template.Status, escv1alpha2.TemplateResolved, corev1.ConditionTrue, "Resolved", "")
+	case errors.As(err, &rerr):
+		setTemplateCondition(&template.Status, escv1alpha2.TemplateResolved, corev1.ConditionFalse, rerr.Reason, rerr.Error())
+		r.Recorder.Event(&template, corev1.EventTypeWarning, rerr.Reason, rerr.Error())
+	default:
+		log.Error(err, "unable to resolve Template")
+		return ctrl.Result{}, err
+	}
+	template.Status.Bases = bases
+	template.Status.AllowedSubjects = nil
+	if spec != nil {
+		template.Status.AllowedSubjects = access.AllowedSubjects(spec, template.Namespace)
+	}
+	template.Status.ObservedGeneration = template.Generation
 
-	// your logic here
+	// 3: Pre-pull the images of the Template
+	if spec != nil {
+		if err := r.reconcilePrePull(ctx, log, &template, spec); err != nil {
+			log.Error(err, "failed to reconcile the pre-pull DaemonSet")
+			return ctrl.Result{}, err
+		}
+	}
+
+	// 4: Keep the warm pool of the Template
+	if spec != nil {
+		if err := r.reconcileWarmPool(ctx, log, &template, spec); err != nil {
+			log.Error(err, "failed to reconcile the warm pool")
+			return ctrl.Result{}, err
+		}
+	}
+
+	// 5: Update Template Status
+	if !equality.Semantic.DeepEqual(before, &template.Status) {
+		if err := r.Status().Update(ctx, &template); err != nil {
+			log.Error(err, "unable to update Template status")
+			return ctrl.Result{}, err
+		}
+	}
 
 	return ctrl.Result{}, nil
 }
 
+// reconcilePrePull ensures the DaemonSet which pulls the images of the Template if prePull is set,
+// and deletes it otherwise. A DaemonSet of the same name which is not controlled by the Template is left as it is.
+func (r *TemplateReconciler) reconcilePrePull(ctx context.Context, log logr.Logger, template *escv1alpha2.Template, spec *escv1alpha2.TemplateSpec) error {
+	desired := render.PrePullDaemonSet(template, spec, r.PauseImage)
+
+	var daemonSet appsv1.DaemonSet
+	if err := r.Get(ctx, types.NamespacedName{Namespace: desired.Namespace, Name: desired.Name}, &daemonSet); err != nil {
+		if !apierrors.IsNotFound(err) || !spec.PrePull {
+			return client.IgnoreNotFound(err)
+		}
+
+		// set the owner so that garbage collection can kicks in
+		if err := ctrl.SetControllerReference(template, desired, r.Scheme); err != nil {
+			return err
+		}
+		if err := r.Create(ctx, desired); err != nil {
+			log.Error(err, "unable to create daemonSet")
+			return err
+		}
+		log.Info("create daemonSet resource: " + desired.Name)
+		return nil
+	}
+
+	if !metav1.IsControlledBy(&daemonSet, template) {
+		if !spec.PrePull {
+			return nil
+		}
+		r.Recorder.Eventf(template, corev1.EventTypeWarning, "NameConflict", "DaemonSet %q already exists and is not owned by this template", daemonSet.Name)
+		return fmt.Errorf("daemonSet %q in namespace %q already exists and is not owned by template %q", daemonSet.Name, daemonSet.Namespace, template.Name)
+	}
+
+	if !spec.PrePull {
+		if err := r.Delete(ctx, &daemonSet); client.IgnoreNotFound(err) != nil {
+			log.Error(err, "unable to delete daemonSet")
+			return err
+		}
+		log.Info("delete daemonSet resource: " + daemonSet.Name)
+		return nil
+	}
+
+	// spec.selector is immutable, so only the pod template is updated
+	before := daemonSet.DeepCopy()
+	daemonSet.Labels = desired.Labels
+	daemonSet.Spec.Template = desired.Spec.Template
+	if equality.Semantic.DeepEqual(before, &daemonSet) {
+		return nil
+	}
+	if err := r.Update(ctx, &daemonSet); err != nil {
+		log.Error(err, "unable to ensure daemonSet is correct")
+		return err
+	}
+	return nil
+}
+
+// reconcileWarmPool keeps the number of unclaimed pods of the Template to the size of the warm pool.
+// Pods of an old spec are replaced. Pods claimed by Userlands no longer have the label of the pool,
+// and only the pods controlled by the Template are counted.
+func (r *TemplateReconciler) reconcileWarmPool(ctx context.Context, log logr.Logger, template *escv1alpha2.Template, spec *escv1alpha2.TemplateSpec) error {
+	var size int
+	// pods can't be moved to the dedicated namespace of a user
+	if spec.WarmPool != nil && spec.NamespacePerUser == nil {
+		size = int(spec.WarmPool.Size)
+	}
+	desired := render.WarmPod(template, spec)
+
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(template.Namespace), client.MatchingLabels{render.WarmPoolLabel: naming.Truncate(template.Name, naming.MaxLength)}); err != nil {
+		return err
+	}
+
+	var current int
+	var ready int32
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		// the label may be set on other pods by users
+		if pod.DeletionTimestamp != nil || !metav1.IsControlledBy(pod, template) {
+			continue
+		}
+
+		finished := pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+		if current >= size || finished || pod.Annotations[render.PodSpecHashAnnotation] != desired.Annotations[render.PodSpecHashAnnotation] {
+			if err := r.Delete(ctx, pod); client.IgnoreNotFound(err) != nil {
+				log.Error(err, "failed to delete warm pod")
+				return err
+			}
+			log.Info("delete warm pod resource: " + pod.Name)
+			continue
+		}
+
+		current++
+		if isPodReady(pod) {
+			ready++
+		}
+	}
+
+	for ; current < size; current++ {
+		pod := desired.DeepCopy()
+		if err := ctrl.SetControllerReference(template, pod, r.Scheme); err != nil {
+			return err
+		}
+		if err := r.Create(ctx, pod); err != nil {
+			log.Error(err, "unable to create warm pod")
+			return err
+		}
+	}
+
+	template.Status.WarmPoolReady = ready
+	return nil
+}
+
 // SetupWithManager setup with controller manager
 func (r *TemplateReconciler) SetupWithManager(mgr ctrl.Manager) error {
+
+	// enqueue Templates which extend the changed Template, so that changes of a base fan out
+	mapDerived := handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+		names, err := derivedTemplates(context.Background(), r.Client, obj.Meta.GetNamespace(), obj.Meta.GetName())
+		if err != nil {
+			r.Log.Error(err, "unable to list derived Templates", "template", obj.Meta.GetName())
+			return nil
+		}
+
+		requests := make([]reconcile.Request, 0, len(names))
+		for _, name := range names {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: obj.Meta.GetNamespace(), Name: name}})
+		}
+		return requests
+	})
+
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&escv1alpha1.Template{}).
+		For(&escv1alpha2.Template{}).
+		Owns(&appsv1.DaemonSet{}).
+		Owns(&corev1.Pod{}).
+		Watches(&source.Kind{Type: &escv1alpha2.Template{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: mapDerived}).
 		Complete(r)
 }
+
+// derivedTemplates returns the names of Templates which extend the Template directly or indirectly.
+func derivedTemplates(ctx context.Context, c client.Reader, namespace, name string) ([]string, error) {
+	var templates escv1alpha2.TemplateList
+	if err := c.List(ctx, &templates, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+
+	var derived []string
+	found := map[string]bool{name: true}
+	queue := []string{name}
+	for len(queue) > 0 {
+		base := queue[0]
+		queue = queue[1:]
+		for _, t := range templates.Items {
+			if t.Spec.BaseTemplate == base && !found[t.Name] {
+				found[t.Name] = true
+				derived = append(derived, t.Name)
+				queue = append(queue, t.Name)
+			}
+		}
+	}
+	return derived, nil
+}
@@ -0,0 +1,110 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/render"
+)
+
+var _ = Describe("Template controller", func() {
+	ctx := context.Background()
+
+	// foreignDaemonSet returns a DaemonSet which is not owned by any Template.
+	foreignDaemonSet := func(namespace, name string) *appsv1.DaemonSet {
+		labels := map[string]string{"app": "foreign"}
+		return &appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: appsv1.DaemonSetSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: labels},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: labels},
+					Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "foreign", Image: "example.com/foreign"}}},
+				},
+			},
+		}
+	}
+
+	It("creates and deletes the pre-pull DaemonSet", func() {
+		namespace := createNamespace()
+		template := newTemplate(namespace, "vscode")
+		template.Spec.PrePull = true
+		Expect(k8sClient.Create(ctx, template)).To(Succeed())
+		key := types.NamespacedName{Namespace: namespace, Name: render.PrePullName(template)}
+
+		var daemonSet appsv1.DaemonSet
+		Eventually(func() error {
+			return k8sClient.Get(ctx, key, &daemonSet)
+		}, timeout, interval).Should(Succeed())
+		Expect(metav1.IsControlledBy(&daemonSet, template)).To(BeTrue())
+
+		By("disabling prePull")
+		Eventually(func() error {
+			var current escv1alpha2.Template
+			if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "vscode"}, &current); err != nil {
+				return err
+			}
+			current.Spec.PrePull = false
+			return k8sClient.Update(ctx, &current)
+		}, timeout, interval).Should(Succeed())
+
+		Eventually(func() bool {
+			var current appsv1.DaemonSet
+			err := k8sClient.Get(ctx, key, &current)
+			return apierrors.IsNotFound(err) || (err == nil && current.DeletionTimestamp != nil)
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("doesn't touch a DaemonSet which is not owned by the Template", func() {
+		namespace := createNamespace()
+		template := newTemplate(namespace, "vscode")
+		key := types.NamespacedName{Namespace: namespace, Name: render.PrePullName(template)}
+		Expect(k8sClient.Create(ctx, foreignDaemonSet(namespace, key.Name))).To(Succeed())
+
+		By("creating a Template without prePull")
+		Expect(k8sClient.Create(ctx, template)).To(Succeed())
+		Consistently(func() error {
+			return k8sClient.Get(ctx, key, &appsv1.DaemonSet{})
+		}, "2s", interval).Should(Succeed())
+
+		By("enabling prePull")
+		Eventually(func() error {
+			var current escv1alpha2.Template
+			if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "vscode"}, &current); err != nil {
+				return err
+			}
+			current.Spec.PrePull = true
+			return k8sClient.Update(ctx, &current)
+		}, timeout, interval).Should(Succeed())
+
+		Consistently(func() []corev1.Container {
+			var current appsv1.DaemonSet
+			Expect(k8sClient.Get(ctx, key, &current)).To(Succeed())
+			Expect(metav1.GetControllerOf(&current)).To(BeNil())
+			return current.Spec.Template.Spec.InitContainers
+		}, "2s", interval).Should(BeEmpty())
+	})
+})
@@ -0,0 +1,199 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/accounting"
+	"github.com/koba1t/ESC/pkg/quota"
+)
+
+const (
+	// usageAccountingInterval is the minimum interval to account the usage of a running Userland.
+	// The usage is also accounted when the Userland is enabled or disabled.
+	usageAccountingInterval = 5 * time.Minute
+
+	// usageHistoryDays is the number of days kept in the daily usage of a Userland.
+	usageHistoryDays = 93
+
+	dateFormat = accounting.DateFormat
+)
+
+var (
+	userlandRunningSeconds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "esc_userland_running_seconds_total",
+		Help: "Total time while Userlands have been running.",
+	}, []string{"namespace", "template"})
+	userlandCPUSeconds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "esc_userland_cpu_request_core_seconds_total",
+		Help: "Total CPU cores requested by running Userlands, multiplied by the running time.",
+	}, []string{"namespace", "template"})
+	userlandMemorySeconds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "esc_userland_memory_request_byte_seconds_total",
+		Help: "Total memory bytes requested by running Userlands, multiplied by the running time.",
+	}, []string{"namespace", "template"})
+)
+
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;patch
+
+func init() {
+	metrics.Registry.MustRegister(userlandRunningSeconds, userlandCPUSeconds, userlandMemorySeconds)
+}
+
+// accountUsage adds the resources consumed by the Userland since the last accounting to its status,
+// and returns them to be added to the metrics once the status is updated.
+// The usage is accounted from the resource requests of the Deployment while it is enabled.
+func accountUsage(userland *escv1alpha2.Userland, deploy *appsv1.Deployment, now time.Time) escv1alpha2.ResourceUsage {
+	usage := &userland.Status.Usage
+	var accounted escv1alpha2.ResourceUsage
+	now = now.UTC().Truncate(time.Second)
+
+	// the Deployment of a Userland has one replica while it is enabled
+	running := deploy.Spec.Replicas == nil || *deploy.Spec.Replicas > 0
+
+	if usage.AccountedUntil == nil {
+		if running {
+			t := metav1.NewTime(now)
+			usage.AccountedUntil = &t
+		}
+		return accounted
+	}
+
+	from := usage.AccountedUntil.UTC()
+	if running && now.Sub(from) < usageAccountingInterval {
+		return accounted
+	}
+
+	cpuMillicores, memoryBytes := quota.PodRequests(&deploy.Spec.Template.Spec)
+
+	// split the duration at the boundaries of days
+	for from.Before(now) {
+		to := time.Date(from.Year(), from.Month(), from.Day()+1, 0, 0, 0, 0, time.UTC)
+		if now.Before(to) {
+			to = now
+		}
+
+		seconds := int64(to.Sub(from) / time.Second)
+		delta := escv1alpha2.ResourceUsage{
+			RunningSeconds:        seconds,
+			CPUMillicoreSeconds:   cpuMillicores * seconds,
+			MemoryMebibyteSeconds: memoryBytes * seconds >> 20,
+		}
+		addUsage(&usage.Total, delta)
+		addUsage(dailyUsage(usage, from.Format(dateFormat)), delta)
+		addUsage(&accounted, delta)
+
+		from = to
+	}
+
+	if running {
+		t := metav1.NewTime(now)
+		usage.AccountedUntil = &t
+	} else {
+		usage.AccountedUntil = nil
+	}
+
+	// drop old days
+	if len(usage.Daily) > usageHistoryDays {
+		usage.Daily = usage.Daily[len(usage.Daily)-usageHistoryDays:]
+	}
+	return accounted
+}
+
+// recordUsageMetrics adds the usage accounted by accountUsage to the metrics.
+func recordUsageMetrics(userland *escv1alpha2.Userland, accounted escv1alpha2.ResourceUsage) {
+	if accounted.RunningSeconds == 0 {
+		return
+	}
+	labels := prometheus.Labels{"namespace": userland.Namespace, "template": userland.Spec.TemplateName}
+	userlandRunningSeconds.With(labels).Add(float64(accounted.RunningSeconds))
+	userlandCPUSeconds.With(labels).Add(float64(accounted.CPUMillicoreSeconds) / 1000)
+	userlandMemorySeconds.With(labels).Add(float64(accounted.MemoryMebibyteSeconds << 20))
+}
+
+// recordUsage writes the daily usage of the Userland changed since before to the usage records of the days,
+// which are kept after the Userland is deleted. The records hold the values in the status rather than increments,
+// so writing them again is harmless, e.g. when the status update conflicts and the usage is accounted again.
+func (r *UserlandReconciler) recordUsage(ctx context.Context, userland *escv1alpha2.Userland, before *escv1alpha2.UserlandUsage) error {
+	previous := map[string]escv1alpha2.ResourceUsage{}
+	for _, daily := range before.Daily {
+		previous[daily.Date] = daily.Usage
+	}
+
+	for _, daily := range userland.Status.Usage.Daily {
+		if equality.Semantic.DeepEqual(previous[daily.Date], daily.Usage) {
+			continue
+		}
+		value, err := json.Marshal(accounting.NewRecord(userland, daily.Usage))
+		if err != nil {
+			return err
+		}
+
+		var cm corev1.ConfigMap
+		key := types.NamespacedName{Namespace: userland.Namespace, Name: accounting.ConfigMapName(daily.Date)}
+		if err := r.Get(ctx, key, &cm); apierrors.IsNotFound(err) {
+			cm = corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace, Labels: map[string]string{accounting.Label: daily.Date}},
+				Data:       map[string]string{accounting.Key(userland): string(value)},
+			}
+			if err := r.Create(ctx, &cm); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		patch := client.MergeFrom(cm.DeepCopy())
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[accounting.Key(userland)] = string(value)
+		if err := r.Patch(ctx, &cm, patch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dailyUsage returns the usage of the date, which is appended if it doesn't exist.
+func dailyUsage(usage *escv1alpha2.UserlandUsage, date string) *escv1alpha2.ResourceUsage {
+	if n := len(usage.Daily); n > 0 && usage.Daily[n-1].Date == date {
+		return &usage.Daily[n-1].Usage
+	}
+	usage.Daily = append(usage.Daily, escv1alpha2.DailyUsage{Date: date})
+	return &usage.Daily[len(usage.Daily)-1].Usage
+}
+
+func addUsage(usage *escv1alpha2.ResourceUsage, delta escv1alpha2.ResourceUsage) {
+	usage.RunningSeconds += delta.RunningSeconds
+	usage.CPUMillicoreSeconds += delta.CPUMillicoreSeconds
+	usage.MemoryMebibyteSeconds += delta.MemoryMebibyteSeconds
+}
@@ -17,19 +17,30 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"k8s.io/client-go/tools/record"
 
 	"github.com/go-logr/logr"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/naming"
+	"github.com/koba1t/ESC/pkg/notify"
+	"github.com/koba1t/ESC/pkg/render"
 )
 
 // UserlandReconciler reconciles a Userland object
@@ -38,14 +49,33 @@ type UserlandReconciler struct {
 	Log      logr.Logger
 	Scheme   *runtime.Scheme
 	Recorder record.EventRecorder
+
+	// OrphanedVolumeGracePeriod is the duration to keep PersistentVolumeClaims no longer used by the Template.
+	OrphanedVolumeGracePeriod time.Duration
+
+	// Namer generates the names of owned resources.
+	Namer naming.Namer
+
+	// ExpiryWarningPeriod is the duration before the expiry of a Userland to warn its user.
+	ExpiryWarningPeriod time.Duration
+
+	// Notifier delivers the events of Userlands to NotificationSinks. Notifications are disabled if it is nil.
+	Notifier *notify.Dispatcher
+
+	// BindableClusterRoles are the ClusterRoles which the RoleBindings of namespacePerUser can refer to.
+	// Templates can't grant other ClusterRoles, because the manager is allowed to bind any role.
+	BindableClusterRoles []string
+
+	// APIReader reads Secrets from the API server instead of the cache. The client is used if it is nil.
+	APIReader client.Reader
 }
 
 // +kubebuilder:rbac:groups=esc.k06.in,resources=userlands,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=esc.k06.in,resources=userlands/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=esc.k06.in,resources=templates,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;delete
-// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;delete
-// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile loop for Userland resource
@@ -67,16 +97,25 @@ func (r *UserlandReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		// on deleted requests.
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
+	statusBefore := userland.Status.DeepCopy()
 
-	// 2: Clean Up old Deployment which had been owned by Userland Resource.
-	if err := r.cleanupOwnedResources(ctx, log, &userland); err != nil {
-		log.Error(err, "failed to clean up old Deployment resources for this userland")
+	// 2: Finalize the Userland which is being deleted
+	if userland.DeletionTimestamp != nil {
+		return ctrl.Result{}, r.finalizeUserNamespace(ctx, log, &userland)
+	}
+
+	// Delete the Userland when it is expired
+	expired, expiryRequeueAfter, err := r.reconcileExpiry(ctx, log, &userland)
+	if err != nil {
+		log.Error(err, "failed to reconcile the expiry of this userland")
 		return ctrl.Result{}, err
 	}
+	if expired {
+		return ctrl.Result{}, nil
+	}
 
-	// 3: Create or Update deployment object
+	// 3: Get Template resource from templateName
 	templateName := userland.Spec.TemplateName
-	// Get Template resource from templateName
 	var template escv1alpha2.Template
 	namespacedTemplateName := req.NamespacedName
 	namespacedTemplateName.Name = templateName
@@ -84,199 +123,306 @@ func (r *UserlandReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, err
 	}
 
-	// define deploymentName join to templateName and userland.Name
-	deploymentName := userland.Spec.TemplateName + "-" + userland.Name
+	// Resolve the effective spec if the Template extends another Template
+	templateSpec, _, err := render.ResolveTemplate(ctx, r.Client, &template)
+	if err != nil {
+		log.Error(err, "unable to resolve Template", "template", templateName)
+		return ctrl.Result{}, err
+	}
 
-	// volume list of defined template resource
-	volumes := []corev1.Volume{}
+	// The size is checked by the webhook, but the sizes of the Template may have been changed since
+	if _, err := render.FindSize(templateSpec, userland.Spec.Size); err != nil {
+		r.Recorder.Event(&userland, corev1.EventTypeWarning, "InvalidSize", err.Error())
+		return ctrl.Result{}, err
+	}
 
-	for _, v := range template.Spec.VolumeSpecs {
+	// Render the resources owned by this userland
+	objects, err := render.Render(&userland, templateSpec, r.Namer)
+	if err != nil {
+		log.Error(err, "unable to render resources of this userland")
+		r.Recorder.Event(&userland, corev1.EventTypeWarning, "InvalidName", err.Error())
+		return ctrl.Result{}, err
+	}
+	names := objects.Names
+	namespace := objects.Namespace
 
-		// pvc resource name
-		pvcName := deploymentName + "-pvc-" + v.Name
+	// 4: Ensure the namespace where owned resources are placed
+	if err := r.reconcileUserNamespace(ctx, log, &userland, objects); err != nil {
+		log.Error(err, "failed to reconcile the namespace for this userland")
+		return ctrl.Result{}, err
+	}
 
-		//add volume data for deploy.Spec.Template.Spec.Volumes
-		vs := corev1.VolumeSource{
-			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-				ClaimName: pvcName,
-			},
+	// 5: Clean Up old Deployment which had been owned by Userland Resource.
+	if err := r.cleanupOwnedResources(ctx, log, &userland, namespace, namespace, names); err != nil {
+		log.Error(err, "failed to clean up old Deployment resources for this userland")
+		return ctrl.Result{}, err
+	}
+	if namespace != userland.Namespace {
+		// Resources created before namespacePerUser was enabled are left in the namespace of the Userland.
+		if err := r.cleanupOwnedResources(ctx, log, &userland, userland.Namespace, namespace, names); err != nil {
+			log.Error(err, "failed to clean up old Deployment resources for this userland")
+			return ctrl.Result{}, err
 		}
-		volumes = append(volumes, corev1.Volume{
-			Name:         v.Name,
-			VolumeSource: vs,
-		})
-
-		// define persistentVolumeClaim using deploymentName
-		persistentVolumeClaim := &corev1.PersistentVolumeClaim{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      pvcName,
-				Namespace: req.Namespace,
-			},
+	}
+
+	// 6: Create or Update ServiceAccount used by the pod
+	if err := r.reconcileServiceAccount(ctx, log, &userland, objects); err != nil {
+		log.Error(err, "failed to reconcile the serviceAccount for this userland")
+		return ctrl.Result{}, err
+	}
+
+	// 7: Apply PersistentVolumeClaims, Deployment and Service
+	// These resources are applied with server-side apply, so that fields set by others are kept.
+	// pvc names used by the template
+	pvcNames := map[string]bool{}
+	seeds := volumeSeeds(templateSpec, names)
+	migrated := migrationTargets(&userland, templateSpec, names)
+
+	for _, persistentVolumeClaim := range objects.PersistentVolumeClaims {
+		pvcNames[persistentVolumeClaim.Name] = true
+
+		var current corev1.PersistentVolumeClaim
+		if err := r.getOwned(ctx, &userland, persistentVolumeClaim, &current); err != nil {
+			return ctrl.Result{}, err
 		}
 
-		if _, err := ctrl.CreateOrUpdate(ctx, r.Client, persistentVolumeClaim, func() error {
+		// a user scoped claim may have been created by another Template, whose spec is immutable
+		if msg := volumeConflict(&current, persistentVolumeClaim); msg != "" {
+			r.Recorder.Event(&userland, corev1.EventTypeWarning, "VolumeConflict", msg)
+			return ctrl.Result{}, fmt.Errorf("persistentVolumeClaim %q conflicts with the Template: %s", current.Name, msg)
+		}
 
-			//get PersistentVolumeClaimSpec from template resource
-			if persistentVolumeClaim.Spec.VolumeName != "" {
-				v.PersistentVolumeClaimSpec.VolumeName = persistentVolumeClaim.Spec.VolumeName
+		// the claim is used again if it had been orphaned
+		if _, ok := current.Annotations[orphanedAtAnnotation]; ok {
+			patch := client.MergeFrom(current.DeepCopy())
+			delete(current.Annotations, orphanedAtAnnotation)
+			if err := r.Patch(ctx, &current, patch); err != nil {
+				log.Error(err, "unable to unmark persistentVolumeClaim as orphaned")
+				return ctrl.Result{}, err
 			}
-			persistentVolumeClaim.Spec = v.PersistentVolumeClaimSpec
+		}
 
-			// set the owner so that garbage collection can kicks in
-			if err := ctrl.SetControllerReference(&userland, persistentVolumeClaim, r.Scheme); err != nil {
-				log.Error(err, "unable to set ownerReference from Userland to PersistentVolumeClaim")
-				return err
+		// the seed is applied only when the claim is created
+		seedState := current.Annotations[seedAnnotation]
+		if !current.CreationTimestamp.IsZero() {
+			persistentVolumeClaim.Spec.DataSource = current.Spec.DataSource
+			// a claim can be expanded, but not shrunk, e.g. by switching to a smaller size
+			keepLargerStorage(&current, persistentVolumeClaim)
+		} else if migrated[persistentVolumeClaim.Name] {
+			// the claim is filled by the migration instead of the seed
+			persistentVolumeClaim.Spec.DataSource = nil
+		} else if render.HasTarballSeed(seeds[persistentVolumeClaim.Name]) {
+			// the claim is created with the mark, so that it is seeded even if the controller stops right after
+			seedState = seedPending
+		}
+		if seedState != "" {
+			if persistentVolumeClaim.Annotations == nil {
+				persistentVolumeClaim.Annotations = map[string]string{}
 			}
+			persistentVolumeClaim.Annotations[seedAnnotation] = seedState
+		}
 
-			return nil
-
-		}); err != nil {
-			// error handling of ctrl.CreateOrUpdate
+		if err := r.apply(ctx, &userland, persistentVolumeClaim); err != nil {
 			log.Error(err, "unable to ensure persistentVolumeClaim is correct")
 			return ctrl.Result{}, err
 		}
 	}
 
-	// define deployment template using deploymentName
-	deploy := &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      deploymentName,
-			Namespace: req.Namespace,
-		},
+	// Hold the deployment while the data is copied from the previous Template
+	held, err := r.reconcileMigration(ctx, log, &userland, templateSpec, objects)
+	if err != nil {
+		log.Error(err, "failed to reconcile the migration for this userland")
+		return ctrl.Result{}, err
 	}
 
-	// Create or Update deployment object
-	if _, err := ctrl.CreateOrUpdate(ctx, r.Client, deploy, func() error {
-
-		// set the replicas from 1 by default
-		replicas := int32(1)
+	// Hold the deployment while the claims are seeded
+	seedHold, err := r.reconcileSeeds(ctx, log, &userland, objects, seeds)
+	if err != nil {
+		log.Error(err, "failed to reconcile the seeds for this userland")
+		return ctrl.Result{}, err
+	}
+	if held == nil {
+		held = seedHold
+	}
 
-		// If Userland.Spec.Enabled was false, deployment has no pod.
-		if userland.Spec.Enabled != nil {
-			if !*userland.Spec.Enabled {
-				replicas = int32(0)
-			}
-		}
+	// Hold the deployment while it exceeds a UserlandQuota
+	quotaHold, err := r.reconcileQuota(ctx, log, &userland, objects)
+	if err != nil {
+		log.Error(err, "failed to reconcile the quotas for this userland")
+		return ctrl.Result{}, err
+	}
+	if held == nil {
+		held = quotaHold
+	}
 
+	deploy := objects.Deployment
+	if held != nil {
+		replicas := int32(0)
 		deploy.Spec.Replicas = &replicas
+	}
 
-		//get template.Spec from template resource
-		//templateSpec := templates.Items[0].Spec.Template.Spec
-		templateSpec := template.Spec.Template.Spec
+	var currentDeploy appsv1.Deployment
+	if err := r.getOwned(ctx, &userland, deploy, &currentDeploy); err != nil {
+		return ctrl.Result{}, err
+	}
 
-		// set a label for our deployment
-		labels := map[string]string{
-			"app":        deploymentName,
-			"controller": req.Name,
-			"template":   templateName,
+	// spec.selector is immutable, so the selector of the existing deployment is kept
+	if currentDeploy.Spec.Selector != nil {
+		deploy.Spec.Selector = currentDeploy.Spec.Selector
+		for k, v := range currentDeploy.Spec.Selector.MatchLabels {
+			deploy.Spec.Template.ObjectMeta.Labels[k] = v
 		}
+	}
 
-		// set labels to spec.selector for our deployment
-		if deploy.Spec.Selector == nil {
-			deploy.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+	// roll the pods when the referenced ConfigMaps and Secrets are changed
+	if !templateSpec.DisableConfigHash {
+		hash, err := r.configHash(ctx, namespace, &deploy.Spec.Template.Spec)
+		if err != nil {
+			log.Error(err, "unable to compute the hash of ConfigMaps and Secrets")
+			return ctrl.Result{}, err
 		}
-
-		// set labels to template.objectMeta for our deployment
-		if deploy.Spec.Template.ObjectMeta.Labels == nil {
-			deploy.Spec.Template.ObjectMeta.Labels = labels
+		if deploy.Spec.Template.Annotations == nil {
+			deploy.Spec.Template.Annotations = map[string]string{}
 		}
+		deploy.Spec.Template.Annotations[configHashAnnotation] = hash
+	}
 
-		// set containers to template.spec.containers for our deployment
-		//if deploy.Spec.Template.Spec.Containers == nil {
-		//	deploy.Spec.Template.Spec = templateSpec
-		//}
-		deploy.Spec.Template.Spec = templateSpec
+	if err := r.apply(ctx, &userland, deploy); err != nil {
+		log.Error(err, "unable to ensure deployment is correct")
+		return ctrl.Result{}, err
+	}
 
-		// Append volumes created by this controller
-		if volumes != nil {
-			deploy.Spec.Template.Spec.Volumes = append(deploy.Spec.Template.Spec.Volumes, volumes...)
-		}
+	// Report the bootstrap of the volume
+	bootstrapRequeueAfter, err := r.reconcileBootstrap(ctx, &userland, deploy)
+	if err != nil {
+		log.Error(err, "failed to check the bootstrap of this userland")
+		return ctrl.Result{}, err
+	}
 
-		// set the owner so that garbage collection can kicks in
-		if err := ctrl.SetControllerReference(&userland, deploy, r.Scheme); err != nil {
-			log.Error(err, "unable to set ownerReference from Userland to Deployment")
-			return err
-		}
+	service := objects.Service
+	var currentService corev1.Service
+	if err := r.getOwned(ctx, &userland, service, &currentService); err != nil {
+		return ctrl.Result{}, err
+	}
 
-		return nil
+	// select the pods of the deployment
+	service.Spec.Selector = deploy.Spec.Selector.MatchLabels
 
-	}); err != nil {
-		// error handling of ctrl.CreateOrUpdate
-		log.Error(err, "unable to ensure deployment is correct")
+	if err := r.apply(ctx, &userland, service); err != nil {
+		log.Error(err, "unable to ensure service is correct")
 		return ctrl.Result{}, err
 	}
 
-	// define service using deploymentName
-	service := &corev1.Service{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      deploymentName + "-svc",
-			Namespace: req.Namespace,
-		},
+	// Apply the certificate and route of the userland
+	if err := r.reconcileCertificate(ctx, log, &userland, objects); err != nil {
+		log.Error(err, "failed to reconcile the certificate for this userland")
+		return ctrl.Result{}, err
+	}
+	if err := r.reconcileRoute(ctx, log, &userland, objects); err != nil {
+		log.Error(err, "failed to reconcile the route for this userland")
+		return ctrl.Result{}, err
 	}
 
-	if _, err := ctrl.CreateOrUpdate(ctx, r.Client, service, func() error {
-
-		//get template.Spec from template resource
-		//templateSpec := templates.Items[0].Spec.Template.Spec
-		ServiceSpec := template.Spec.ServiceSpec
+	// Serve the userland by a warm pod while the deployment is starting
+	warmPodReady, err := r.reconcileWarmPod(ctx, log, &userland, templateSpec, deploy)
+	if err != nil {
+		log.Error(err, "failed to reconcile the warm pod for this userland")
+		return ctrl.Result{}, err
+	}
 
-		// set a label for our deployment
-		labels := map[string]string{
-			"app":        deploymentName,
-			"controller": req.Name,
-			"template":   templateName,
+	// 8: Collect PersistentVolumeClaims which are no longer used by the Template
+	// the claims copied by a migration are used until it is finished
+	if m := userland.Status.Migration; m != nil && m.Phase == escv1alpha2.MigrationRunning {
+		for _, v := range m.Volumes {
+			pvcNames[v.From] = true
 		}
+	}
+	requeueAfter, err := r.collectOrphanedVolumes(ctx, log, &userland, namespace, pvcNames)
+	if err != nil {
+		log.Error(err, "failed to collect orphaned persistentVolumeClaims for this userland")
+		return ctrl.Result{}, err
+	}
 
-		//service.Spec = ServiceSpec
-		// This code has error
-		// spec.clusterIP: Invalid value: "": field is immutable
-		if ServiceSpec.Ports != nil {
-			service.Spec.Ports = ServiceSpec.Ports
-		}
+	// 9: Update userland Status
+	if userland.Status.TemplateName != userland.Spec.TemplateName {
+		// the claims of the previous Template are kept until the migration is finished
+		names.PersistentVolumeClaims = userland.Status.ResourceNames.PersistentVolumeClaims
+	}
+	userland.Status.ResourceNames = names
+	accounted := accountUsage(&userland, deploy, time.Now())
+	if err := r.recordUsage(ctx, &userland, &statusBefore.Usage); err != nil {
+		log.Error(err, "unable to record the usage of this userland")
+		return ctrl.Result{}, err
+	}
+	if err := r.updateStatus(ctx, &userland, statusBefore, deploy, warmPodReady, held); err != nil {
+		log.Error(err, "unable to update Userland status")
+		return ctrl.Result{}, err
+	}
+	recordUsageMetrics(&userland, accounted)
+	if userland.Status.Phase != statusBefore.Phase {
+		r.notifyPhase(ctx, log, &userland)
+	}
 
-		// set labels to spec.selector for our deployment
-		if service.Spec.Selector == nil {
-			service.Spec.Selector = labels
-		}
+	if held != nil {
+		requeueAfter = minRequeueAfter(requeueAfter, holdRequeueAfter)
+	}
+	if userland.Status.Usage.AccountedUntil != nil {
+		// account the usage while the Userland is running
+		requeueAfter = minRequeueAfter(requeueAfter, usageAccountingInterval)
+	}
+	return ctrl.Result{RequeueAfter: minRequeueAfter(requeueAfter, expiryRequeueAfter, bootstrapRequeueAfter)}, nil
+}
 
-		//if service.Spec.Type == nil {
-		//	service.Spec.Type = "ClusterIP"
-		//}
-		service.Spec.Type = "ClusterIP"
+// hold is the reason why the Deployment of a Userland is held with no replicas.
+type hold struct {
+	Reason  string
+	Message string
+}
 
-		// set the owner so that garbage collection can kicks in
-		if err := ctrl.SetControllerReference(&userland, service, r.Scheme); err != nil {
-			log.Error(err, "unable to set ownerReference from Userland to Service")
-			return err
-		}
+// updateStatus sets the phase and Ready condition of the Userland from its Deployment, claimed warm pod and hold,
+// and updates the status if it is changed from before.
+func (r *UserlandReconciler) updateStatus(ctx context.Context, userland *escv1alpha2.Userland, before *escv1alpha2.UserlandStatus, deploy *appsv1.Deployment, warmPodReady bool, held *hold) error {
+	switch {
+	case held != nil:
+		userland.Status.Phase = escv1alpha2.UserlandPending
+		setUserlandCondition(&userland.Status, escv1alpha2.UserlandReady, corev1.ConditionFalse, held.Reason, held.Message)
+	case deploy.Spec.Replicas != nil && *deploy.Spec.Replicas == 0:
+		userland.Status.Phase = escv1alpha2.UserlandSuspended
+		setUserlandCondition(&userland.Status, escv1alpha2.UserlandReady, corev1.ConditionFalse, "Disabled", "Userland is disabled by spec.enabled")
+	case deploy.Status.AvailableReplicas > 0:
+		userland.Status.Phase = escv1alpha2.UserlandRunning
+		setUserlandCondition(&userland.Status, escv1alpha2.UserlandReady, corev1.ConditionTrue, "DeploymentAvailable", "")
+	case warmPodReady:
+		// the warm pod doesn't mount the volumes of the Userland, so it is not ready until the Deployment is available
+		userland.Status.Phase = escv1alpha2.UserlandPending
+		setUserlandCondition(&userland.Status, escv1alpha2.UserlandReady, corev1.ConditionFalse, "WarmPodAvailable", "Userland is served by a pod from the warm pool, whose data is not kept, until Deployment "+deploy.Name+" is available")
+	default:
+		userland.Status.Phase = escv1alpha2.UserlandPending
+		setUserlandCondition(&userland.Status, escv1alpha2.UserlandReady, corev1.ConditionFalse, "DeploymentUnavailable", "Deployment "+deploy.Name+" has no available pod")
+	}
+	userland.Status.ObservedGeneration = userland.Generation
+	userland.Status.Namespace = deploy.Namespace
 
+	if equality.Semantic.DeepEqual(before, &userland.Status) {
 		return nil
-
-	}); err != nil {
-		// error handling of ctrl.CreateOrUpdate
-		log.Error(err, "unable to ensure service is correct")
-		return ctrl.Result{}, err
 	}
-
-	// 4: Update userland Status
-	//TODO:
-
-	return ctrl.Result{}, nil
+	return r.Status().Update(ctx, userland)
 }
 
-// cleanupOwnedResources will delete any existing Deployment and Service resources
-func (r *UserlandReconciler) cleanupOwnedResources(ctx context.Context, log logr.Logger, userland *escv1alpha2.Userland) error {
+// cleanupOwnedResources will delete any existing Deployment and Service resources in the namespace
+// which don't match the names used in targetNamespace.
+func (r *UserlandReconciler) cleanupOwnedResources(ctx context.Context, log logr.Logger, userland *escv1alpha2.Userland, namespace, targetNamespace string, names escv1alpha2.ResourceNames) error {
 	log.Info("finding existing Deployments for userland resource")
 
 	// List all deployment resources owned by this Userland resource
 	var deployments appsv1.DeploymentList
-	if err := r.List(ctx, &deployments, client.InNamespace(userland.Namespace), client.MatchingFields(map[string]string{resourceOwnerKey: userland.Name})); err != nil {
+	if err := r.List(ctx, &deployments, r.ownedListOptions(userland, namespace)...); err != nil {
 		return err
 	}
 
 	// Delete deployment if the deployment name doesn't match userland.spec.TemplateName
 	for _, deployment := range deployments.Items {
-		if deployment.Name == userland.Spec.TemplateName+"-"+userland.Name {
+		if deployment.Namespace == targetNamespace && deployment.Name == names.Deployment {
 			// If this deployment's name matches the one on the Userland resource
 			// then do not delete it.
 			continue
@@ -295,13 +441,13 @@ func (r *UserlandReconciler) cleanupOwnedResources(ctx context.Context, log logr
 	// Service
 	// List all Service resources owned by this Userland resource
 	var services corev1.ServiceList
-	if err := r.List(ctx, &services, client.InNamespace(userland.Namespace), client.MatchingFields(map[string]string{resourceOwnerKey: userland.Name})); err != nil {
+	if err := r.List(ctx, &services, r.ownedListOptions(userland, namespace)...); err != nil {
 		return err
 	}
 
 	// Delete deployment if the deployment name doesn't match userland.spec.TemplateName
 	for _, service := range services.Items {
-		if service.Name == userland.Spec.TemplateName+"-"+userland.Name+"-"+"svc" {
+		if service.Namespace == targetNamespace && service.Name == names.Service {
 			// If this service's name matches the one on the Userland resource
 			// then do not delete it.
 			continue
@@ -317,36 +463,15 @@ func (r *UserlandReconciler) cleanupOwnedResources(ctx context.Context, log logr
 		r.Recorder.Eventf(userland, corev1.EventTypeNormal, "Deleted", "Deleted service %q", service.Name)
 	}
 
-	// Service
-	// List all Service resources owned by this Userland resource
-	var persistentVolumeClaims corev1.PersistentVolumeClaimList
-	if err := r.List(ctx, &persistentVolumeClaims, client.InNamespace(userland.Namespace), client.MatchingFields(map[string]string{resourceOwnerKey: userland.Name})); err != nil {
-		return err
-	}
-
-	// // Delete deployment if the deployment name doesn't match userland.spec.TemplateName
-	// for _, persistentVolumeClaim := range persistentVolumeClaims.Items {
-	// 	if persistentVolumeClaim.Name == userland.Spec.TemplateName+"-"+userland.Name+"-"+"pvc" {
-	// 		// If this service's name matches the one on the Userland resource
-	// 		// then do not delete it.
-	// 		continue
-	// 	}
-
-	// 	// Delete old service object which doesn't match
-	// 	if err := r.Delete(ctx, &persistentVolumeClaim); err != nil {
-	// 		log.Error(err, "failed to delete persistentVolumeClaim resource")
-	// 		return err
-	// 	}
-
-	// 	log.Info("delete persistentVolumeClaim resource: " + persistentVolumeClaim.Name)
-	// 	r.Recorder.Eventf(userland, corev1.EventTypeNormal, "Deleted", "Deleted persistentVolumeClaim %q", persistentVolumeClaim.Name)
-	// }
+	// PersistentVolumeClaims are not deleted here, because they store the data of users.
+	// They are deleted by collectOrphanedVolumes after the grace period.
 
 	return nil
 }
 
 var (
 	resourceOwnerKey = ".metadata.controller"
+	templateNameKey  = ".spec.templateName"
 	apiGVStr         = escv1alpha2.GroupVersion.String()
 )
 
@@ -410,12 +535,83 @@ func (r *UserlandReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		return err
 	}
 
+	// add resourceOwnerKey index to pod object which is claimed from a warm pool by Userland resource
+	if err := mgr.GetFieldIndexer().IndexField(&corev1.Pod{}, resourceOwnerKey, func(rawObj runtime.Object) []string {
+		pod := rawObj.(*corev1.Pod)
+		owner := metav1.GetControllerOf(pod)
+		if owner == nil {
+			return nil
+		}
+		if owner.APIVersion != apiGVStr || owner.Kind != "Userland" {
+			return nil
+		}
+		return []string{owner.Name}
+	}); err != nil {
+		return err
+	}
+
+	// add templateNameKey index to find Userlands from the Template
+	if err := mgr.GetFieldIndexer().IndexField(&escv1alpha2.Userland{}, templateNameKey, func(rawObj runtime.Object) []string {
+		userland := rawObj.(*escv1alpha2.Userland)
+		return []string{userland.Spec.TemplateName}
+	}); err != nil {
+		return err
+	}
+
+	// add configRefKey index to find Deployments from the ConfigMaps and Secrets referenced by them
+	if err := mgr.GetFieldIndexer().IndexField(&appsv1.Deployment{}, configRefKey, indexConfigRefs); err != nil {
+		return err
+	}
+
+	// enqueue Userlands which use the changed Template or a Template derived from it
+	mapTemplate := handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+		ctx := context.Background()
+		derived, err := derivedTemplates(ctx, r.Client, obj.Meta.GetNamespace(), obj.Meta.GetName())
+		if err != nil {
+			r.Log.Error(err, "unable to list derived Templates", "template", obj.Meta.GetName())
+			return nil
+		}
+
+		var requests []reconcile.Request
+		for _, name := range append([]string{obj.Meta.GetName()}, derived...) {
+			var userlands escv1alpha2.UserlandList
+			if err := r.List(ctx, &userlands, client.InNamespace(obj.Meta.GetNamespace()), client.MatchingFields(map[string]string{templateNameKey: name})); err != nil {
+				r.Log.Error(err, "unable to list Userlands for Template", "template", name)
+				return nil
+			}
+			for _, userland := range userlands.Items {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: userland.Namespace, Name: userland.Name}})
+			}
+		}
+		return requests
+	})
+
+	secrets, err := watchSecrets(mgr)
+	if err != nil {
+		return err
+	}
+
 	// define to watch targets...Userland resource and owned Deployment
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&escv1alpha2.Template{}).
+	// Resources in the dedicated namespace of a Userland are watched by labels instead of ownerReferences.
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&escv1alpha2.Userland{}).
+		Watches(&source.Kind{Type: &escv1alpha2.Template{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: mapTemplate}).
 		Owns(&appsv1.Deployment{}).
 		Owns(&corev1.Service{}).
 		Owns(&corev1.PersistentVolumeClaim{}).
-		Complete(r)
+		Owns(&corev1.ServiceAccount{}).
+		Owns(&corev1.Pod{}).
+		Owns(&batchv1.Job{}).
+		Watches(&source.Kind{Type: &appsv1.Deployment{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: mapLabelledToUserland}).
+		Watches(&source.Kind{Type: &corev1.Service{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: mapLabelledToUserland}).
+		Watches(&source.Kind{Type: &corev1.PersistentVolumeClaim{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: mapLabelledToUserland}).
+		Watches(&source.Kind{Type: &batchv1.Job{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: mapLabelledToUserland}).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: r.mapConfigToUserland("ConfigMap")}).
+		Watches(secrets, &handler.EnqueueRequestsFromMapFunc{ToRequests: r.mapConfigToUserland("Secret")})
+
+	// Certificates and HTTPRoutes are watched only if their CRDs are installed.
+	b = r.ownsIfInstalled(mgr, b, render.CertificateGVK)
+	b = r.ownsIfInstalled(mgr, b, render.HTTPRouteGVK)
+
+	return b.Complete(r)
 }
@@ -0,0 +1,63 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+)
+
+var _ = Describe("Userland controller", func() {
+	ctx := context.Background()
+
+	It("keeps the names of existing resources", func() {
+		namespace := createNamespace()
+		Expect(k8sClient.Create(ctx, newTemplate(namespace, "vscode"))).To(Succeed())
+		userland := newUserland(namespace, "koba1t", "vscode")
+		userland.Spec.Name = "alice"
+		Expect(k8sClient.Create(ctx, userland)).To(Succeed())
+		key := types.NamespacedName{Namespace: namespace, Name: "koba1t"}
+
+		Eventually(func() string {
+			var current escv1alpha2.Userland
+			Expect(k8sClient.Get(ctx, key, &current)).To(Succeed())
+			return current.Status.ResourceNames.Deployment
+		}, timeout, interval).Should(Equal("vscode-alice"))
+
+		By("changing spec.Name")
+		Eventually(func() error {
+			var current escv1alpha2.Userland
+			if err := k8sClient.Get(ctx, key, &current); err != nil {
+				return err
+			}
+			current.Spec.Name = "bob"
+			return k8sClient.Update(ctx, &current)
+		}, timeout, interval).Should(Succeed())
+
+		Consistently(func() error {
+			return k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "vscode-alice"}, &appsv1.Deployment{})
+		}, "2s", interval).Should(Succeed())
+		var current escv1alpha2.Userland
+		Expect(k8sClient.Get(ctx, key, &current)).To(Succeed())
+		Expect(current.Status.ResourceNames.Deployment).To(Equal("vscode-alice"))
+	})
+})
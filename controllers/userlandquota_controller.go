@@ -0,0 +1,113 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/quota"
+)
+
+// UserlandQuotaReconciler reconciles a UserlandQuota object
+type UserlandQuotaReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=esc.k06.in,resources=userlandquotas,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=esc.k06.in,resources=userlandquotas/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=esc.k06.in,resources=userlands,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile loop for UserlandQuota resource
+// The limits are enforced by the webhook and the Userland controller, this loop reports the current usage.
+func (r *UserlandQuotaReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("userlandquota", req.NamespacedName)
+
+	// 1: Load the UserlandQuota resource by name
+	var q escv1alpha2.UserlandQuota
+	if err := r.Get(ctx, req.NamespacedName, &q); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			log.Error(err, "unable to fetch UserlandQuota")
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// 2: Aggregate the usage of the selected Userlands
+	var userlands escv1alpha2.UserlandList
+	if err := r.List(ctx, &userlands, client.InNamespace(q.Namespace)); err != nil {
+		return ctrl.Result{}, err
+	}
+	used, users, err := quota.Aggregate(&q, userlands.Items)
+	if err != nil {
+		r.Recorder.Event(&q, corev1.EventTypeWarning, "InvalidSelector", err.Error())
+		return ctrl.Result{}, nil
+	}
+
+	// 3: Update UserlandQuota Status
+	before := q.Status.DeepCopy()
+	q.Status.Used = used
+	q.Status.Users = users
+	q.Status.ObservedGeneration = q.Generation
+	if equality.Semantic.DeepEqual(before, &q.Status) {
+		return ctrl.Result{}, nil
+	}
+	if err := r.Status().Update(ctx, &q); err != nil {
+		log.Error(err, "unable to update UserlandQuota status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager setup with controller manager
+func (r *UserlandQuotaReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// enqueue the UserlandQuotas in the namespace of the changed Userland
+	mapUserland := handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+		var quotas escv1alpha2.UserlandQuotaList
+		if err := r.List(context.Background(), &quotas, client.InNamespace(obj.Meta.GetNamespace())); err != nil {
+			r.Log.Error(err, "unable to list UserlandQuotas for Userland", "userland", obj.Meta.GetName())
+			return nil
+		}
+
+		requests := make([]reconcile.Request, 0, len(quotas.Items))
+		for _, q := range quotas.Items {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: q.Namespace, Name: q.Name}})
+		}
+		return requests
+	})
+
+	// define to watch targets...UserlandQuota resource and Userlands in the namespace
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&escv1alpha2.UserlandQuota{}).
+		Watches(&source.Kind{Type: &escv1alpha2.Userland{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: mapUserland}).
+		Complete(r)
+}
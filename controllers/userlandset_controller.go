@@ -0,0 +1,304 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/naming"
+)
+
+const (
+	// userlandSetLabel is set to Userlands owned by a UserlandSet.
+	userlandSetLabel = "esc.k06.in/userlandset"
+
+	userRegistryKey = ".spec.userRegistry.configMapName"
+)
+
+// UserlandSetReconciler reconciles a UserlandSet object
+type UserlandSetReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// APIReader reads the UserlandSet from the API server, so that the users whose Userlands have just expired
+	// are not missed in a stale cache. The client is used if it is nil.
+	APIReader client.Reader
+}
+
+// +kubebuilder:rbac:groups=esc.k06.in,resources=userlandsets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=esc.k06.in,resources=userlandsets/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=esc.k06.in,resources=userlands,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile loop for UserlandSet resource
+func (r *UserlandSetReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("userlandset", req.NamespacedName)
+
+	// 1: Load the UserlandSet resource by name
+	reader := r.APIReader
+	if reader == nil {
+		reader = r.Client
+	}
+	var set escv1alpha2.UserlandSet
+	if err := reader.Get(ctx, req.NamespacedName, &set); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			log.Error(err, "unable to fetch UserlandSet")
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// 2: Resolve the users from spec.users and the user registry
+	users, err := r.resolveUsers(ctx, &set)
+	if err != nil {
+		log.Error(err, "unable to resolve users")
+		return ctrl.Result{}, err
+	}
+
+	// 3: Create or Update a Userland for each user, except the users whose Userland has expired
+	var expired []string
+	desired := map[string]bool{}
+	for _, user := range users {
+		if containsString(set.Status.ExpiredUsers, user) {
+			expired = append(expired, user)
+			continue
+		}
+		name := set.Name + "-" + user
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			r.Recorder.Eventf(&set, corev1.EventTypeWarning, "InvalidUser", "Skipped user %q: %s", user, errs[0])
+			continue
+		}
+		desired[name] = true
+
+		userland := &escv1alpha2.Userland{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: set.Namespace,
+			},
+		}
+
+		if _, err := ctrl.CreateOrUpdate(ctx, r.Client, userland, func() error {
+			if userland.Labels == nil {
+				userland.Labels = map[string]string{}
+			}
+			for k, v := range set.Spec.Overrides.Labels {
+				userland.Labels[k] = v
+			}
+			userland.Labels[userlandSetLabel] = set.Name
+			userland.Labels[naming.UserLabel] = user
+
+			if len(set.Spec.Overrides.Annotations) > 0 && userland.Annotations == nil {
+				userland.Annotations = map[string]string{}
+			}
+			for k, v := range set.Spec.Overrides.Annotations {
+				userland.Annotations[k] = v
+			}
+
+			userland.Spec.TemplateName = set.Spec.TemplateName
+			// the owner can't be changed once it is set
+			if userland.Spec.Owner == nil {
+				userland.Spec.Owner = &escv1alpha2.UserlandOwner{User: user}
+			}
+			userland.Spec.Enabled = set.Spec.Overrides.Enabled
+
+			// set the owner so that garbage collection can kicks in
+			if err := ctrl.SetControllerReference(&set, userland, r.Scheme); err != nil {
+				log.Error(err, "unable to set ownerReference from UserlandSet to Userland")
+				return err
+			}
+
+			return nil
+
+		}); err != nil {
+			// error handling of ctrl.CreateOrUpdate
+			log.Error(err, "unable to ensure userland is correct", "userland", name)
+			return ctrl.Result{}, err
+		}
+	}
+
+	// 4: Prune Userlands which are no longer listed
+	var userlands escv1alpha2.UserlandList
+	if err := r.List(ctx, &userlands, client.InNamespace(set.Namespace), client.MatchingFields(map[string]string{resourceOwnerKey: set.Name})); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	var owned []escv1alpha2.Userland
+	for i := range userlands.Items {
+		userland := userlands.Items[i]
+		if desired[userland.Name] {
+			owned = append(owned, userland)
+			continue
+		}
+
+		if err := r.Delete(ctx, &userland); client.IgnoreNotFound(err) != nil {
+			log.Error(err, "failed to delete Userland resource")
+			return ctrl.Result{}, err
+		}
+
+		log.Info("delete userland resource: " + userland.Name)
+		r.Recorder.Eventf(&set, corev1.EventTypeNormal, "Deleted", "Deleted userland %q", userland.Name)
+	}
+
+	// 5: Update UserlandSet Status
+	if err := r.updateStatus(ctx, &set, owned, expired); err != nil {
+		log.Error(err, "unable to update UserlandSet status")
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// resolveUsers returns the sorted and unique user names from spec.users and the user registry.
+func (r *UserlandSetReconciler) resolveUsers(ctx context.Context, set *escv1alpha2.UserlandSet) ([]string, error) {
+	found := map[string]bool{}
+	for _, user := range set.Spec.Users {
+		found[user] = true
+	}
+
+	if registry := set.Spec.UserRegistry; registry != nil {
+		var cm corev1.ConfigMap
+		if err := r.Get(ctx, types.NamespacedName{Namespace: set.Namespace, Name: registry.ConfigMapName}, &cm); err != nil {
+			return nil, err
+		}
+
+		selector := labels.Everything()
+		if registry.Selector != nil {
+			s, err := metav1.LabelSelectorAsSelector(registry.Selector)
+			if err != nil {
+				return nil, err
+			}
+			selector = s
+		}
+
+		for user, value := range cm.Data {
+			userLabels, err := labels.ConvertSelectorToLabelsMap(value)
+			if err != nil {
+				r.Recorder.Eventf(set, corev1.EventTypeWarning, "InvalidUser", "Skipped user %q: invalid labels %q", user, value)
+				continue
+			}
+			if selector.Matches(userLabels) {
+				found[user] = true
+			}
+		}
+	}
+
+	users := make([]string, 0, len(found))
+	for user := range found {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+// updateStatus aggregates the readiness of owned Userlands, and records the users still listed whose Userland has expired.
+func (r *UserlandSetReconciler) updateStatus(ctx context.Context, set *escv1alpha2.UserlandSet, owned []escv1alpha2.Userland, expired []string) error {
+	before := set.Status.DeepCopy()
+
+	set.Status.Replicas = int32(len(owned))
+	set.Status.ReadyReplicas = 0
+	set.Status.NotReadyUsers = nil
+	for i := range owned {
+		if isUserlandConditionTrue(&owned[i].Status, escv1alpha2.UserlandReady) {
+			set.Status.ReadyReplicas++
+			continue
+		}
+		set.Status.NotReadyUsers = append(set.Status.NotReadyUsers, owned[i].Labels[naming.UserLabel])
+	}
+	sort.Strings(set.Status.NotReadyUsers)
+	set.Status.ExpiredUsers = expired
+	set.Status.ObservedGeneration = set.Generation
+
+	if equality.Semantic.DeepEqual(before, &set.Status) {
+		return nil
+	}
+	return r.Status().Update(ctx, set)
+}
+
+// SetupWithManager setup with controller manager
+func (r *UserlandSetReconciler) SetupWithManager(mgr ctrl.Manager) error {
+
+	// add resourceOwnerKey index to userland object which UserlandSet resource owns
+	if err := mgr.GetFieldIndexer().IndexField(&escv1alpha2.Userland{}, resourceOwnerKey, func(rawObj runtime.Object) []string {
+		// grab the userland object, extract the owner...
+		userland := rawObj.(*escv1alpha2.Userland)
+		owner := metav1.GetControllerOf(userland)
+		if owner == nil {
+			return nil
+		}
+		// ...make sure it's a UserlandSet...
+		if owner.APIVersion != apiGVStr || owner.Kind != "UserlandSet" {
+			return nil
+		}
+
+		// ...and if so, return it
+		return []string{owner.Name}
+	}); err != nil {
+		return err
+	}
+
+	// add userRegistryKey index to find UserlandSets from the registry ConfigMap
+	if err := mgr.GetFieldIndexer().IndexField(&escv1alpha2.UserlandSet{}, userRegistryKey, func(rawObj runtime.Object) []string {
+		set := rawObj.(*escv1alpha2.UserlandSet)
+		if set.Spec.UserRegistry == nil {
+			return nil
+		}
+		return []string{set.Spec.UserRegistry.ConfigMapName}
+	}); err != nil {
+		return err
+	}
+
+	// enqueue UserlandSets which refer to the changed ConfigMap as a user registry
+	mapRegistry := handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+		var sets escv1alpha2.UserlandSetList
+		if err := r.List(context.Background(), &sets, client.InNamespace(obj.Meta.GetNamespace()), client.MatchingFields(map[string]string{userRegistryKey: obj.Meta.GetName()})); err != nil {
+			r.Log.Error(err, "unable to list UserlandSets for ConfigMap", "configmap", obj.Meta.GetName())
+			return nil
+		}
+
+		requests := make([]reconcile.Request, 0, len(sets.Items))
+		for _, set := range sets.Items {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: set.Namespace, Name: set.Name}})
+		}
+		return requests
+	})
+
+	// define to watch targets...UserlandSet resource, owned Userland and user registry ConfigMap
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&escv1alpha2.UserlandSet{}).
+		Owns(&escv1alpha2.Userland{}).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: mapRegistry}).
+		Complete(r)
+}
@@ -0,0 +1,80 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/naming"
+)
+
+var _ = Describe("UserlandSet controller", func() {
+	ctx := context.Background()
+
+	It("creates a Userland for each user and prunes the Userlands of removed users", func() {
+		namespace := createNamespace()
+		Expect(k8sClient.Create(ctx, newTemplate(namespace, "vscode"))).To(Succeed())
+
+		set := &escv1alpha2.UserlandSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "workshop", Namespace: namespace},
+			Spec: escv1alpha2.UserlandSetSpec{
+				TemplateName: "vscode",
+				Users:        []string{"alice", "bob"},
+			},
+		}
+		Expect(k8sClient.Create(ctx, set)).To(Succeed())
+
+		for _, user := range set.Spec.Users {
+			var userland escv1alpha2.Userland
+			Eventually(func() error {
+				return k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "workshop-" + user}, &userland)
+			}, timeout, interval).Should(Succeed())
+			Expect(userland.Spec.TemplateName).To(Equal("vscode"))
+			Expect(userland.Labels).To(HaveKeyWithValue(naming.UserLabel, user))
+			Expect(userland.Spec.Owner).To(Equal(&escv1alpha2.UserlandOwner{User: user}))
+			Expect(metav1.IsControlledBy(&userland, set)).To(BeTrue())
+		}
+
+		Eventually(func() int32 {
+			var current escv1alpha2.UserlandSet
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "workshop"}, &current)).To(Succeed())
+			return current.Status.Replicas
+		}, timeout, interval).Should(Equal(int32(2)))
+
+		By("removing a user")
+		Eventually(func() error {
+			var current escv1alpha2.UserlandSet
+			if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "workshop"}, &current); err != nil {
+				return err
+			}
+			current.Spec.Users = []string{"alice"}
+			return k8sClient.Update(ctx, &current)
+		}, timeout, interval).Should(Succeed())
+
+		Eventually(func() bool {
+			err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "workshop-bob"}, &escv1alpha2.Userland{})
+			return apierrors.IsNotFound(err)
+		}, timeout, interval).Should(BeTrue())
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "workshop-alice"}, &escv1alpha2.Userland{})).To(Succeed())
+	})
+})
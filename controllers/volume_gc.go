@@ -0,0 +1,194 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+)
+
+const (
+	// orphanedAtAnnotation records when a PersistentVolumeClaim was found to be unused by the Userland.
+	orphanedAtAnnotation = "esc.k06.in/orphaned-at"
+	// retainAnnotation pins an orphaned PersistentVolumeClaim, so that it is never deleted by the controller.
+	retainAnnotation = "esc.k06.in/retain"
+)
+
+// collectOrphanedVolumes marks PersistentVolumeClaims owned by the Userland which are not in inUse,
+// and deletes them after the grace period unless they are pinned by retainAnnotation.
+// It records the orphaned claims in the status, and returns the duration until the next deletion.
+func (r *UserlandReconciler) collectOrphanedVolumes(ctx context.Context, log logr.Logger, userland *escv1alpha2.Userland, namespace string, inUse map[string]bool) (time.Duration, error) {
+	namespaces := []string{namespace}
+	if namespace != userland.Namespace {
+		// Claims created before namespacePerUser was enabled are left in the namespace of the Userland.
+		namespaces = append(namespaces, userland.Namespace)
+	}
+
+	now := time.Now()
+	var requeueAfter time.Duration
+	var orphaned []escv1alpha2.OrphanedVolume
+
+	for _, ns := range namespaces {
+		var persistentVolumeClaims corev1.PersistentVolumeClaimList
+		if err := r.List(ctx, &persistentVolumeClaims, r.ownedListOptions(userland, ns)...); err != nil {
+			return 0, err
+		}
+
+		for i := range persistentVolumeClaims.Items {
+			persistentVolumeClaim := &persistentVolumeClaims.Items[i]
+			if (ns == namespace && inUse[persistentVolumeClaim.Name]) || persistentVolumeClaim.DeletionTimestamp != nil {
+				continue
+			}
+
+			// Mark the claim as orphaned
+			orphanedAt, err := time.Parse(time.RFC3339, persistentVolumeClaim.Annotations[orphanedAtAnnotation])
+			if err != nil {
+				orphanedAt = now
+				if persistentVolumeClaim.Annotations == nil {
+					persistentVolumeClaim.Annotations = map[string]string{}
+				}
+				persistentVolumeClaim.Annotations[orphanedAtAnnotation] = orphanedAt.Format(time.RFC3339)
+				if err := r.Update(ctx, persistentVolumeClaim); err != nil {
+					log.Error(err, "failed to mark persistentVolumeClaim as orphaned")
+					return 0, err
+				}
+				r.Recorder.Eventf(userland, corev1.EventTypeNormal, "Orphaned", "PersistentVolumeClaim %q is no longer used", persistentVolumeClaim.Name)
+			}
+
+			volume := escv1alpha2.OrphanedVolume{
+				Name:       persistentVolumeClaim.Name,
+				Namespace:  persistentVolumeClaim.Namespace,
+				OrphanedAt: metav1.NewTime(orphanedAt),
+			}
+
+			if persistentVolumeClaim.Annotations[retainAnnotation] != "true" {
+				deleteAfter := orphanedAt.Add(r.OrphanedVolumeGracePeriod)
+				if !now.Before(deleteAfter) {
+					if err := r.Delete(ctx, persistentVolumeClaim); client.IgnoreNotFound(err) != nil {
+						log.Error(err, "failed to delete persistentVolumeClaim resource")
+						return 0, err
+					}
+
+					log.Info("delete persistentVolumeClaim resource: " + persistentVolumeClaim.Name)
+					r.Recorder.Eventf(userland, corev1.EventTypeNormal, "Deleted", "Deleted persistentVolumeClaim %q", persistentVolumeClaim.Name)
+					continue
+				}
+
+				if wait := deleteAfter.Sub(now); requeueAfter == 0 || wait < requeueAfter {
+					requeueAfter = wait
+				}
+				t := metav1.NewTime(deleteAfter)
+				volume.DeleteAfter = &t
+			}
+
+			orphaned = append(orphaned, volume)
+		}
+	}
+
+	userland.Status.OrphanedVolumes = orphaned
+	return requeueAfter, nil
+}
+
+// releaseRetainedVolumes removes the ownerReference of the Userland from PersistentVolumeClaims pinned by
+// retainAnnotation, so that they are not garbage collected when the Userland is deleted by the controller.
+func (r *UserlandReconciler) releaseRetainedVolumes(ctx context.Context, log logr.Logger, userland *escv1alpha2.Userland) error {
+	var persistentVolumeClaims corev1.PersistentVolumeClaimList
+	if err := r.List(ctx, &persistentVolumeClaims, r.ownedListOptions(userland, userland.Namespace)...); err != nil {
+		return err
+	}
+
+	for i := range persistentVolumeClaims.Items {
+		persistentVolumeClaim := &persistentVolumeClaims.Items[i]
+		if persistentVolumeClaim.Annotations[retainAnnotation] != "true" {
+			continue
+		}
+
+		patch := client.MergeFrom(persistentVolumeClaim.DeepCopy())
+		var ownerReferences []metav1.OwnerReference
+		for _, ref := range persistentVolumeClaim.OwnerReferences {
+			if ref.UID != userland.UID {
+				ownerReferences = append(ownerReferences, ref)
+			}
+		}
+		persistentVolumeClaim.OwnerReferences = ownerReferences
+		if err := r.Patch(ctx, persistentVolumeClaim, patch); err != nil {
+			log.Error(err, "failed to release retained persistentVolumeClaim")
+			return err
+		}
+		r.Recorder.Eventf(userland, corev1.EventTypeNormal, "Retained", "Released retained persistentVolumeClaim %q", persistentVolumeClaim.Name)
+	}
+	return nil
+}
+
+// hasRetainedVolumes returns true if the namespace has PersistentVolumeClaims pinned by retainAnnotation.
+func (r *UserlandReconciler) hasRetainedVolumes(ctx context.Context, namespace string) (bool, error) {
+	var persistentVolumeClaims corev1.PersistentVolumeClaimList
+	if err := r.List(ctx, &persistentVolumeClaims, client.InNamespace(namespace)); err != nil {
+		return false, err
+	}
+	for _, persistentVolumeClaim := range persistentVolumeClaims.Items {
+		if persistentVolumeClaim.Annotations[retainAnnotation] == "true" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// volumeConflict returns the reason why the existing claim can't be used as the desired claim, or an empty string.
+// The access modes and the storage class of a claim can't be changed after it is created.
+func volumeConflict(current, desired *corev1.PersistentVolumeClaim) string {
+	if current.CreationTimestamp.IsZero() {
+		return ""
+	}
+	if !sameAccessModes(current.Spec.AccessModes, desired.Spec.AccessModes) {
+		return fmt.Sprintf("persistentVolumeClaim %q has access modes %v, but the Template requires %v", current.Name, current.Spec.AccessModes, desired.Spec.AccessModes)
+	}
+	if desired.Spec.StorageClassName != nil && current.Spec.StorageClassName != nil && *desired.Spec.StorageClassName != *current.Spec.StorageClassName {
+		return fmt.Sprintf("persistentVolumeClaim %q has storage class %q, but the Template requires %q", current.Name, *current.Spec.StorageClassName, *desired.Spec.StorageClassName)
+	}
+	return ""
+}
+
+func sameAccessModes(a, b []corev1.PersistentVolumeAccessMode) bool {
+	set := func(modes []corev1.PersistentVolumeAccessMode) map[corev1.PersistentVolumeAccessMode]bool {
+		m := map[corev1.PersistentVolumeAccessMode]bool{}
+		for _, mode := range modes {
+			m[mode] = true
+		}
+		return m
+	}
+	return reflect.DeepEqual(set(a), set(b))
+}
+
+// keepLargerStorage keeps the storage requested by the current claim if it is larger than the desired one.
+func keepLargerStorage(current, desired *corev1.PersistentVolumeClaim) {
+	q, ok := current.Spec.Resources.Requests[corev1.ResourceStorage]
+	if !ok {
+		return
+	}
+	if d, ok := desired.Spec.Resources.Requests[corev1.ResourceStorage]; ok && d.Cmp(q) < 0 {
+		desired.Spec.Resources.Requests[corev1.ResourceStorage] = q
+	}
+}
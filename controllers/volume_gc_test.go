@@ -0,0 +1,146 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+)
+
+var _ = Describe("Orphaned volumes", func() {
+	ctx := context.Background()
+
+	var namespace, claim string
+	var key types.NamespacedName
+	BeforeEach(func() {
+		namespace = createNamespace()
+		Expect(k8sClient.Create(ctx, withVolume(newTemplate(namespace, "vscode")))).To(Succeed())
+		Expect(k8sClient.Create(ctx, newTemplate(namespace, "plain"))).To(Succeed())
+		Expect(k8sClient.Create(ctx, newUserland(namespace, "koba1t", "vscode"))).To(Succeed())
+		key = types.NamespacedName{Namespace: namespace, Name: "koba1t"}
+
+		Eventually(func() string {
+			var current escv1alpha2.Userland
+			Expect(k8sClient.Get(ctx, key, &current)).To(Succeed())
+			claim = current.Status.ResourceNames.PersistentVolumeClaims["data"]
+			return claim
+		}, timeout, interval).ShouldNot(BeEmpty())
+		Eventually(func() error {
+			return k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: claim}, &corev1.PersistentVolumeClaim{})
+		}, timeout, interval).Should(Succeed())
+	})
+
+	// switchTemplate changes the Template of the Userland.
+	switchTemplate := func(name string) {
+		Eventually(func() error {
+			var current escv1alpha2.Userland
+			if err := k8sClient.Get(ctx, key, &current); err != nil {
+				return err
+			}
+			current.Spec.TemplateName = name
+			return k8sClient.Update(ctx, &current)
+		}, timeout, interval).Should(Succeed())
+	}
+
+	// retain pins the claim of the Userland.
+	retain := func() {
+		Eventually(func() error {
+			var pvc corev1.PersistentVolumeClaim
+			if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: claim}, &pvc); err != nil {
+				return err
+			}
+			pvc.Annotations = map[string]string{retainAnnotation: "true"}
+			return k8sClient.Update(ctx, &pvc)
+		}, timeout, interval).Should(Succeed())
+	}
+
+	// getClaim returns the claim of the Userland.
+	getClaim := func() *corev1.PersistentVolumeClaim {
+		var pvc corev1.PersistentVolumeClaim
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: claim}, &pvc)).To(Succeed())
+		return &pvc
+	}
+
+	It("deletes a claim no longer used after the grace period", func() {
+		switchTemplate("plain")
+
+		// the grace period of the suite is zero
+		Eventually(func() bool {
+			var pvc corev1.PersistentVolumeClaim
+			err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: claim}, &pvc)
+			return apierrors.IsNotFound(err) || (err == nil && pvc.DeletionTimestamp != nil)
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("keeps a retained claim and unmarks it when it is used again", func() {
+		retain()
+		switchTemplate("plain")
+
+		Eventually(func() []escv1alpha2.OrphanedVolume {
+			var current escv1alpha2.Userland
+			Expect(k8sClient.Get(ctx, key, &current)).To(Succeed())
+			return current.Status.OrphanedVolumes
+		}, timeout, interval).Should(ConsistOf(WithTransform(func(v escv1alpha2.OrphanedVolume) bool {
+			return v.Name == claim && v.DeleteAfter == nil
+		}, BeTrue())))
+		Expect(getClaim().Annotations).To(HaveKey(orphanedAtAnnotation))
+		Consistently(func() *metav1.Time {
+			return getClaim().DeletionTimestamp
+		}, "2s", interval).Should(BeNil())
+
+		By("switching back to the Template of the claim")
+		switchTemplate("vscode")
+		Eventually(func() map[string]string {
+			return getClaim().Annotations
+		}, timeout, interval).ShouldNot(HaveKey(orphanedAtAnnotation))
+		Eventually(func() []escv1alpha2.OrphanedVolume {
+			var current escv1alpha2.Userland
+			Expect(k8sClient.Get(ctx, key, &current)).To(Succeed())
+			return current.Status.OrphanedVolumes
+		}, timeout, interval).Should(BeEmpty())
+	})
+
+	It("releases a retained claim when the Userland expires", func() {
+		var userland escv1alpha2.Userland
+		Expect(k8sClient.Get(ctx, key, &userland)).To(Succeed())
+		Expect(metav1.IsControlledBy(getClaim(), &userland)).To(BeTrue())
+		retain()
+
+		Eventually(func() error {
+			var current escv1alpha2.Userland
+			if err := k8sClient.Get(ctx, key, &current); err != nil {
+				return err
+			}
+			past := metav1.NewTime(time.Now().Add(-time.Minute))
+			current.Spec.ExpiresAt = &past
+			return k8sClient.Update(ctx, &current)
+		}, timeout, interval).Should(Succeed())
+
+		Eventually(func() bool {
+			return apierrors.IsNotFound(k8sClient.Get(ctx, key, &escv1alpha2.Userland{}))
+		}, timeout, interval).Should(BeTrue())
+		Expect(metav1.IsControlledBy(getClaim(), &userland)).To(BeFalse())
+	})
+})
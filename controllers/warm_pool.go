@@ -0,0 +1,123 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/naming"
+	"github.com/koba1t/ESC/pkg/render"
+)
+
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;update;delete
+
+// reconcileWarmPod serves the Userland by a pod claimed from the warm pool of its Template while the Deployment
+// has no available pod, and deletes the claimed pod once the Deployment becomes available.
+// It returns true if the claimed pod is ready.
+func (r *UserlandReconciler) reconcileWarmPod(ctx context.Context, log logr.Logger, userland *escv1alpha2.Userland, spec *escv1alpha2.TemplateSpec, deploy *appsv1.Deployment) (bool, error) {
+	// Pods of Deployments are owned by ReplicaSets, so pods owned by the Userland are claimed pods.
+	var claimed corev1.PodList
+	if err := r.List(ctx, &claimed, r.ownedListOptions(userland, userland.Namespace)...); err != nil {
+		return false, err
+	}
+
+	starting := (deploy.Spec.Replicas == nil || *deploy.Spec.Replicas > 0) && deploy.Status.AvailableReplicas == 0
+	if !starting {
+		for i := range claimed.Items {
+			pod := &claimed.Items[i]
+			if err := r.Delete(ctx, pod); client.IgnoreNotFound(err) != nil {
+				log.Error(err, "failed to delete claimed warm pod")
+				return false, err
+			}
+			log.Info("delete claimed warm pod resource: " + pod.Name)
+		}
+		return false, nil
+	}
+
+	for i := range claimed.Items {
+		if claimed.Items[i].DeletionTimestamp == nil {
+			return isPodReady(&claimed.Items[i]), nil
+		}
+	}
+
+	if spec.WarmPool == nil || deploy.Namespace != userland.Namespace {
+		return false, nil
+	}
+
+	var pool corev1.PodList
+	if err := r.List(ctx, &pool, client.InNamespace(userland.Namespace), client.MatchingLabels{render.WarmPoolLabel: naming.Truncate(userland.Spec.TemplateName, naming.MaxLength)}); err != nil {
+		return false, err
+	}
+	for i := range pool.Items {
+		pod := &pool.Items[i]
+		if pod.DeletionTimestamp != nil || !isPodReady(pod) || !isWarmPodOf(pod, userland.Spec.TemplateName) {
+			continue
+		}
+
+		// Select the pod by the Service of the Userland, and hand it over from the Template to the Userland.
+		delete(pod.Labels, render.WarmPoolLabel)
+		for k, v := range deploy.Spec.Template.Labels {
+			pod.Labels[k] = v
+		}
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[naming.UserLabel] = naming.User(userland)
+		pod.OwnerReferences = nil
+		if err := ctrl.SetControllerReference(userland, pod, r.Scheme); err != nil {
+			return false, err
+		}
+
+		// The resourceVersion of the pod prevents it from being claimed by two Userlands.
+		if err := r.Update(ctx, pod); err != nil {
+			if apierrors.IsConflict(err) || apierrors.IsNotFound(err) {
+				continue
+			}
+			log.Error(err, "unable to claim warm pod")
+			return false, err
+		}
+
+		r.Recorder.Eventf(userland, corev1.EventTypeNormal, "Claimed", "Claimed warm pod %q", pod.Name)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// isWarmPodOf returns true if the pod is controlled by the Template, so that pods labelled by others are not claimed.
+func isWarmPodOf(pod *corev1.Pod, template string) bool {
+	owner := metav1.GetControllerOf(pod)
+	return owner != nil && owner.Kind == "Template" && owner.Name == template
+}
+
+// isPodReady returns true if the pod has the Ready condition.
+func isPodReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
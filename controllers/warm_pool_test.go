@@ -0,0 +1,130 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/render"
+)
+
+var _ = Describe("Warm pool", func() {
+	ctx := context.Background()
+
+	// warmPods returns the names of the unclaimed pods in the pool of the Template.
+	warmPods := func(template *escv1alpha2.Template) []string {
+		var pods corev1.PodList
+		Expect(k8sClient.List(ctx, &pods, client.InNamespace(template.Namespace), client.MatchingLabels{render.WarmPoolLabel: template.Name})).To(Succeed())
+		var names []string
+		for i := range pods.Items {
+			if pods.Items[i].DeletionTimestamp == nil && metav1.IsControlledBy(&pods.Items[i], template) {
+				names = append(names, pods.Items[i].Name)
+			}
+		}
+		return names
+	}
+
+	// setWarmPool updates the size of the warm pool of the Template.
+	setWarmPool := func(template *escv1alpha2.Template, size int32) {
+		Eventually(func() error {
+			if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: template.Namespace, Name: template.Name}, template); err != nil {
+				return err
+			}
+			template.Spec.WarmPool = &escv1alpha2.WarmPoolSpec{Size: size}
+			return k8sClient.Update(ctx, template)
+		}, timeout, interval).Should(Succeed())
+	}
+
+	It("keeps the size of the pool and leaves pods labelled by others", func() {
+		namespace := createNamespace()
+		foreign := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "foreign", Namespace: namespace, Labels: map[string]string{render.WarmPoolLabel: "vscode"}},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "foreign", Image: "example.com/foreign"}}},
+		}
+		Expect(k8sClient.Create(ctx, foreign)).To(Succeed())
+
+		template := newTemplate(namespace, "vscode")
+		template.Spec.WarmPool = &escv1alpha2.WarmPoolSpec{Size: 2}
+		Expect(k8sClient.Create(ctx, template)).To(Succeed())
+		Eventually(func() []string { return warmPods(template) }, timeout, interval).Should(HaveLen(2))
+
+		By("shrinking the pool")
+		setWarmPool(template, 1)
+		Eventually(func() []string { return warmPods(template) }, timeout, interval).Should(HaveLen(1))
+		Consistently(func() error {
+			return k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "foreign"}, &corev1.Pod{})
+		}, "2s", interval).Should(Succeed())
+	})
+
+	It("serves a starting Userland by a warm pod without reporting it ready", func() {
+		namespace := createNamespace()
+		template := newTemplate(namespace, "vscode")
+		template.Spec.WarmPool = &escv1alpha2.WarmPoolSpec{Size: 1}
+		Expect(k8sClient.Create(ctx, template)).To(Succeed())
+
+		var warm string
+		Eventually(func() []string {
+			names := warmPods(template)
+			if len(names) > 0 {
+				warm = names[0]
+			}
+			return names
+		}, timeout, interval).Should(HaveLen(1))
+
+		By("making the warm pod ready")
+		Eventually(func() error {
+			var pod corev1.Pod
+			if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: warm}, &pod); err != nil {
+				return err
+			}
+			pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+			return k8sClient.Status().Update(ctx, &pod)
+		}, timeout, interval).Should(Succeed())
+
+		userland := newUserland(namespace, "koba1t", "vscode")
+		Expect(k8sClient.Create(ctx, userland)).To(Succeed())
+
+		By("claiming the warm pod")
+		Eventually(func() string {
+			var current escv1alpha2.Userland
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "koba1t"}, &current)).To(Succeed())
+			if c := getUserlandCondition(&current.Status, escv1alpha2.UserlandReady); c != nil && c.Status == corev1.ConditionFalse {
+				return c.Reason
+			}
+			return ""
+		}, timeout, interval).Should(Equal("WarmPodAvailable"))
+
+		var current escv1alpha2.Userland
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "koba1t"}, &current)).To(Succeed())
+		Expect(current.Status.Phase).To(Equal(escv1alpha2.UserlandPending))
+
+		var pod corev1.Pod
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: warm}, &pod)).To(Succeed())
+		Expect(pod.Labels).NotTo(HaveKey(render.WarmPoolLabel))
+		Expect(metav1.IsControlledBy(&pod, &current)).To(BeTrue())
+
+		By("refilling the pool")
+		Eventually(func() []string { return warmPods(template) }, timeout, interval).Should(ConsistOf(Not(Equal(warm))))
+	})
+})
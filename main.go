@@ -18,11 +18,17 @@ package main
 import (
 	"flag"
 	"os"
+	"strings"
 	"time"
 
 	escv1alpha1 "github.com/koba1t/ESC/api/v1alpha1"
 	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
 	"github.com/koba1t/ESC/controllers"
+	"github.com/koba1t/ESC/pkg/naming"
+	"github.com/koba1t/ESC/pkg/notify"
+	"github.com/koba1t/ESC/pkg/proxy"
+	"github.com/koba1t/ESC/pkg/render"
+	"github.com/koba1t/ESC/webhooks"
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
@@ -47,9 +53,39 @@ func init() {
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var orphanedVolumeGracePeriod time.Duration
+	var namingPattern string
+	var expiryWarningPeriod time.Duration
+	var pauseImage string
+	var proxyAddr string
+	var proxyUserHeader string
+	var proxyGroupsHeader string
+	var enableWebhooks bool
+	var trustedGroups string
+	var bindableClusterRoles string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
+	flag.DurationVar(&orphanedVolumeGracePeriod, "orphaned-volume-grace-period", 24*time.Hour,
+		"The duration to keep PersistentVolumeClaims which are no longer used by the Template of the Userland.")
+	flag.StringVar(&namingPattern, "naming-pattern", naming.DefaultPattern,
+		"The pattern of the names of resources owned by Userlands. $(TEMPLATE), $(NAME), $(NAMESPACE) and $(USER) are replaced.")
+	flag.DurationVar(&expiryWarningPeriod, "expiry-warning-period", time.Hour,
+		"The duration before a Userland is deleted by its TTL, expiresAt or deleteAfterIdle to warn its user.")
+	flag.StringVar(&pauseImage, "pause-image", render.DefaultPauseImage,
+		"The image of the container which keeps the pods pulling the images of Templates running.")
+	flag.StringVar(&proxyAddr, "proxy-addr", "",
+		"The address the proxy to Userlands under /u/<namespace>/<userland>/ binds to. The proxy is disabled if it is empty.")
+	flag.StringVar(&proxyUserHeader, "proxy-user-header", proxy.DefaultUserHeader,
+		"The header which carries the name of the user authenticated by a proxy in front of the proxy to Userlands.")
+	flag.StringVar(&proxyGroupsHeader, "proxy-groups-header", proxy.DefaultGroupsHeader,
+		"The header which carries the comma separated groups of the user authenticated by a proxy in front of the proxy to Userlands.")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", false,
+		"Enable the admission webhooks. The serving certificate must be in /tmp/k8s-webhook-server/serving-certs.")
+	flag.StringVar(&trustedGroups, "trusted-groups", "system:masters,system:serviceaccounts:esc-system",
+		"The comma separated groups whose members can create Userlands and UserlandSets on behalf of other users.")
+	flag.StringVar(&bindableClusterRoles, "bindable-cluster-roles", "admin,edit,view",
+		"The comma separated ClusterRoles which the roleBindings of namespacePerUser in Templates can refer to.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(func(o *zap.Options) {
@@ -70,25 +106,89 @@ func main() {
 		os.Exit(1)
 	}
 
-	// if err = (&controllers.TemplateReconciler{
-	// 	Client: mgr.GetClient(),
-	// 	Log:    ctrl.Log.WithName("controllers").WithName("Template"),
-	// 	Scheme: mgr.GetScheme(),
-	// }).SetupWithManager(mgr); err != nil {
-	// 	setupLog.Error(err, "unable to create controller", "controller", "Template")
-	// 	os.Exit(1)
-	// }
+	if err = (&controllers.TemplateReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("Template"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("template-controller"),
+
+		PauseImage: pauseImage,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Template")
+		os.Exit(1)
+	}
+	notifier := &notify.Dispatcher{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("notify"),
+	}
+	if err := mgr.Add(notifier); err != nil {
+		setupLog.Error(err, "unable to add notification dispatcher")
+		os.Exit(1)
+	}
 	if err = (&controllers.UserlandReconciler{
 		Client:   mgr.GetClient(),
 		Log:      ctrl.Log.WithName("controllers").WithName("Userland"),
 		Scheme:   mgr.GetScheme(),
 		Recorder: mgr.GetEventRecorderFor("userland-controller"),
+
+		OrphanedVolumeGracePeriod: orphanedVolumeGracePeriod,
+		Namer:                     naming.Namer{Pattern: namingPattern},
+		ExpiryWarningPeriod:       expiryWarningPeriod,
+		Notifier:                  notifier,
+		APIReader:                 mgr.GetAPIReader(),
+		BindableClusterRoles:      strings.Split(bindableClusterRoles, ","),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Userland")
 		os.Exit(1)
 	}
+	if err = (&controllers.UserlandSetReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("UserlandSet"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("userlandset-controller"),
+
+		APIReader: mgr.GetAPIReader(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "UserlandSet")
+		os.Exit(1)
+	}
+	if err = (&controllers.UserlandQuotaReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("UserlandQuota"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("userlandquota-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "UserlandQuota")
+		os.Exit(1)
+	}
+	if enableWebhooks {
+		if err := webhooks.SetupWithManager(mgr, webhooks.Options{
+			Namer:         naming.Namer{Pattern: namingPattern},
+			TrustedGroups: strings.Split(trustedGroups, ","),
+		}); err != nil {
+			setupLog.Error(err, "unable to create webhooks")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
+	if proxyAddr != "" {
+		if err := mgr.Add(&proxy.Server{
+			Addr: proxyAddr,
+			Proxy: &proxy.Proxy{
+				Client: mgr.GetClient(),
+				Log:    ctrl.Log.WithName("proxy"),
+
+				UserHeader:       proxyUserHeader,
+				GroupsHeader:     proxyGroupsHeader,
+				ActivityInterval: proxy.DefaultActivityInterval,
+			},
+		}); err != nil {
+			setupLog.Error(err, "unable to add proxy")
+			os.Exit(1)
+		}
+	}
+
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
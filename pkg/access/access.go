@@ -0,0 +1,111 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package access decides which users can use a Userland from its owner and collaborators.
+package access
+
+import (
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/naming"
+)
+
+// Owner returns the name of the user who owns the Userland, which is spec.owner.user if it is set,
+// otherwise the user in the esc.k06.in/user label or the name of the Userland.
+func Owner(userland *escv1alpha2.Userland) string {
+	return naming.Owner(userland)
+}
+
+// Allowed returns true if the user, who is a member of the groups, is the owner or a collaborator of the Userland.
+func Allowed(userland *escv1alpha2.Userland, user string, groups []string) bool {
+	if user == "" {
+		return false
+	}
+	if Owner(userland) == user {
+		return true
+	}
+	for _, c := range userland.Spec.Collaborators {
+		switch c.Kind {
+		case escv1alpha2.GroupCollaborator:
+			for _, g := range groups {
+				if g == c.Name {
+					return true
+				}
+			}
+		default:
+			if c.Name == user {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CollaboratorSubjects returns the RBAC subjects of the collaborators of the Userland.
+func CollaboratorSubjects(userland *escv1alpha2.Userland) []rbacv1.Subject {
+	var subjects []rbacv1.Subject
+	for _, c := range userland.Spec.Collaborators {
+		kind := rbacv1.UserKind
+		if c.Kind == escv1alpha2.GroupCollaborator {
+			kind = rbacv1.GroupKind
+		}
+		subjects = append(subjects, rbacv1.Subject{APIGroup: rbacv1.GroupName, Kind: kind, Name: c.Name})
+	}
+	return subjects
+}
+
+// AllowedSubjects returns the subjects allowed to create Userlands of the Template in the namespace,
+// with the namespaces of service accounts defaulted. It is the group of all authenticated users if
+// the Template has no allowed subjects.
+func AllowedSubjects(spec *escv1alpha2.TemplateSpec, namespace string) []rbacv1.Subject {
+	if len(spec.AllowedSubjects) == 0 {
+		return []rbacv1.Subject{{APIGroup: rbacv1.GroupName, Kind: rbacv1.GroupKind, Name: "system:authenticated"}}
+	}
+	subjects := make([]rbacv1.Subject, len(spec.AllowedSubjects))
+	for i, s := range spec.AllowedSubjects {
+		if s.Kind == rbacv1.ServiceAccountKind && s.Namespace == "" {
+			s.Namespace = namespace
+		}
+		subjects[i] = s
+	}
+	return subjects
+}
+
+// SubjectsAllow returns true if one of the subjects matches the user, who is a member of the groups.
+// The namespaces of service accounts must be set.
+func SubjectsAllow(subjects []rbacv1.Subject, user string, groups []string) bool {
+	for _, s := range subjects {
+		switch s.Kind {
+		case rbacv1.UserKind:
+			if s.Name == user {
+				return true
+			}
+		case rbacv1.GroupKind:
+			for _, g := range groups {
+				if g == s.Name {
+					return true
+				}
+			}
+		case rbacv1.ServiceAccountKind:
+			if user == strings.Join([]string{"system", "serviceaccount", s.Namespace, s.Name}, ":") {
+				return true
+			}
+		}
+	}
+	return false
+}
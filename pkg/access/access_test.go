@@ -0,0 +1,93 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package access
+
+import (
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/naming"
+)
+
+func TestAllowed(t *testing.T) {
+	userland := &escv1alpha2.Userland{
+		ObjectMeta: metav1.ObjectMeta{Name: "vscode-alice", Labels: map[string]string{naming.UserLabel: "alice"}},
+	}
+	if !Allowed(userland, "alice", nil) || Allowed(userland, "bob", nil) {
+		t.Errorf("only the user in the label should be allowed without an owner")
+	}
+
+	userland.Spec.Owner = &escv1alpha2.UserlandOwner{User: "alice@example.com"}
+	userland.Spec.Collaborators = []escv1alpha2.Collaborator{
+		{Name: "bob@example.com"},
+		{Kind: escv1alpha2.GroupCollaborator, Name: "mentors"},
+	}
+	for _, tc := range []struct {
+		user    string
+		groups  []string
+		allowed bool
+	}{
+		{user: "alice@example.com", allowed: true},
+		{user: "alice", allowed: false},
+		{user: "bob@example.com", allowed: true},
+		{user: "carol@example.com", groups: []string{"students"}, allowed: false},
+		{user: "carol@example.com", groups: []string{"students", "mentors"}, allowed: true},
+		{user: "", groups: []string{"mentors"}, allowed: false},
+	} {
+		if got := Allowed(userland, tc.user, tc.groups); got != tc.allowed {
+			t.Errorf("Allowed(%q, %v) = %v, want %v", tc.user, tc.groups, got, tc.allowed)
+		}
+	}
+
+	subjects := CollaboratorSubjects(userland)
+	if len(subjects) != 2 || subjects[0].Kind != rbacv1.UserKind || subjects[1].Kind != rbacv1.GroupKind {
+		t.Errorf("CollaboratorSubjects = %v", subjects)
+	}
+}
+
+func TestSubjectsAllow(t *testing.T) {
+	spec := &escv1alpha2.TemplateSpec{AllowedSubjects: []rbacv1.Subject{
+		{Kind: rbacv1.UserKind, Name: "alice"},
+		{Kind: rbacv1.GroupKind, Name: "infra"},
+		{Kind: rbacv1.ServiceAccountKind, Name: "ci"},
+	}}
+	subjects := AllowedSubjects(spec, "esc")
+	if subjects[2].Namespace != "esc" || spec.AllowedSubjects[2].Namespace != "" {
+		t.Errorf("the namespace of the service account should be defaulted in a copy, got %v", subjects[2])
+	}
+	for _, tc := range []struct {
+		user    string
+		groups  []string
+		allowed bool
+	}{
+		{user: "alice", allowed: true},
+		{user: "bob", groups: []string{"system:authenticated"}, allowed: false},
+		{user: "bob", groups: []string{"infra"}, allowed: true},
+		{user: "system:serviceaccount:esc:ci", allowed: true},
+		{user: "system:serviceaccount:default:ci", allowed: false},
+	} {
+		if got := SubjectsAllow(subjects, tc.user, tc.groups); got != tc.allowed {
+			t.Errorf("SubjectsAllow(%q, %v) = %v, want %v", tc.user, tc.groups, got, tc.allowed)
+		}
+	}
+
+	if !SubjectsAllow(AllowedSubjects(&escv1alpha2.TemplateSpec{}, "esc"), "bob", []string{"system:authenticated"}) {
+		t.Errorf("all users should be allowed without allowed subjects")
+	}
+}
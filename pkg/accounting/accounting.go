@@ -0,0 +1,87 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package accounting defines the durable records of the resources consumed by Userlands.
+//
+// The usage of each day is recorded in a ConfigMap per namespace and day, so that it is kept after the Userlands
+// are deleted. Each key is a Userland, and the value is its Record in JSON.
+package accounting
+
+import (
+	"encoding/json"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/access"
+)
+
+const (
+	// Label is set to the ConfigMaps of usage records, the value is the date.
+	Label = "esc.k06.in/usage"
+
+	// DateFormat is the format of the dates of usage records, which are in UTC.
+	DateFormat = "2006-01-02"
+)
+
+// Record is the usage of a Userland in a day.
+type Record struct {
+	Userland string                    `json:"userland"`
+	UID      string                    `json:"uid"`
+	Owner    string                    `json:"owner"`
+	Template string                    `json:"template"`
+	Usage    escv1alpha2.ResourceUsage `json:"usage"`
+}
+
+// ConfigMapName returns the name of the ConfigMap which records the usage of the date.
+func ConfigMapName(date string) string {
+	return "esc-usage-" + date
+}
+
+// Key returns the key of the Userland in the ConfigMap. The UID tells Userlands recreated with the same name apart.
+func Key(userland *escv1alpha2.Userland) string {
+	return userland.Name + "." + string(userland.UID)
+}
+
+// NewRecord returns the record of the usage of the Userland.
+func NewRecord(userland *escv1alpha2.Userland, usage escv1alpha2.ResourceUsage) Record {
+	return Record{
+		Userland: userland.Name,
+		UID:      string(userland.UID),
+		Owner:    access.Owner(userland),
+		Template: userland.Spec.TemplateName,
+		Usage:    usage,
+	}
+}
+
+// Records returns the records in the ConfigMap ordered by their keys. Invalid values are skipped.
+func Records(cm *corev1.ConfigMap) []Record {
+	keys := make([]string, 0, len(cm.Data))
+	for key := range cm.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var records []Record
+	for _, key := range keys {
+		var record Record
+		if err := json.Unmarshal([]byte(cm.Data[key]), &record); err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records
+}
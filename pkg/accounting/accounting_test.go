@@ -0,0 +1,53 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package accounting
+
+import (
+	"encoding/json"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+)
+
+func TestRecords(t *testing.T) {
+	userland := &escv1alpha2.Userland{
+		ObjectMeta: metav1.ObjectMeta{Name: "alice", Namespace: "esc", UID: "1234"},
+		Spec: escv1alpha2.UserlandSpec{
+			TemplateName: "vscode",
+			Owner:        &escv1alpha2.UserlandOwner{User: "alice@example.com"},
+		},
+	}
+	value, err := json.Marshal(NewRecord(userland, escv1alpha2.ResourceUsage{RunningSeconds: 3600}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cm := &corev1.ConfigMap{Data: map[string]string{
+		Key(userland): string(value),
+		"broken.5678": "{",
+	}}
+	records := Records(cm)
+	if len(records) != 1 {
+		t.Fatalf("Records() returned %d records, want 1", len(records))
+	}
+	want := Record{Userland: "alice", UID: "1234", Owner: "alice@example.com", Template: "vscode", Usage: escv1alpha2.ResourceUsage{RunningSeconds: 3600}}
+	if records[0] != want {
+		t.Errorf("Records() = %+v, want %+v", records[0], want)
+	}
+}
@@ -0,0 +1,235 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package naming generates the names of resources owned by Userlands.
+package naming
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+)
+
+const (
+	// DefaultPattern is the default pattern of the base name of resources owned by a Userland.
+	DefaultPattern = "$(TEMPLATE)-$(NAME)"
+
+	// MaxLength is the max length of generated names.
+	// Names are also used as label values, so they are limited to the length of a DNS-1123 label.
+	MaxLength = validation.DNS1123LabelMaxLength
+
+	hashLength = 8
+
+	// UserLabel is set to Userlands owned by a UserlandSet, the value is the user name.
+	UserLabel = "esc.k06.in/user"
+)
+
+// Namer generates the names of resources owned by Userlands.
+//
+// Pattern may contain the following variables:
+//
+//	$(TEMPLATE)  the name of the Template
+//	$(NAME)      spec.Name of the Userland, or the name of the Userland if it is empty
+//	$(NAMESPACE) the namespace of the Userland
+//	$(USER)      the name of the user of the Userland
+//
+// Existing resources keep the names recorded in the status of their Userland until its Template is switched.
+type Namer struct {
+	Pattern string
+}
+
+// Name returns spec.Name of the Userland, or the name of the Userland if it is empty.
+func Name(userland *escv1alpha2.Userland) string {
+	if userland.Spec.Name != "" {
+		return userland.Spec.Name
+	}
+	return userland.Name
+}
+
+// User returns the name of the user of the Userland.
+func User(userland *escv1alpha2.Userland) string {
+	if user, ok := userland.Labels[UserLabel]; ok {
+		return user
+	}
+	return userland.Name
+}
+
+// Owner returns the name of the user who owns the Userland, which is spec.owner.user if it is set,
+// otherwise the user in the esc.k06.in/user label or the name of the Userland.
+// Unlike the label, spec.owner is protected by the webhook, so it is used to name resources shared by the user.
+func Owner(userland *escv1alpha2.Userland) string {
+	if owner := userland.Spec.Owner; owner != nil && owner.User != "" {
+		return owner.User
+	}
+	return User(userland)
+}
+
+// userName returns the user name usable in names. Characters not allowed in names are replaced with "-",
+// with a hash of the user name appended so that different users still have different names.
+func userName(user string) string {
+	lower := strings.ToLower(user)
+	name := strings.Trim(strings.Map(func(r rune) rune {
+		if ('a' <= r && r <= 'z') || ('0' <= r && r <= '9') || r == '-' {
+			return r
+		}
+		return '-'
+	}, lower), "-")
+	if name == lower {
+		return name
+	}
+	if name == "" {
+		return hash(user)
+	}
+	return name + "-" + hash(user)
+}
+
+// Expand replaces the variables of Namer.Pattern and $(USER), the name of the user, in the pattern.
+func Expand(pattern string, userland *escv1alpha2.Userland) string {
+	return strings.NewReplacer(
+		"$(TEMPLATE)", userland.Spec.TemplateName,
+		"$(NAME)", Name(userland),
+		"$(NAMESPACE)", userland.Namespace,
+		"$(USER)", User(userland),
+	).Replace(pattern)
+}
+
+// BaseName returns the name of the Deployment of the Userland, which the names of other resources are based on.
+func (n Namer) BaseName(userland *escv1alpha2.Userland) (string, error) {
+	pattern := n.Pattern
+	if pattern == "" {
+		pattern = DefaultPattern
+	}
+
+	name := Truncate(strings.ToLower(Expand(pattern, userland)), MaxLength)
+	if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+		return "", fmt.Errorf("invalid name %q generated from pattern %q: %s", name, pattern, strings.Join(errs, ", "))
+	}
+	return name, nil
+}
+
+// Names returns the names of the Deployment, Service and PersistentVolumeClaims of the Userland.
+func (n Namer) Names(userland *escv1alpha2.Userland, spec *escv1alpha2.TemplateSpec) (escv1alpha2.ResourceNames, error) {
+	base, err := n.BaseName(userland)
+	if err != nil {
+		return escv1alpha2.ResourceNames{}, err
+	}
+
+	names := escv1alpha2.ResourceNames{
+		Deployment: base,
+		Service:    Truncate(base+"-svc", MaxLength),
+	}
+	if spec.Certificate != nil {
+		names.Certificate = Truncate(base+"-tls", MaxLength)
+	}
+	if spec.Route != nil {
+		names.Route = base
+	}
+	for _, v := range spec.VolumeSpecs {
+		if names.PersistentVolumeClaims == nil {
+			names.PersistentVolumeClaims = map[string]string{}
+		}
+		if v.Scope == escv1alpha2.UserVolumeScope {
+			name := Truncate(userName(Owner(userland))+"-pvc-"+v.Name, MaxLength)
+			if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+				return escv1alpha2.ResourceNames{}, fmt.Errorf("invalid name %q of user scoped volume %q: %s", name, v.Name, strings.Join(errs, ", "))
+			}
+			names.PersistentVolumeClaims[v.Name] = name
+			continue
+		}
+		names.PersistentVolumeClaims[v.Name] = Truncate(base+"-pvc-"+v.Name, MaxLength)
+	}
+	keepNames(userland, &names)
+	return names, nil
+}
+
+// keepNames replaces the generated names with the names of the existing resources of the Userland,
+// so that they are not renamed (and their claims not orphaned) when the pattern or the naming scheme is changed.
+// The names are kept until the Userland is switched to another Template.
+func keepNames(userland *escv1alpha2.Userland, names *escv1alpha2.ResourceNames) {
+	status := userland.Status
+	if status.TemplateName != "" && status.TemplateName != userland.Spec.TemplateName {
+		return
+	}
+
+	recorded := status.ResourceNames
+	if recorded.Deployment == "" {
+		if status.Phase == "" {
+			// not reconciled yet
+			return
+		}
+		// Reconciled before the names were recorded, when they were derived from the Template and the Userland.
+		base := userland.Spec.TemplateName + "-" + userland.Name
+		recorded = escv1alpha2.ResourceNames{Deployment: base, Service: base + "-svc", PersistentVolumeClaims: map[string]string{}}
+		for volume := range names.PersistentVolumeClaims {
+			recorded.PersistentVolumeClaims[volume] = base + "-pvc-" + volume
+		}
+	}
+
+	names.Deployment = recorded.Deployment
+	if recorded.Service != "" {
+		names.Service = recorded.Service
+	}
+	if names.Certificate != "" && recorded.Certificate != "" {
+		names.Certificate = recorded.Certificate
+	}
+	if names.Route != "" && recorded.Route != "" {
+		names.Route = recorded.Route
+	}
+	for volume := range names.PersistentVolumeClaims {
+		if name, ok := recorded.PersistentVolumeClaims[volume]; ok {
+			names.PersistentVolumeClaims[volume] = name
+		}
+	}
+}
+
+// ServiceAccount returns the name of the ServiceAccount, Role and RoleBinding of the Userland.
+// It doesn't depend on the Template, so the identity of the user is kept when the Template is switched.
+func ServiceAccount(userland *escv1alpha2.Userland) string {
+	return Truncate("userland-"+Name(userland), MaxLength)
+}
+
+// Namespace returns the name of the dedicated namespace of the Userland.
+// The name ends with a hash of the namespace and the name of the Userland, because "<namespace>-<name>" is ambiguous
+// ("x" in "team-a" and "a-x" in "team"). The namespace recorded in the status is kept, so that existing namespaces
+// are not renamed.
+func Namespace(userland *escv1alpha2.Userland) string {
+	if ns := userland.Status.Namespace; ns != "" && ns != userland.Namespace {
+		return ns
+	}
+	prefix := strings.TrimRight(Truncate(userland.Namespace+"-"+userland.Name, MaxLength-hashLength-1), "-.")
+	return prefix + "-" + hash(userland.Namespace+"/"+userland.Name)
+}
+
+// Truncate shortens the name to max characters by replacing its tail with a hash of the whole name,
+// so that different long names are still different after truncation.
+func Truncate(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+
+	prefix := strings.TrimRight(name[:max-hashLength-1], "-.")
+	return prefix + "-" + hash(name)
+}
+
+// hash returns a short hash of s which is valid in names.
+func hash(s string) string {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return fmt.Sprintf("%0*x", hashLength, h.Sum32())
+}
@@ -0,0 +1,190 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package naming
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+)
+
+func TestBaseName(t *testing.T) {
+	userland := &escv1alpha2.Userland{
+		ObjectMeta: metav1.ObjectMeta{Name: "koba1t", Namespace: "esc"},
+		Spec:       escv1alpha2.UserlandSpec{TemplateName: "vscode"},
+	}
+
+	tests := []struct {
+		pattern  string
+		specName string
+		want     string
+	}{
+		{"", "", "vscode-koba1t"},
+		{"", "alice", "vscode-alice"},
+		{"$(NAMESPACE)-$(NAME)", "", "esc-koba1t"},
+	}
+	for _, tt := range tests {
+		userland.Spec.Name = tt.specName
+		got, err := Namer{Pattern: tt.pattern}.BaseName(userland)
+		if err != nil {
+			t.Fatalf("BaseName(%q) returned error: %v", tt.pattern, err)
+		}
+		if got != tt.want {
+			t.Errorf("BaseName(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+
+	userland.Spec.Name = "Invalid_Name"
+	if _, err := (Namer{}).BaseName(userland); err == nil {
+		t.Errorf("BaseName should fail for an invalid name")
+	}
+}
+
+func TestNamesUserScope(t *testing.T) {
+	spec := &escv1alpha2.TemplateSpec{VolumeSpecs: []escv1alpha2.VolumeSpec{
+		{Name: "home", Scope: escv1alpha2.UserVolumeScope},
+		{Name: "cache"},
+	}}
+	for _, template := range []string{"vscode", "jupyter"} {
+		userland := &escv1alpha2.Userland{
+			ObjectMeta: metav1.ObjectMeta{Name: "koba1t", Namespace: "esc"},
+			Spec:       escv1alpha2.UserlandSpec{TemplateName: template},
+		}
+		names, err := Namer{}.Names(userland, spec)
+		if err != nil {
+			t.Fatalf("Names returned error: %v", err)
+		}
+		if got := names.PersistentVolumeClaims["home"]; got != "koba1t-pvc-home" {
+			t.Errorf("user scoped claim = %q, want koba1t-pvc-home for any Template", got)
+		}
+		if got, want := names.PersistentVolumeClaims["cache"], template+"-koba1t-pvc-cache"; got != want {
+			t.Errorf("template scoped claim = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestNamesUserScopeOwner(t *testing.T) {
+	spec := &escv1alpha2.TemplateSpec{VolumeSpecs: []escv1alpha2.VolumeSpec{{Name: "home", Scope: escv1alpha2.UserVolumeScope}}}
+	userland := &escv1alpha2.Userland{
+		ObjectMeta: metav1.ObjectMeta{Name: "koba1t", Namespace: "esc", Labels: map[string]string{UserLabel: "bob"}},
+		Spec: escv1alpha2.UserlandSpec{
+			TemplateName: "vscode",
+			Owner:        &escv1alpha2.UserlandOwner{User: "Alice@example.com"},
+		},
+	}
+	names, err := Namer{}.Names(userland, spec)
+	if err != nil {
+		t.Fatalf("Names returned error: %v", err)
+	}
+	got := names.PersistentVolumeClaims["home"]
+	if !strings.HasPrefix(got, "alice-example-com-") || !strings.HasSuffix(got, "-pvc-home") {
+		t.Errorf("user scoped claim = %q, want alice-example-com-<hash>-pvc-home named after the owner", got)
+	}
+
+	userland.Spec.Owner.User = "alice.example.com"
+	if other, _ := (Namer{}).Names(userland, spec); other.PersistentVolumeClaims["home"] == got {
+		t.Errorf("user scoped claims of different owners have the same name %q", got)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	long := strings.Repeat("a", 70)
+	got := Truncate(long, MaxLength)
+	if len(got) != MaxLength {
+		t.Errorf("len(Truncate()) = %d, want %d", len(got), MaxLength)
+	}
+	if got != Truncate(long, MaxLength) {
+		t.Errorf("Truncate() is not stable")
+	}
+	if got == Truncate(long+"b", MaxLength) {
+		t.Errorf("Truncate() returned the same name for different names")
+	}
+	if Truncate("short", MaxLength) != "short" {
+		t.Errorf("Truncate() changed a short name")
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	userland := func(namespace, name string) *escv1alpha2.Userland {
+		return &escv1alpha2.Userland{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	}
+
+	got := Namespace(userland("team-a", "x"))
+	if !strings.HasPrefix(got, "team-a-x-") || len(got) > MaxLength {
+		t.Errorf("Namespace() = %q, want team-a-x-<hash>", got)
+	}
+	if got == Namespace(userland("team", "a-x")) {
+		t.Errorf("Namespace() returned the same name for different userlands: %q", got)
+	}
+	if long := Namespace(userland(strings.Repeat("a", 63), strings.Repeat("b", 63))); len(long) > MaxLength {
+		t.Errorf("len(Namespace()) = %d, want <= %d", len(long), MaxLength)
+	}
+
+	recorded := userland("team-a", "x")
+	recorded.Status.Namespace = "team-a-x"
+	if got := Namespace(recorded); got != "team-a-x" {
+		t.Errorf("Namespace() = %q, want the recorded namespace", got)
+	}
+	recorded.Status.Namespace = "team-a"
+	if got := Namespace(recorded); got == "team-a" {
+		t.Errorf("Namespace() should ignore the namespace of the userland recorded in the status")
+	}
+}
+
+func TestNamesKept(t *testing.T) {
+	spec := &escv1alpha2.TemplateSpec{VolumeSpecs: []escv1alpha2.VolumeSpec{{Name: "home"}, {Name: "cache"}}}
+	recorded := escv1alpha2.ResourceNames{
+		Deployment:             "old",
+		Service:                "old-svc",
+		PersistentVolumeClaims: map[string]string{"home": "old-pvc-home"},
+	}
+
+	tests := []struct {
+		name       string
+		status     escv1alpha2.UserlandStatus
+		deployment string
+		home       string
+		cache      string
+	}{
+		{"new", escv1alpha2.UserlandStatus{}, "vscode-alice", "vscode-alice-pvc-home", "vscode-alice-pvc-cache"},
+		{"recorded", escv1alpha2.UserlandStatus{Phase: escv1alpha2.UserlandRunning, TemplateName: "vscode", ResourceNames: recorded}, "old", "old-pvc-home", "vscode-alice-pvc-cache"},
+		{"legacy", escv1alpha2.UserlandStatus{Phase: escv1alpha2.UserlandRunning}, "vscode-koba1t", "vscode-koba1t-pvc-home", "vscode-koba1t-pvc-cache"},
+		{"switched", escv1alpha2.UserlandStatus{Phase: escv1alpha2.UserlandRunning, TemplateName: "jupyter", ResourceNames: recorded}, "vscode-alice", "vscode-alice-pvc-home", "vscode-alice-pvc-cache"},
+	}
+	for _, tt := range tests {
+		userland := &escv1alpha2.Userland{
+			ObjectMeta: metav1.ObjectMeta{Name: "koba1t", Namespace: "esc"},
+			Spec:       escv1alpha2.UserlandSpec{Name: "alice", TemplateName: "vscode"},
+			Status:     tt.status,
+		}
+		names, err := Namer{}.Names(userland, spec)
+		if err != nil {
+			t.Fatalf("%s: Names returned error: %v", tt.name, err)
+		}
+		if names.Deployment != tt.deployment {
+			t.Errorf("%s: deployment = %q, want %q", tt.name, names.Deployment, tt.deployment)
+		}
+		if got := names.PersistentVolumeClaims["home"]; got != tt.home {
+			t.Errorf("%s: claim home = %q, want %q", tt.name, got, tt.home)
+		}
+		if got := names.PersistentVolumeClaims["cache"]; got != tt.cache {
+			t.Errorf("%s: claim cache = %q, want %q", tt.name, got, tt.cache)
+		}
+	}
+}
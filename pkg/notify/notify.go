@@ -0,0 +1,380 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package notify delivers the events of Userlands to the HTTP endpoints of NotificationSinks.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/access"
+)
+
+const (
+	// SignatureHeader carries the HMAC-SHA256 signature of the payload as "sha256=<hex>".
+	SignatureHeader = "X-ESC-Signature"
+	// EventHeader carries the event of the payload.
+	EventHeader = "X-ESC-Event"
+	// DeliveryHeader carries the ID of the delivery, which is the same for all the retries of an event.
+	DeliveryHeader = "X-ESC-Delivery"
+
+	// DefaultRetries is the number of retries of a failed delivery if the NotificationSink doesn't set it.
+	DefaultRetries = 5
+	// DefaultBackoff is the duration before the first retry, which is doubled for each retry.
+	DefaultBackoff = time.Second
+
+	maxBackoff = time.Minute
+	queueSize  = 100
+	timeout    = 10 * time.Second
+)
+
+// privateNetworks are the networks which are not reachable from the Internet, in addition to loopback,
+// link-local and unspecified addresses.
+var privateNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"} {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// Payload is the JSON body POSTed to NotificationSinks.
+type Payload struct {
+	Event     escv1alpha2.NotificationEvent `json:"event"`
+	Time      time.Time                     `json:"time"`
+	Namespace string                        `json:"namespace"`
+	Userland  string                        `json:"userland"`
+	Owner     string                        `json:"owner"`
+	Template  string                        `json:"template"`
+	Phase     escv1alpha2.UserlandPhase     `json:"phase,omitempty"`
+	Message   string                        `json:"message,omitempty"`
+	ExpiresAt *time.Time                    `json:"expiresAt,omitempty"`
+}
+
+// NewPayload returns the payload of an event of the Userland.
+func NewPayload(userland *escv1alpha2.Userland, event escv1alpha2.NotificationEvent, message string, now time.Time) Payload {
+	p := Payload{
+		Event:     event,
+		Time:      now.UTC(),
+		Namespace: userland.Namespace,
+		Userland:  userland.Name,
+		Owner:     access.Owner(userland),
+		Template:  userland.Spec.TemplateName,
+		Phase:     userland.Status.Phase,
+		Message:   message,
+	}
+	if userland.Status.ExpiresAt != nil {
+		expiresAt := userland.Status.ExpiresAt.UTC()
+		p.ExpiresAt = &expiresAt
+	}
+	return p
+}
+
+// Selects returns true if the NotificationSink sends the event of the Userland.
+func Selects(sink *escv1alpha2.NotificationSink, userland *escv1alpha2.Userland, event escv1alpha2.NotificationEvent) (bool, error) {
+	if len(sink.Spec.Events) > 0 {
+		found := false
+		for _, e := range sink.Spec.Events {
+			if e == event {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, nil
+		}
+	}
+	if sink.Spec.Selector == nil {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(sink.Spec.Selector)
+	if err != nil {
+		return false, err
+	}
+	return selector.Matches(labels.Set(userland.Labels)), nil
+}
+
+// Sign returns the value of the signature header of the body.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notification is an event to deliver to a NotificationSink.
+type Notification struct {
+	// Sink is the NotificationSink whose status records the delivery.
+	Sink types.NamespacedName
+	URL  string
+	// Secret signs the payload if it is not empty.
+	Secret  []byte
+	Retries int
+	Payload Payload
+}
+
+// NewNotification returns the notification of the payload to the NotificationSink, signed by the secret.
+func NewNotification(sink *escv1alpha2.NotificationSink, secret []byte, payload Payload) Notification {
+	retries := DefaultRetries
+	if sink.Spec.Retries != nil {
+		retries = int(*sink.Spec.Retries)
+	}
+	return Notification{
+		Sink:    types.NamespacedName{Namespace: sink.Namespace, Name: sink.Name},
+		URL:     sink.Spec.URL,
+		Secret:  secret,
+		Retries: retries,
+		Payload: payload,
+	}
+}
+
+// checkURL returns an error if the URL of a NotificationSink is not an https URL.
+func checkURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("url %q must be an https URL", rawURL)
+	}
+	return nil
+}
+
+// publicIP returns false if the IP is a loopback, link-local, private or unspecified address.
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewHTTPClient returns the client which delivers the notifications by default. It connects only to public addresses
+// without a proxy and doesn't follow redirects, so that NotificationSinks can't make the manager send requests
+// to the services in the cluster or the metadata endpoints of clouds.
+func NewHTTPClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		// the resolved address is checked, so that a name can't be resolved to a private address
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return fmt.Errorf("address %s is not a public address", host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Send POSTs the payload of the notification until it is accepted by a 2xx response, or the retries are exhausted.
+// Network errors, 429 and 5xx responses are retried after the backoff, which is doubled for each retry.
+// The notification is not sent if its URL is not an https URL.
+func Send(ctx context.Context, httpClient *http.Client, n Notification, backoff time.Duration) escv1alpha2.NotificationDelivery {
+	delivery := escv1alpha2.NotificationDelivery{Event: n.Payload.Event, Userland: n.Payload.Userland}
+	finish := func(err error) escv1alpha2.NotificationDelivery {
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+		delivery.Time = metav1.Now()
+		return delivery
+	}
+
+	if err := checkURL(n.URL); err != nil {
+		return finish(err)
+	}
+	body, err := json.Marshal(n.Payload)
+	if err != nil {
+		return finish(err)
+	}
+	id := string(uuid.NewUUID())
+
+	for {
+		delivery.Attempts++
+		code, err := post(ctx, httpClient, n, id, body)
+		delivery.StatusCode = int32(code)
+		if err == nil && code >= 200 && code < 300 {
+			return finish(nil)
+		}
+		if err == nil {
+			err = fmt.Errorf("unexpected status %d", code)
+		}
+		if int(delivery.Attempts) > n.Retries || (code != 0 && code != http.StatusTooManyRequests && code < 500) {
+			return finish(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return finish(err)
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// post sends a request of the notification and returns the status code of the response.
+func post(ctx context.Context, httpClient *http.Client, n Notification, id string, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(n.Payload.Event))
+	req.Header.Set(DeliveryHeader, id)
+	if len(n.Secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(n.Secret, body))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// Dispatcher is a runnable of the manager which delivers the enqueued notifications in the background,
+// so that the reconciliation of Userlands is not blocked by slow endpoints and retries.
+// The results are recorded in the status of the NotificationSinks.
+type Dispatcher struct {
+	Client client.Client
+	Log    logr.Logger
+
+	// HTTPClient sends the requests. The client of NewHTTPClient is used if it is nil.
+	HTTPClient *http.Client
+	// Backoff is the duration before the first retry. DefaultBackoff is used if it is zero.
+	Backoff time.Duration
+
+	once  sync.Once
+	queue chan Notification
+}
+
+func (d *Dispatcher) init() {
+	d.once.Do(func() {
+		d.queue = make(chan Notification, queueSize)
+	})
+}
+
+// Enqueue queues the notification to be delivered. It returns false if the queue is full and the notification is dropped.
+func (d *Dispatcher) Enqueue(n Notification) bool {
+	d.init()
+	select {
+	case d.queue <- n:
+		return true
+	default:
+		d.Log.Info("dropped notification because the queue is full", "sink", n.Sink, "event", n.Payload.Event, "userland", n.Payload.Userland)
+		return false
+	}
+}
+
+// Start implements manager.Runnable. It delivers the notifications until the stop channel is closed.
+func (d *Dispatcher) Start(stop <-chan struct{}) error {
+	d.init()
+	httpClient := d.HTTPClient
+	if httpClient == nil {
+		httpClient = NewHTTPClient()
+	}
+	backoff := d.Backoff
+	if backoff == 0 {
+		backoff = DefaultBackoff
+	}
+
+	// the pending retries are cancelled before waiting for the deliveries on stop
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case n := <-d.queue:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				delivery := Send(ctx, httpClient, n, backoff)
+				if delivery.Error != "" {
+					d.Log.Info("failed to deliver notification", "sink", n.Sink, "event", n.Payload.Event, "userland", n.Payload.Userland, "error", delivery.Error)
+				}
+				// the status is recorded even while stopping, as the delivery has been finished
+				if err := d.record(context.Background(), n.Sink, delivery); err != nil {
+					d.Log.Error(err, "unable to update NotificationSink status", "sink", n.Sink)
+				}
+			}()
+		}
+	}
+}
+
+// record adds the delivery to the status of the NotificationSink, which is ignored if it has been deleted.
+func (d *Dispatcher) record(ctx context.Context, name types.NamespacedName, delivery escv1alpha2.NotificationDelivery) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var sink escv1alpha2.NotificationSink
+		if err := d.Client.Get(ctx, name, &sink); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		if delivery.Error == "" {
+			sink.Status.Delivered++
+			sink.Status.LastDelivery = &delivery
+		} else {
+			sink.Status.Failed++
+			sink.Status.LastFailure = &delivery
+		}
+		return d.Client.Status().Update(ctx, &sink)
+	})
+}
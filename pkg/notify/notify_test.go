@@ -0,0 +1,217 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+)
+
+func TestSelects(t *testing.T) {
+	userland := &escv1alpha2.Userland{ObjectMeta: metav1.ObjectMeta{Name: "alice", Labels: map[string]string{"cohort": "2021"}}}
+	tests := []struct {
+		name string
+		spec escv1alpha2.NotificationSinkSpec
+		want bool
+	}{
+		{name: "all", want: true},
+		{name: "event", spec: escv1alpha2.NotificationSinkSpec{Events: []escv1alpha2.NotificationEvent{escv1alpha2.ExpiringEvent, escv1alpha2.RunningEvent}}, want: true},
+		{name: "other event", spec: escv1alpha2.NotificationSinkSpec{Events: []escv1alpha2.NotificationEvent{escv1alpha2.ExpiringEvent}}},
+		{name: "selector", spec: escv1alpha2.NotificationSinkSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"cohort": "2021"}}}, want: true},
+		{name: "other selector", spec: escv1alpha2.NotificationSinkSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"cohort": "2020"}}}},
+	}
+	for _, tt := range tests {
+		sink := &escv1alpha2.NotificationSink{Spec: tt.spec}
+		got, err := Selects(sink, userland, escv1alpha2.RunningEvent)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: Selects() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	var mu sync.Mutex
+	var ids []string
+	statuses := []int{http.StatusInternalServerError, http.StatusTooManyRequests, http.StatusOK}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if got, want := r.Header.Get(SignatureHeader), Sign([]byte("secret"), body); got != want {
+			t.Errorf("signature = %q, want %q", got, want)
+		}
+		var p Payload
+		if err := json.Unmarshal(body, &p); err != nil || p.Event != escv1alpha2.RunningEvent || p.Userland != "alice" {
+			t.Errorf("unexpected payload %s: %v", body, err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		ids = append(ids, r.Header.Get(DeliveryHeader))
+		w.WriteHeader(statuses[len(ids)-1])
+	}))
+	defer server.Close()
+
+	userland := &escv1alpha2.Userland{ObjectMeta: metav1.ObjectMeta{Name: "alice", Namespace: "esc"}}
+	n := Notification{URL: server.URL, Secret: []byte("secret"), Retries: 2, Payload: NewPayload(userland, escv1alpha2.RunningEvent, "", time.Now())}
+
+	delivery := Send(context.Background(), server.Client(), n, time.Millisecond)
+	if delivery.Error != "" || delivery.Attempts != 3 || delivery.StatusCode != http.StatusOK {
+		t.Errorf("unexpected delivery %+v", delivery)
+	}
+	if len(ids) != 3 || ids[0] == "" || ids[0] != ids[2] {
+		t.Errorf("the retries must have the same delivery id: %v", ids)
+	}
+
+	// the retries are exhausted
+	mu.Lock()
+	ids = nil
+	mu.Unlock()
+	n.Retries = 1
+	if delivery := Send(context.Background(), server.Client(), n, time.Millisecond); delivery.Error == "" || delivery.Attempts != 2 {
+		t.Errorf("unexpected delivery %+v", delivery)
+	}
+}
+
+func TestSendNotRetried(t *testing.T) {
+	attempts := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if r.Header.Get(SignatureHeader) != "" {
+			t.Errorf("the payload must not be signed without a secret")
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	n := Notification{URL: server.URL, Retries: 3, Payload: Payload{Event: escv1alpha2.ExpiringEvent}}
+	delivery := Send(context.Background(), server.Client(), n, time.Millisecond)
+	if delivery.Error == "" || delivery.StatusCode != http.StatusBadRequest || attempts != 1 {
+		t.Errorf("client errors must not be retried: %+v, %d attempts", delivery, attempts)
+	}
+}
+
+func TestSendPrivateAddress(t *testing.T) {
+	attempts := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+	}))
+	defer server.Close()
+
+	n := Notification{URL: server.URL, Payload: Payload{Event: escv1alpha2.ExpiringEvent}}
+	if delivery := Send(context.Background(), NewHTTPClient(), n, time.Millisecond); delivery.Error == "" || attempts != 0 {
+		t.Errorf("the loopback address must not be connected: %+v, %d attempts", delivery, attempts)
+	}
+
+	n.URL = strings.Replace(server.URL, "https://", "http://", 1)
+	if delivery := Send(context.Background(), server.Client(), n, time.Millisecond); delivery.Error == "" || delivery.Attempts != 0 {
+		t.Errorf("the notification must not be sent over http: %+v", delivery)
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	for ip, public := range map[string]bool{
+		"8.8.8.8":          true,
+		"2001:4860::8888":  true,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"169.254.169.254":  false,
+		"10.96.0.1":        false,
+		"172.20.0.1":       false,
+		"192.168.1.1":      false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"fd00::1":          false,
+		"fe80::1":          false,
+		"::ffff:127.0.0.1": false,
+	} {
+		if got := publicIP(net.ParseIP(ip)); got != public {
+			t.Errorf("publicIP(%s) = %v, want %v", ip, got, public)
+		}
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	received := make(chan string, 1)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get(EventHeader)
+	}))
+	defer server.Close()
+
+	scheme := runtime.NewScheme()
+	_ = escv1alpha2.AddToScheme(scheme)
+	sink := &escv1alpha2.NotificationSink{
+		ObjectMeta: metav1.ObjectMeta{Name: "slack", Namespace: "esc"},
+		Spec:       escv1alpha2.NotificationSinkSpec{URL: server.URL},
+	}
+	c := fake.NewFakeClientWithScheme(scheme, sink)
+	d := &Dispatcher{Client: c, Log: log.NullLogger{}, HTTPClient: server.Client(), Backoff: time.Millisecond}
+
+	userland := &escv1alpha2.Userland{ObjectMeta: metav1.ObjectMeta{Name: "alice", Namespace: "esc"}}
+	if !d.Enqueue(NewNotification(sink, nil, NewPayload(userland, escv1alpha2.SuspendedEvent, "", time.Now()))) {
+		t.Fatal("notification is dropped")
+	}
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- d.Start(stop) }()
+
+	if event := <-received; event != string(escv1alpha2.SuspendedEvent) {
+		t.Errorf("event = %q, want Suspended", event)
+	}
+	name := types.NamespacedName{Namespace: "esc", Name: "slack"}
+	err := wait(func() bool {
+		var got escv1alpha2.NotificationSink
+		if err := c.Get(context.Background(), name, &got); err != nil {
+			t.Fatal(err)
+		}
+		return got.Status.Delivered == 1 && got.Status.LastDelivery != nil && got.Status.LastDelivery.Userland == "alice"
+	})
+	close(stop)
+	if err != nil {
+		t.Error("the delivery is not recorded in the status")
+	}
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+// wait polls the condition for a second.
+func wait(condition func() bool) error {
+	for i := 0; i < 100; i++ {
+		if condition() {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return context.DeadlineExceeded
+}
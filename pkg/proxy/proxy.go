@@ -0,0 +1,257 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package proxy serves Userlands under the paths /u/<namespace>/<userland>/ by a reverse proxy,
+// for environments where a hostname per Userland is not available.
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/access"
+)
+
+const (
+	// PathPrefix is the prefix of the paths served by the proxy.
+	PathPrefix = "/u/"
+
+	// DefaultUserHeader is the default header which carries the name of the authenticated user.
+	DefaultUserHeader = "X-Forwarded-User"
+
+	// DefaultGroupsHeader is the default header which carries the comma separated groups of the authenticated user.
+	DefaultGroupsHeader = "X-Forwarded-Groups"
+
+	// DefaultActivityInterval is the default minimum interval to record the last activity of a Userland.
+	DefaultActivityInterval = time.Minute
+)
+
+// Proxy is a reverse proxy to the Services of Userlands.
+// Requests to /u/<namespace>/<userland>/<path> are sent to /<path> of the Service of the Userland,
+// with the X-Forwarded-Prefix header set to /u/<namespace>/<userland>. WebSocket connections are proxied as well.
+//
+// Callers are authenticated by a proxy in front of it, which sets the name of the user in UserHeader
+// and the groups of the user in GroupsHeader.
+// The headers must not be settable by clients, so the proxy must not be exposed directly.
+// Only the owner and the collaborators of the Userland are allowed.
+type Proxy struct {
+	// Client reads Userlands and Services, usually from the cache of the manager, and records activities.
+	Client client.Client
+	Log    logr.Logger
+
+	// UserHeader is the header which carries the name of the authenticated user.
+	UserHeader string
+
+	// GroupsHeader is the header which carries the comma separated groups of the authenticated user.
+	GroupsHeader string
+
+	// ActivityInterval is the minimum interval to record the last activity of a Userland in its annotation.
+	// Activities are not recorded if it is zero.
+	ActivityInterval time.Duration
+
+	mu           sync.Mutex
+	lastActivity map[types.NamespacedName]time.Time
+}
+
+// ServeHTTP implements http.Handler.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, PathPrefix), "/", 3)
+	if !strings.HasPrefix(r.URL.Path, PathPrefix) || len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	key := types.NamespacedName{Namespace: parts[0], Name: parts[1]}
+	prefix := PathPrefix + key.Namespace + "/" + key.Name
+	if len(parts) == 2 {
+		// relative links of the Userland are resolved under the prefix
+		http.Redirect(w, r, prefix+"/", http.StatusFound)
+		return
+	}
+	log := p.Log.WithValues("userland", key)
+
+	user := r.Header.Get(p.userHeader())
+	if user == "" {
+		http.Error(w, "unauthenticated", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	var userland escv1alpha2.Userland
+	if err := p.Client.Get(ctx, key, &userland); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			log.Error(err, "unable to fetch Userland")
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+	// the existence of Userlands of other users is not revealed
+	if !access.Allowed(&userland, user, p.groups(r)) {
+		http.NotFound(w, r)
+		return
+	}
+
+	target, err := p.backend(ctx, &userland)
+	if err != nil {
+		log.Error(err, "unable to find the Service of Userland")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if target == nil {
+		http.Error(w, "userland is not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	p.recordActivity(ctx, log, &userland)
+
+	path := "/" + parts[2]
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+			req.URL.Path = path
+			req.URL.RawPath = ""
+			req.Header.Set("X-Forwarded-Prefix", prefix)
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// backend returns the URL of the Service of the Userland, or nil if it is not created yet.
+func (p *Proxy) backend(ctx context.Context, userland *escv1alpha2.Userland) (*url.URL, error) {
+	name := userland.Status.ResourceNames.Service
+	if name == "" || userland.Status.Namespace == "" {
+		return nil, nil
+	}
+
+	var service corev1.Service
+	if err := p.Client.Get(ctx, types.NamespacedName{Namespace: userland.Status.Namespace, Name: name}, &service); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	if len(service.Spec.Ports) == 0 || service.Spec.ClusterIP == "" || service.Spec.ClusterIP == corev1.ClusterIPNone {
+		return nil, nil
+	}
+
+	port := strconv.Itoa(int(service.Spec.Ports[0].Port))
+	return &url.URL{Scheme: "http", Host: net.JoinHostPort(service.Spec.ClusterIP, port)}, nil
+}
+
+// recordActivity sets the current time to the last activity annotation of the Userland,
+// at most once in ActivityInterval. Errors are only logged, because they don't affect the request.
+func (p *Proxy) recordActivity(ctx context.Context, log logr.Logger, userland *escv1alpha2.Userland) {
+	if p.ActivityInterval <= 0 {
+		return
+	}
+	key := types.NamespacedName{Namespace: userland.Namespace, Name: userland.Name}
+	now := time.Now()
+
+	p.mu.Lock()
+	if p.lastActivity == nil {
+		p.lastActivity = map[types.NamespacedName]time.Time{}
+	}
+	if now.Sub(p.lastActivity[key]) < p.ActivityInterval {
+		p.mu.Unlock()
+		return
+	}
+	for k, t := range p.lastActivity {
+		// forget deleted Userlands
+		if now.Sub(t) >= p.ActivityInterval {
+			delete(p.lastActivity, k)
+		}
+	}
+	p.lastActivity[key] = now
+	p.mu.Unlock()
+
+	patch := client.MergeFrom(userland.DeepCopy())
+	if userland.Annotations == nil {
+		userland.Annotations = map[string]string{}
+	}
+	userland.Annotations[escv1alpha2.LastActivityAnnotation] = now.UTC().Format(time.RFC3339)
+	if err := p.Client.Patch(ctx, userland, patch); err != nil {
+		log.Error(err, "unable to record the activity of Userland")
+	}
+}
+
+func (p *Proxy) userHeader() string {
+	if p.UserHeader == "" {
+		return DefaultUserHeader
+	}
+	return p.UserHeader
+}
+
+// groups returns the groups of the authenticated user in the request.
+func (p *Proxy) groups(r *http.Request) []string {
+	header := p.GroupsHeader
+	if header == "" {
+		header = DefaultGroupsHeader
+	}
+	var groups []string
+	for _, value := range r.Header[http.CanonicalHeaderKey(header)] {
+		for _, g := range strings.Split(value, ",") {
+			if g = strings.TrimSpace(g); g != "" {
+				groups = append(groups, g)
+			}
+		}
+	}
+	return groups
+}
+
+// Server serves the Proxy on Addr as a Runnable of the manager.
+// It runs on every replica of the manager regardless of leader election.
+type Server struct {
+	Addr  string
+	Proxy *Proxy
+}
+
+// Start runs the server until stop is closed.
+func (s *Server) Start(stop <-chan struct{}) error {
+	mux := http.NewServeMux()
+	mux.Handle(PathPrefix, s.Proxy)
+	server := &http.Server{Addr: s.Addr, Handler: mux}
+
+	errCh := make(chan error, 1)
+	go func() {
+		s.Proxy.Log.Info("starting proxy", "addr", s.Addr)
+		errCh <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-stop:
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return server.Shutdown(ctx)
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
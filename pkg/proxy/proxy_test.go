@@ -0,0 +1,108 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+)
+
+func TestProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.RequestURI() + " " + r.Header.Get("X-Forwarded-Prefix")))
+	}))
+	defer backend.Close()
+	host, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = escv1alpha2.AddToScheme(scheme)
+	c := fake.NewFakeClientWithScheme(scheme,
+		&escv1alpha2.Userland{
+			ObjectMeta: metav1.ObjectMeta{Name: "koba1t", Namespace: "esc"},
+			Spec: escv1alpha2.UserlandSpec{Collaborators: []escv1alpha2.Collaborator{
+				{Kind: escv1alpha2.GroupCollaborator, Name: "mentors"},
+			}},
+			Status: escv1alpha2.UserlandStatus{
+				Namespace:     "esc",
+				ResourceNames: escv1alpha2.ResourceNames{Service: "vscode-koba1t-svc"},
+			},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "vscode-koba1t-svc", Namespace: "esc"},
+			Spec:       corev1.ServiceSpec{ClusterIP: host, Ports: []corev1.ServicePort{{Port: int32(portNumber)}}},
+		},
+	)
+	p := &Proxy{Client: c, Log: log.NullLogger{}, ActivityInterval: time.Minute}
+
+	tests := []struct {
+		path   string
+		user   string
+		groups string
+		status int
+		body   string
+	}{
+		{path: "/u/esc/koba1t/static/app.js?v=1", user: "koba1t", status: http.StatusOK, body: "/static/app.js?v=1 /u/esc/koba1t"},
+		{path: "/u/esc/koba1t", user: "koba1t", status: http.StatusFound},
+		{path: "/u/esc/koba1t/", status: http.StatusUnauthorized},
+		{path: "/u/esc/koba1t/", user: "someone", status: http.StatusNotFound},
+		{path: "/u/esc/koba1t/", user: "mentor", groups: "students, mentors", status: http.StatusOK},
+		{path: "/u/esc/unknown/", user: "koba1t", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.user != "" {
+			req.Header.Set(DefaultUserHeader, tt.user)
+		}
+		if tt.groups != "" {
+			req.Header.Set(DefaultGroupsHeader, tt.groups)
+		}
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+
+		if rec.Code != tt.status {
+			t.Errorf("%s by %q: status = %d, want %d", tt.path, tt.user, rec.Code, tt.status)
+		}
+		if body, _ := ioutil.ReadAll(rec.Body); tt.body != "" && string(body) != tt.body {
+			t.Errorf("%s: body = %q, want %q", tt.path, body, tt.body)
+		}
+	}
+
+	var userland escv1alpha2.Userland
+	if err := c.Get(context.Background(), types.NamespacedName{Namespace: "esc", Name: "koba1t"}, &userland); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := userland.Annotations[escv1alpha2.LastActivityAnnotation]; !ok {
+		t.Errorf("the activity should be recorded in the annotation")
+	}
+}
@@ -0,0 +1,213 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package quota counts the usage of Userlands limited by UserlandQuotas.
+package quota
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/access"
+)
+
+// PodRequests returns the CPU in millicores and memory in bytes requested by the pod.
+// Limits are used for containers which have no requests, as they are defaulted by the API server.
+func PodRequests(podSpec *corev1.PodSpec) (int64, int64) {
+	var cpuMillicores, memoryBytes int64
+	for _, c := range podSpec.Containers {
+		resources := c.Resources.Requests
+		if resources == nil {
+			resources = c.Resources.Limits
+		}
+		if q, ok := resources[corev1.ResourceCPU]; ok {
+			cpuMillicores += q.MilliValue()
+		}
+		if q, ok := resources[corev1.ResourceMemory]; ok {
+			memoryBytes += q.Value()
+		}
+	}
+	return cpuMillicores, memoryBytes
+}
+
+// ObjectsUsage returns the usage of a Userland rendered to the pod and the claims.
+// The requests of the pod are counted only if the Userland runs.
+func ObjectsUsage(podSpec *corev1.PodSpec, claims []*corev1.PersistentVolumeClaim, running bool) corev1.ResourceList {
+	usage := corev1.ResourceList{escv1alpha2.ResourceUserlands: *resource.NewQuantity(1, resource.DecimalSI)}
+
+	storage := resource.NewQuantity(0, resource.BinarySI)
+	for _, pvc := range claims {
+		if q, ok := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; ok {
+			storage.Add(q)
+		}
+	}
+	usage[corev1.ResourceRequestsStorage] = *storage
+
+	if running {
+		cpuMillicores, memoryBytes := PodRequests(podSpec)
+		usage[escv1alpha2.ResourceRunning] = *resource.NewQuantity(1, resource.DecimalSI)
+		usage[corev1.ResourceRequestsCPU] = *resource.NewMilliQuantity(cpuMillicores, resource.DecimalSI)
+		usage[corev1.ResourceRequestsMemory] = *resource.NewQuantity(memoryBytes, resource.BinarySI)
+	}
+	return usage
+}
+
+// Usage returns the usage of the Userland recorded in its status.
+// A Userland which has not been reconciled yet is counted only as a Userland.
+func Usage(userland *escv1alpha2.Userland) corev1.ResourceList {
+	usage := corev1.ResourceList{}
+	for name, q := range userland.Status.QuotaUsage {
+		usage[name] = q.DeepCopy()
+	}
+	usage[escv1alpha2.ResourceUserlands] = *resource.NewQuantity(1, resource.DecimalSI)
+	return usage
+}
+
+// Selects returns true if the quota applies to the Userland.
+func Selects(q *escv1alpha2.UserlandQuota, userland *escv1alpha2.Userland) (bool, error) {
+	if q.Namespace != userland.Namespace {
+		return false, nil
+	}
+	if len(q.Spec.Groups) > 0 && !ownerInGroups(userland, q.Spec.Groups) {
+		return false, nil
+	}
+	if q.Spec.Selector == nil {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(q.Spec.Selector)
+	if err != nil {
+		return false, err
+	}
+	return selector.Matches(labels.Set(userland.Labels)), nil
+}
+
+// ownerInGroups returns true if the owner of the Userland is a member of one of the groups.
+func ownerInGroups(userland *escv1alpha2.Userland, groups []string) bool {
+	if userland.Spec.Owner == nil {
+		return false
+	}
+	for _, g := range userland.Spec.Owner.Groups {
+		for _, group := range groups {
+			if g == group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Key returns the key which the usage of the Userland is aggregated by in the quota,
+// which is the owner for the User scope, or empty.
+func Key(q *escv1alpha2.UserlandQuota, userland *escv1alpha2.Userland) string {
+	if q.Spec.Scope == escv1alpha2.UserQuotaScope {
+		return access.Owner(userland)
+	}
+	return ""
+}
+
+// Add adds the quantities of delta to the quantities of list.
+func Add(list, delta corev1.ResourceList) {
+	for name, q := range delta {
+		sum := list[name]
+		sum.Add(q)
+		list[name] = sum
+	}
+}
+
+// Aggregate returns the usage of all the Userlands selected by the quota, and the usage of each user for the User scope.
+func Aggregate(q *escv1alpha2.UserlandQuota, userlands []escv1alpha2.Userland) (corev1.ResourceList, []escv1alpha2.UserQuotaUsage, error) {
+	used := corev1.ResourceList{}
+	byUser := map[string]corev1.ResourceList{}
+	for i := range userlands {
+		selected, err := Selects(q, &userlands[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		if !selected {
+			continue
+		}
+		usage := Usage(&userlands[i])
+		Add(used, usage)
+		if q.Spec.Scope == escv1alpha2.UserQuotaScope {
+			user := Key(q, &userlands[i])
+			if byUser[user] == nil {
+				byUser[user] = corev1.ResourceList{}
+			}
+			Add(byUser[user], usage)
+		}
+	}
+
+	var users []escv1alpha2.UserQuotaUsage
+	for user, usage := range byUser {
+		users = append(users, escv1alpha2.UserQuotaUsage{User: user, Used: usage})
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].User < users[j].User })
+	return used, users, nil
+}
+
+// Check returns a message for each quota which is exceeded when the Userland has the usage, counted with the
+// usage of the other Userlands selected by the quota. Only the resources for which check returns true are checked.
+func Check(quotas []escv1alpha2.UserlandQuota, userlands []escv1alpha2.Userland, userland *escv1alpha2.Userland, usage corev1.ResourceList, check func(corev1.ResourceName) bool) ([]string, error) {
+	var messages []string
+	for i := range quotas {
+		q := &quotas[i]
+		selected, err := Selects(q, userland)
+		if err != nil {
+			return nil, fmt.Errorf("userlandquota %q: %v", q.Name, err)
+		}
+		if !selected {
+			continue
+		}
+
+		key := Key(q, userland)
+		used := corev1.ResourceList{}
+		for j := range userlands {
+			other := &userlands[j]
+			if other.Name == userland.Name {
+				continue
+			}
+			if s, err := Selects(q, other); err != nil || !s || Key(q, other) != key {
+				continue
+			}
+			Add(used, Usage(other))
+		}
+
+		var exceeded []string
+		for name, limit := range q.Spec.Hard {
+			requested, ok := usage[name]
+			if !ok || requested.IsZero() || !check(name) {
+				continue
+			}
+			total := used[name]
+			total.Add(requested)
+			if total.Cmp(limit) > 0 {
+				current := used[name]
+				exceeded = append(exceeded, fmt.Sprintf("%s (requested %s, used %s, limited %s)", name, requested.String(), current.String(), limit.String()))
+			}
+		}
+		if len(exceeded) > 0 {
+			sort.Strings(exceeded)
+			messages = append(messages, fmt.Sprintf("exceeded userlandquota %q: %s", q.Name, strings.Join(exceeded, ", ")))
+		}
+	}
+	return messages, nil
+}
@@ -0,0 +1,127 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quota
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/naming"
+)
+
+func userland(name, user string, usage corev1.ResourceList) escv1alpha2.Userland {
+	return escv1alpha2.Userland{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "esc", Labels: map[string]string{naming.UserLabel: user, "team": "infra"}},
+		Status:     escv1alpha2.UserlandStatus{QuotaUsage: usage},
+	}
+}
+
+func running(cpu string) corev1.ResourceList {
+	return corev1.ResourceList{
+		escv1alpha2.ResourceRunning: resource.MustParse("1"),
+		corev1.ResourceRequestsCPU:  resource.MustParse(cpu),
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	q := &escv1alpha2.UserlandQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "infra", Namespace: "esc"},
+		Spec: escv1alpha2.UserlandQuotaSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "infra"}},
+			Scope:    escv1alpha2.UserQuotaScope,
+		},
+	}
+	other := userland("other", "bob", running("1"))
+	other.Labels["team"] = "web"
+	userlands := []escv1alpha2.Userland{
+		userland("vscode", "alice", running("500m")),
+		userland("jupyter", "alice", nil),
+		userland("rstudio", "carol", running("2")),
+		other,
+	}
+
+	used, users, err := Aggregate(q, userlands)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, cpu := used[escv1alpha2.ResourceUserlands], used[corev1.ResourceRequestsCPU]; n.Value() != 3 || cpu.MilliValue() != 2500 {
+		t.Errorf("used = %v, want 3 userlands and 2500m cpu", used)
+	}
+	if len(users) != 2 || users[0].User != "alice" || users[1].User != "carol" {
+		t.Fatalf("users = %v, want alice and carol", users)
+	}
+	if n := users[0].Used[escv1alpha2.ResourceRunning]; n.Value() != 1 {
+		t.Errorf("alice should have 1 running userland, got %v", users[0].Used)
+	}
+}
+
+func TestSelectsGroups(t *testing.T) {
+	q := &escv1alpha2.UserlandQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "infra", Namespace: "esc"},
+		Spec:       escv1alpha2.UserlandQuotaSpec{Groups: []string{"infra"}},
+	}
+	for _, tc := range []struct {
+		name     string
+		owner    *escv1alpha2.UserlandOwner
+		selected bool
+	}{
+		{name: "member", owner: &escv1alpha2.UserlandOwner{User: "alice", Groups: []string{"students", "infra"}}, selected: true},
+		{name: "not a member", owner: &escv1alpha2.UserlandOwner{User: "bob", Groups: []string{"students"}}, selected: false},
+		// the labels don't select a Userland by group
+		{name: "no owner", selected: false},
+	} {
+		u := userland("vscode", "alice", nil)
+		u.Spec.Owner = tc.owner
+		if selected, err := Selects(q, &u); err != nil || selected != tc.selected {
+			t.Errorf("%s: Selects = %v, %v, want %v", tc.name, selected, err, tc.selected)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	quotas := []escv1alpha2.UserlandQuota{{
+		ObjectMeta: metav1.ObjectMeta{Name: "per-user", Namespace: "esc"},
+		Spec: escv1alpha2.UserlandQuotaSpec{
+			Hard:  corev1.ResourceList{escv1alpha2.ResourceRunning: resource.MustParse("1"), corev1.ResourceRequestsCPU: resource.MustParse("2")},
+			Scope: escv1alpha2.UserQuotaScope,
+		},
+	}}
+	userlands := []escv1alpha2.Userland{
+		userland("vscode", "alice", running("500m")),
+		userland("rstudio", "carol", running("2")),
+	}
+	all := func(corev1.ResourceName) bool { return true }
+
+	jupyter := userland("jupyter", "alice", nil)
+	messages, err := Check(quotas, userlands, &jupyter, running("1"), all)
+	if err != nil || len(messages) != 1 {
+		t.Errorf("a second running userland of alice should exceed the quota, got %v, %v", messages, err)
+	}
+
+	dave := userland("jupyter", "dave", nil)
+	if messages, err := Check(quotas, userlands, &dave, running("1"), all); err != nil || len(messages) != 0 {
+		t.Errorf("the usage of other users should not be counted, got %v, %v", messages, err)
+	}
+
+	vscode := userlands[0]
+	if messages, err := Check(quotas, userlands, &vscode, running("1"), all); err != nil || len(messages) != 0 {
+		t.Errorf("the current usage of the userland itself should not be counted, got %v, %v", messages, err)
+	}
+}
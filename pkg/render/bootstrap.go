@@ -0,0 +1,210 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+)
+
+const (
+	// BootstrapContainerName is the name of the init container which bootstraps the volume of a Userland.
+	BootstrapContainerName = "esc-bootstrap"
+
+	// DefaultBootstrapImage is the default image of the bootstrap container.
+	// It is pinned to a version, set bootstrap.image of the Template to pin it by digest.
+	DefaultBootstrapImage = "alpine/git:v2.30.2"
+
+	// BootstrapMarker is the file created in the volume when it is bootstrapped.
+	BootstrapMarker = ".esc-bootstrapped"
+
+	bootstrapCredentialsVolume = "esc-bootstrap-credentials"
+	bootstrapCredentialsPath   = "/etc/esc/git"
+)
+
+// bootstrapScript clones the repositories into the volume unless the marker exists.
+// Existing files in the volume are never overwritten, so volumes created before bootstrap was set are kept.
+const bootstrapScript = `set -e
+export HOME=/tmp
+cd "$ESC_VOLUME"
+if [ -e ` + BootstrapMarker + ` ]; then
+  echo "already bootstrapped"
+  exit 0
+fi
+
+if [ -f ` + bootstrapCredentialsPath + `/ssh-privatekey ]; then
+  cp ` + bootstrapCredentialsPath + `/ssh-privatekey /tmp/id
+  chmod 600 /tmp/id
+  export GIT_SSH_COMMAND="ssh -i /tmp/id -o StrictHostKeyChecking=accept-new"
+fi
+if [ -f ` + bootstrapCredentialsPath + `/password ]; then
+  git config --global credential.helper '!f() { echo "username=$(cat ` + bootstrapCredentialsPath + `/username)"; echo "password=$(cat ` + bootstrapCredentialsPath + `/password)"; }; f'
+fi
+
+# clone into a temporary directory, so that a failed clone is retried from scratch
+clone() {
+  cloned=
+  if [ -e "$3" ]; then
+    echo "$3 already exists, skip cloning $1"
+    return 0
+  fi
+  rm -rf "$3.esc-tmp"
+  git clone ${2:+--branch "$2"} "$1" "$3.esc-tmp"
+  mv "$3.esc-tmp" "$3"
+  cloned=1
+}
+
+if [ -n "$ESC_REPOSITORY" ]; then
+  clone "$ESC_REPOSITORY" "$ESC_BRANCH" "$ESC_PATH"
+fi
+if [ -n "$ESC_DOTFILES" ]; then
+  clone "$ESC_DOTFILES" "" .dotfiles
+  if [ -z "$cloned" ]; then
+    :
+  elif [ -x .dotfiles/install.sh ]; then
+    HOME="$ESC_VOLUME" .dotfiles/install.sh
+  else
+    for f in .dotfiles/.[!.]*; do
+      name="${f#.dotfiles/}"
+      if [ -e "$f" ] && [ "$name" != .git ] && [ ! -e "$name" ]; then
+        ln -s "$f" "$name"
+      fi
+    done
+  fi
+fi
+touch ` + BootstrapMarker + `
+`
+
+// bootstrap adds the init container which bootstraps the volume of the Userland to the pod as the first init container.
+// The volume is mounted at the same path as the first container which mounts it, so that links created by
+// dotfiles are valid in the container.
+func bootstrap(spec *escv1alpha2.BootstrapSpec, templateSpec *escv1alpha2.TemplateSpec, podSpec *corev1.PodSpec) error {
+	policy := templateSpec.Bootstrap
+	if policy == nil {
+		policy = &escv1alpha2.BootstrapPolicy{}
+	}
+	if name := spec.CredentialsSecretName; name != "" && !contains(policy.AllowedCredentialsSecrets, name) {
+		return fmt.Errorf("bootstrap credentials secret %q is not allowed by the Template", name)
+	}
+
+	volume := spec.Volume
+	if volume == "" {
+		if len(templateSpec.VolumeSpecs) == 0 {
+			return fmt.Errorf("bootstrap requires a volume of the Template")
+		}
+		volume = templateSpec.VolumeSpecs[0].Name
+	}
+
+	mountPath := "/bootstrap"
+	var securityContext *corev1.SecurityContext
+	found := false
+	for _, c := range podSpec.Containers {
+		for _, m := range c.VolumeMounts {
+			if m.Name == volume && !found {
+				mountPath = path.Join(m.MountPath, m.SubPath)
+				securityContext = c.SecurityContext
+				found = true
+			}
+		}
+	}
+	if !found {
+		exists := false
+		for _, v := range podSpec.Volumes {
+			exists = exists || v.Name == volume
+		}
+		if !exists {
+			return fmt.Errorf("bootstrap volume %q is not found in the Template", volume)
+		}
+	}
+
+	repoPath := spec.Path
+	if repoPath == "" && spec.Repository != "" {
+		repoPath = strings.TrimSuffix(path.Base(strings.TrimRight(spec.Repository, "/")), ".git")
+	}
+	if strings.HasPrefix(repoPath, "/") || strings.Contains(repoPath, "..") {
+		return fmt.Errorf("bootstrap path %q must be relative to the volume", repoPath)
+	}
+
+	image := policy.Image
+	if image == "" {
+		image = DefaultBootstrapImage
+	}
+
+	container := corev1.Container{
+		Name:    BootstrapContainerName,
+		Image:   image,
+		Command: []string{"sh", "-c", bootstrapScript},
+		Env: []corev1.EnvVar{
+			{Name: "ESC_VOLUME", Value: mountPath},
+			{Name: "ESC_REPOSITORY", Value: spec.Repository},
+			{Name: "ESC_BRANCH", Value: spec.Branch},
+			{Name: "ESC_PATH", Value: repoPath},
+			{Name: "ESC_DOTFILES", Value: spec.DotfilesRepository},
+		},
+		VolumeMounts:    []corev1.VolumeMount{{Name: volume, MountPath: mountPath}},
+		SecurityContext: bootstrapSecurityContext(securityContext),
+		// the output of git is reported in the Bootstrapped condition of the Userland
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}
+
+	if spec.CredentialsSecretName != "" {
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: bootstrapCredentialsVolume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: spec.CredentialsSecretName},
+			},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      bootstrapCredentialsVolume,
+			MountPath: bootstrapCredentialsPath,
+			ReadOnly:  true,
+		})
+	}
+
+	podSpec.InitContainers = append([]corev1.Container{container}, podSpec.InitContainers...)
+	return nil
+}
+
+// bootstrapSecurityContext returns the security context of the bootstrap container. It runs as the same user as
+// the container, so that the files are owned by the user, but never with the privileges of the container.
+func bootstrapSecurityContext(c *corev1.SecurityContext) *corev1.SecurityContext {
+	privileged, allowPrivilegeEscalation := false, false
+	sc := &corev1.SecurityContext{
+		Privileged:               &privileged,
+		AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+		Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+	}
+	if c != nil {
+		sc.RunAsUser = c.RunAsUser
+		sc.RunAsGroup = c.RunAsGroup
+		sc.RunAsNonRoot = c.RunAsNonRoot
+	}
+	return sc.DeepCopy()
+}
+
+func contains(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
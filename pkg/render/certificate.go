@@ -0,0 +1,75 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/naming"
+)
+
+// CertificateGVK is the kind of cert-manager Certificates.
+// cert-manager is not a dependency of ESC, so Certificates are handled as unstructured objects.
+var CertificateGVK = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+
+// certificate renders the cert-manager Certificate of the Userland.
+// The Secret which stores the certificate has the same name as the Certificate.
+func (o *Objects) certificate(userland *escv1alpha2.Userland, spec *escv1alpha2.CertificateSpec) (*unstructured.Unstructured, error) {
+	dnsNames, err := expandHostnames(userland, spec.DNSNames)
+	if err != nil {
+		return nil, err
+	}
+
+	issuerRef := map[string]interface{}{"name": spec.IssuerRef.Name}
+	if spec.IssuerRef.Kind != "" {
+		issuerRef["kind"] = spec.IssuerRef.Kind
+	}
+	if spec.IssuerRef.Group != "" {
+		issuerRef["group"] = spec.IssuerRef.Group
+	}
+
+	cert := &unstructured.Unstructured{}
+	cert.SetGroupVersionKind(CertificateGVK)
+	cert.SetName(o.Names.Certificate)
+	cert.SetNamespace(o.Namespace)
+	cert.Object["spec"] = map[string]interface{}{
+		"secretName": o.Names.Certificate,
+		"dnsNames":   dnsNames,
+		"issuerRef":  issuerRef,
+	}
+	return cert, nil
+}
+
+// expandHostnames replaces the variables in the patterns of hostnames with the values of the Userland.
+// The result is a list of unstructured values.
+func expandHostnames(userland *escv1alpha2.Userland, patterns []string) ([]interface{}, error) {
+	var hostnames []interface{}
+	for _, pattern := range patterns {
+		hostname := strings.ToLower(naming.Expand(pattern, userland))
+		// wildcards are allowed by cert-manager and Gateway API
+		if errs := validation.IsDNS1123Subdomain(strings.TrimPrefix(hostname, "*.")); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid hostname %q generated from pattern %q: %s", hostname, pattern, strings.Join(errs, ", "))
+		}
+		hostnames = append(hostnames, hostname)
+	}
+	return hostnames, nil
+}
@@ -0,0 +1,84 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ConfigReferences returns the names of ConfigMaps and Secrets referenced by the pod spec,
+// from volumes and the environment variables of containers and init containers.
+func ConfigReferences(podSpec *corev1.PodSpec) (configMaps, secrets []string) {
+	cm := map[string]bool{}
+	sec := map[string]bool{}
+
+	for _, v := range podSpec.Volumes {
+		if v.ConfigMap != nil {
+			cm[v.ConfigMap.Name] = true
+		}
+		if v.Secret != nil {
+			sec[v.Secret.SecretName] = true
+		}
+		if v.Projected != nil {
+			for _, s := range v.Projected.Sources {
+				if s.ConfigMap != nil {
+					cm[s.ConfigMap.Name] = true
+				}
+				if s.Secret != nil {
+					sec[s.Secret.Name] = true
+				}
+			}
+		}
+	}
+
+	containers := append(append([]corev1.Container{}, podSpec.InitContainers...), podSpec.Containers...)
+	for _, c := range containers {
+		for _, e := range c.EnvFrom {
+			if e.ConfigMapRef != nil {
+				cm[e.ConfigMapRef.Name] = true
+			}
+			if e.SecretRef != nil {
+				sec[e.SecretRef.Name] = true
+			}
+		}
+		for _, e := range c.Env {
+			if e.ValueFrom == nil {
+				continue
+			}
+			if e.ValueFrom.ConfigMapKeyRef != nil {
+				cm[e.ValueFrom.ConfigMapKeyRef.Name] = true
+			}
+			if e.ValueFrom.SecretKeyRef != nil {
+				sec[e.ValueFrom.SecretKeyRef.Name] = true
+			}
+		}
+	}
+
+	return sortedKeys(cm), sortedKeys(sec)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	var keys []string
+	for k := range m {
+		if k != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
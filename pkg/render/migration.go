@@ -0,0 +1,130 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/naming"
+)
+
+const (
+	// DefaultMigrationImage is the default image of the Job which copies the data of a Userland.
+	DefaultMigrationImage = "busybox:1.36"
+
+	// MigrationLabel is set to the pods of a migration Job, the value is the name of the Job.
+	MigrationLabel = "esc.k06.in/migration"
+
+	// migrationBackoffLimit is the number of retries of a migration Job.
+	migrationBackoffLimit = 2
+)
+
+// MigrationVolumes returns the copies of the migration from the previous Template,
+// from the claims of the previous Template to the claims of the current Template mapped by the volume names.
+// Mappings whose volumes don't exist, and claims shared by both Templates are skipped.
+func MigrationVolumes(fromTemplate string, spec *escv1alpha2.MigrationSpec, fromClaims, toClaims map[string]string) []escv1alpha2.MigratedVolume {
+	if spec == nil {
+		return nil
+	}
+
+	var volumes []escv1alpha2.MigratedVolume
+	for _, m := range spec.Volumes {
+		if m.FromTemplate != "" && m.FromTemplate != fromTemplate {
+			continue
+		}
+		from, to := fromClaims[m.From], toClaims[m.To]
+		if from == "" || to == "" || from == to {
+			continue
+		}
+		volumes = append(volumes, escv1alpha2.MigratedVolume{From: from, To: to})
+	}
+	return volumes
+}
+
+// MigrationName returns the name of the migration Job of the Userland.
+func MigrationName(names escv1alpha2.ResourceNames) string {
+	return naming.Truncate(names.Deployment+"-migrate", naming.MaxLength)
+}
+
+// MigrationJob renders the Job which copies the data of the volumes.
+// Each volume is copied by an init container, so that the progress is observed from the pod.
+// Existing files are not overwritten.
+func MigrationJob(name, namespace string, spec *escv1alpha2.MigrationSpec, volumes []escv1alpha2.MigratedVolume) *batchv1.Job {
+	image := spec.Image
+	if image == "" {
+		image = DefaultMigrationImage
+	}
+
+	var podVolumes []corev1.Volume
+	var initContainers []corev1.Container
+	for i, v := range volumes {
+		from, to := fmt.Sprintf("from-%d", i), fmt.Sprintf("to-%d", i)
+		podVolumes = append(podVolumes,
+			corev1.Volume{Name: from, VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: v.From, ReadOnly: true},
+			}},
+			corev1.Volume{Name: to, VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: v.To},
+			}},
+		)
+		initContainers = append(initContainers, corev1.Container{
+			Name:    MigrationContainerName(i),
+			Image:   image,
+			Command: []string{"sh", "-c", "cp -a -n /from/. /to/"},
+			VolumeMounts: []corev1.VolumeMount{
+				{Name: from, MountPath: "/from", ReadOnly: true},
+				{Name: to, MountPath: "/to"},
+			},
+			TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+		})
+	}
+
+	labels := map[string]string{MigrationLabel: name}
+	backoffLimit := int32(migrationBackoffLimit)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					InitContainers: initContainers,
+					Containers: []corev1.Container{{
+						Name:    "done",
+						Image:   image,
+						Command: []string{"sh", "-c", "true"},
+					}},
+					Volumes:       podVolumes,
+					RestartPolicy: corev1.RestartPolicyNever,
+				},
+			},
+		},
+	}
+}
+
+// MigrationContainerName returns the name of the init container which copies the i-th volume.
+func MigrationContainerName(i int) string {
+	return fmt.Sprintf("copy-%d", i)
+}
@@ -0,0 +1,91 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/naming"
+)
+
+const (
+	// DefaultPauseImage is the image of the container which keeps pre-pull pods running.
+	DefaultPauseImage = "k8s.gcr.io/pause:3.1"
+
+	// PrePullLabel is set to the pods of a pre-pull DaemonSet, the value is the name of the Template.
+	PrePullLabel = "esc.k06.in/prepull"
+)
+
+// PrePullName returns the name of the pre-pull DaemonSet of the Template.
+func PrePullName(template *escv1alpha2.Template) string {
+	return naming.Truncate(template.Name+"-prepull", naming.MaxLength)
+}
+
+// PrePullDaemonSet renders the DaemonSet which pulls the images used by the resolved spec of the Template.
+// Each image is pulled by an init container which exits immediately, and the pod is kept by a pause container.
+func PrePullDaemonSet(template *escv1alpha2.Template, spec *escv1alpha2.TemplateSpec, pauseImage string) *appsv1.DaemonSet {
+	if pauseImage == "" {
+		pauseImage = DefaultPauseImage
+	}
+	podSpec := spec.Template.Spec
+
+	var initContainers []corev1.Container
+	pulled := map[string]bool{}
+	for _, c := range append(append([]corev1.Container{}, podSpec.InitContainers...), podSpec.Containers...) {
+		if c.Image == "" || pulled[c.Image] {
+			continue
+		}
+		pulled[c.Image] = true
+		initContainers = append(initContainers, corev1.Container{
+			Name:            fmt.Sprintf("pull-%d", len(initContainers)),
+			Image:           c.Image,
+			ImagePullPolicy: c.ImagePullPolicy,
+			Command:         []string{"sh", "-c", "true"},
+		})
+	}
+
+	labels := map[string]string{PrePullLabel: naming.Truncate(template.Name, naming.MaxLength)}
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      PrePullName(template),
+			Namespace: template.Namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					InitContainers: initContainers,
+					Containers: []corev1.Container{{
+						Name:  "pause",
+						Image: pauseImage,
+					}},
+					// run on the same nodes as Userlands
+					NodeSelector:     podSpec.NodeSelector,
+					Affinity:         podSpec.Affinity,
+					Tolerations:      podSpec.Tolerations,
+					ImagePullSecrets: podSpec.ImagePullSecrets,
+				},
+			},
+		},
+	}
+}
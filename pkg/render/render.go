@@ -0,0 +1,306 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package render renders the resources owned by a Userland from its Template.
+// It is shared by the controller and the esc command, so that Template changes can be reviewed offline.
+package render
+
+import (
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/access"
+	"github.com/koba1t/ESC/pkg/naming"
+)
+
+const (
+	// ResourceQuotaName and LimitRangeName are the names of the ResourceQuota and LimitRange
+	// in the dedicated namespace of a Userland.
+	ResourceQuotaName = "esc-quota"
+	LimitRangeName    = "esc-limits"
+)
+
+// Objects are the resources owned by a Userland.
+// Resources which are not required by the Template are nil.
+type Objects struct {
+	// Namespace is the namespace where the resources are placed.
+	Namespace string
+	// Names are the names of the resources.
+	Names escv1alpha2.ResourceNames
+
+	// UserNamespace, ResourceQuota, LimitRange and RoleBindings are rendered when namespacePerUser is set.
+	UserNamespace *corev1.Namespace
+	ResourceQuota *corev1.ResourceQuota
+	LimitRange    *corev1.LimitRange
+	RoleBindings  []*rbacv1.RoleBinding
+
+	// ServiceAccount is rendered when serviceAccount is set.
+	// Role and RoleBinding grant the rules of the Template to it, they are rendered only when rules are set.
+	ServiceAccount *corev1.ServiceAccount
+	Role           *rbacv1.Role
+	RoleBinding    *rbacv1.RoleBinding
+
+	PersistentVolumeClaims []*corev1.PersistentVolumeClaim
+	Deployment             *appsv1.Deployment
+	Service                *corev1.Service
+
+	// Certificate is a cert-manager Certificate rendered when certificate is set.
+	Certificate *unstructured.Unstructured
+	// Route is a Gateway API HTTPRoute rendered when route is set.
+	Route *unstructured.Unstructured
+}
+
+// List returns the rendered resources in the order they are created.
+func (o *Objects) List() []runtime.Object {
+	var objs []runtime.Object
+	if o.UserNamespace != nil {
+		objs = append(objs, o.UserNamespace)
+	}
+	if o.ResourceQuota != nil {
+		objs = append(objs, o.ResourceQuota)
+	}
+	if o.LimitRange != nil {
+		objs = append(objs, o.LimitRange)
+	}
+	for _, roleBinding := range o.RoleBindings {
+		objs = append(objs, roleBinding)
+	}
+	if o.ServiceAccount != nil {
+		objs = append(objs, o.ServiceAccount)
+	}
+	if o.Role != nil {
+		objs = append(objs, o.Role)
+	}
+	if o.RoleBinding != nil {
+		objs = append(objs, o.RoleBinding)
+	}
+	for _, persistentVolumeClaim := range o.PersistentVolumeClaims {
+		objs = append(objs, persistentVolumeClaim)
+	}
+	if o.Deployment != nil {
+		objs = append(objs, o.Deployment)
+	}
+	if o.Service != nil {
+		objs = append(objs, o.Service)
+	}
+	if o.Certificate != nil {
+		objs = append(objs, o.Certificate)
+	}
+	if o.Route != nil {
+		objs = append(objs, o.Route)
+	}
+	return objs
+}
+
+// Render renders the resources owned by the Userland from the resolved spec of its Template.
+// The resources have no ownerReferences, they are set by the controller when the resources are created.
+func Render(userland *escv1alpha2.Userland, spec *escv1alpha2.TemplateSpec, namer naming.Namer) (*Objects, error) {
+	names, err := namer.Names(userland, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := FindSize(spec, userland.Spec.Size)
+	if err != nil {
+		return nil, err
+	}
+	if size != nil {
+		for volume := range size.Storage {
+			if _, ok := names.PersistentVolumeClaims[volume]; !ok {
+				return nil, fmt.Errorf("size %q: volume %q is not found in the Template", size.Name, volume)
+			}
+		}
+	}
+
+	o := &Objects{Namespace: userland.Namespace}
+
+	// Dedicated namespace of the user
+	if nsSpec := spec.NamespacePerUser; nsSpec != nil {
+		o.Namespace = naming.Namespace(userland)
+		if errs := validation.IsDNS1123Label(o.Namespace); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid namespace name %q: %s", o.Namespace, strings.Join(errs, ", "))
+		}
+
+		o.UserNamespace = &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: o.Namespace}}
+		if nsSpec.ResourceQuota != nil {
+			o.ResourceQuota = &corev1.ResourceQuota{
+				ObjectMeta: o.objectMeta(ResourceQuotaName),
+				Spec:       *nsSpec.ResourceQuota.DeepCopy(),
+			}
+		}
+		if nsSpec.LimitRange != nil {
+			o.LimitRange = &corev1.LimitRange{
+				ObjectMeta: o.objectMeta(LimitRangeName),
+				Spec:       *nsSpec.LimitRange.DeepCopy(),
+			}
+		}
+		for _, b := range nsSpec.RoleBindings {
+			subjects := make([]rbacv1.Subject, len(b.Subjects))
+			for i, s := range b.Subjects {
+				s.Name = strings.Replace(s.Name, "$(USER)", naming.User(userland), -1)
+				s.Name = strings.Replace(s.Name, "$(OWNER)", access.Owner(userland), -1)
+				subjects[i] = s
+			}
+			if b.Collaborators {
+				subjects = append(subjects, access.CollaboratorSubjects(userland)...)
+			}
+			o.RoleBindings = append(o.RoleBindings, &rbacv1.RoleBinding{
+				ObjectMeta: o.objectMeta(b.Name),
+				RoleRef:    b.RoleRef,
+				Subjects:   subjects,
+			})
+		}
+	}
+
+	// ServiceAccount used by the pod
+	if saSpec := spec.ServiceAccount; saSpec != nil {
+		names.ServiceAccount = naming.ServiceAccount(userland)
+		o.ServiceAccount = &corev1.ServiceAccount{ObjectMeta: o.objectMeta(names.ServiceAccount)}
+		if len(saSpec.Rules) > 0 {
+			o.Role = &rbacv1.Role{
+				ObjectMeta: o.objectMeta(names.ServiceAccount),
+				Rules:      saSpec.Rules,
+			}
+			o.RoleBinding = &rbacv1.RoleBinding{
+				ObjectMeta: o.objectMeta(names.ServiceAccount),
+				RoleRef: rbacv1.RoleRef{
+					APIGroup: rbacv1.GroupName,
+					Kind:     "Role",
+					Name:     names.ServiceAccount,
+				},
+				Subjects: []rbacv1.Subject{{
+					Kind:      rbacv1.ServiceAccountKind,
+					Name:      names.ServiceAccount,
+					Namespace: o.Namespace,
+				}},
+			}
+		}
+	}
+	o.Names = names
+
+	// PersistentVolumeClaims and the volumes which refer to them
+	var volumes []corev1.Volume
+	for _, v := range spec.VolumeSpecs {
+		pvcName := names.PersistentVolumeClaims[v.Name]
+		pvc := &corev1.PersistentVolumeClaim{
+			ObjectMeta: o.objectMeta(pvcName),
+			Spec:       *v.PersistentVolumeClaimSpec.DeepCopy(),
+		}
+		if v.Seed != nil {
+			dataSource, err := seedDataSource(v.Seed)
+			if err != nil {
+				return nil, fmt.Errorf("volume %q: %v", v.Name, err)
+			}
+			if dataSource != nil {
+				pvc.Spec.DataSource = dataSource
+			}
+		}
+		if size != nil {
+			resizeClaim(size, v.Name, pvc)
+		}
+		o.PersistentVolumeClaims = append(o.PersistentVolumeClaims, pvc)
+		volumes = append(volumes, corev1.Volume{
+			Name: v.Name,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: pvcName,
+				},
+			},
+		})
+	}
+
+	// Deployment
+	// set the replicas from 1 by default, and If Userland.Spec.Enabled was false, deployment has no pod.
+	replicas := int32(1)
+	if userland.Spec.Enabled != nil && !*userland.Spec.Enabled {
+		replicas = int32(0)
+	}
+
+	podSpec := spec.Template.Spec.DeepCopy()
+	if names.ServiceAccount != "" {
+		podSpec.ServiceAccountName = names.ServiceAccount
+	}
+	podSpec.Volumes = append(podSpec.Volumes, volumes...)
+	if size != nil {
+		if err := resize(size, podSpec); err != nil {
+			return nil, err
+		}
+	}
+	if userland.Spec.Bootstrap != nil {
+		if err := bootstrap(userland.Spec.Bootstrap, spec, podSpec); err != nil {
+			return nil, err
+		}
+	}
+
+	o.Deployment = &appsv1.Deployment{
+		ObjectMeta: o.objectMeta(names.Deployment),
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: podLabels(userland, names)},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: podLabels(userland, names)},
+				Spec:       *podSpec,
+			},
+		},
+	}
+
+	// Service
+	o.Service = &corev1.Service{
+		ObjectMeta: o.objectMeta(names.Service),
+		Spec: corev1.ServiceSpec{
+			Ports:    spec.ServiceSpec.Ports,
+			Selector: podLabels(userland, names),
+			Type:     corev1.ServiceTypeClusterIP,
+		},
+	}
+
+	// Certificate
+	if spec.Certificate != nil {
+		if o.Certificate, err = o.certificate(userland, spec.Certificate); err != nil {
+			return nil, err
+		}
+	}
+
+	// Route
+	if spec.Route != nil {
+		if o.Route, err = o.route(userland, spec.Route, spec.ServiceSpec.Ports); err != nil {
+			return nil, err
+		}
+	}
+
+	return o, nil
+}
+
+// podLabels returns the labels of the pods of the Userland, which are selected by the Deployment and Service.
+func podLabels(userland *escv1alpha2.Userland, names escv1alpha2.ResourceNames) map[string]string {
+	return map[string]string{
+		"app":        names.Deployment,
+		"controller": userland.Name,
+		"template":   userland.Spec.TemplateName,
+	}
+}
+
+func (o *Objects) objectMeta(name string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Name: name, Namespace: o.Namespace}
+}
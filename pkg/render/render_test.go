@@ -0,0 +1,460 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/naming"
+)
+
+func TestRender(t *testing.T) {
+	enabled := false
+	userland := &escv1alpha2.Userland{
+		ObjectMeta: metav1.ObjectMeta{Name: "koba1t", Namespace: "esc"},
+		Spec:       escv1alpha2.UserlandSpec{TemplateName: "vscode", Enabled: &enabled},
+	}
+	spec := &escv1alpha2.TemplateSpec{
+		Template: corev1.PodTemplateSpec{
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "code-server", Image: "codercom/code-server"}}},
+		},
+		VolumeSpecs: []escv1alpha2.VolumeSpec{{Name: "home"}},
+		NamespacePerUser: &escv1alpha2.NamespacePerUserSpec{
+			RoleBindings: []escv1alpha2.UserRoleBinding{{
+				Name:     "edit",
+				RoleRef:  rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "edit"},
+				Subjects: []rbacv1.Subject{{Kind: rbacv1.UserKind, Name: "$(USER)@example.com"}},
+			}},
+		},
+		ServiceAccount: &escv1alpha2.UserServiceAccountSpec{},
+	}
+
+	objects, err := Render(userland, spec, naming.Namer{})
+	if err != nil {
+		t.Fatalf("Render returned error: %v", err)
+	}
+
+	if objects.Namespace != naming.Namespace(userland) || objects.UserNamespace == nil {
+		t.Errorf("resources should be placed in the dedicated namespace, got %q", objects.Namespace)
+	}
+	if got := objects.RoleBindings[0].Subjects[0].Name; got != "koba1t@example.com" {
+		t.Errorf("$(USER) should be replaced, got %q", got)
+	}
+	if objects.ServiceAccount == nil || objects.Role != nil {
+		t.Errorf("only ServiceAccount should be rendered when rules are empty")
+	}
+
+	podSpec := objects.Deployment.Spec.Template.Spec
+	if *objects.Deployment.Spec.Replicas != 0 {
+		t.Errorf("disabled userland should have no replicas")
+	}
+	if podSpec.ServiceAccountName != "userland-koba1t" {
+		t.Errorf("ServiceAccountName = %q, want %q", podSpec.ServiceAccountName, "userland-koba1t")
+	}
+	if len(podSpec.Volumes) != 1 || podSpec.Volumes[0].PersistentVolumeClaim.ClaimName != "vscode-koba1t-pvc-home" {
+		t.Errorf("volume should refer to the PersistentVolumeClaim, got %v", podSpec.Volumes)
+	}
+	if len(spec.Template.Spec.Volumes) != 0 {
+		t.Errorf("Render should not modify the Template")
+	}
+
+	if got := len(objects.List()); got != 6 {
+		t.Errorf("List returned %d objects, want 6", got)
+	}
+}
+
+func TestConfigReferences(t *testing.T) {
+	podSpec := &corev1.PodSpec{
+		Volumes: []corev1.Volume{
+			{Name: "config", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "settings"}}}},
+			{Name: "token", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "token"}}},
+		},
+		InitContainers: []corev1.Container{{
+			EnvFrom: []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "env"}}}},
+		}},
+		Containers: []corev1.Container{{
+			Env: []corev1.EnvVar{{Name: "PASSWORD", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "token"}, Key: "password"}}}},
+		}},
+	}
+
+	configMaps, secrets := ConfigReferences(podSpec)
+	if !reflect.DeepEqual(configMaps, []string{"env", "settings"}) {
+		t.Errorf("configMaps = %v, want [env settings]", configMaps)
+	}
+	if !reflect.DeepEqual(secrets, []string{"token"}) {
+		t.Errorf("secrets = %v, want [token]", secrets)
+	}
+}
+
+func TestPrePullDaemonSet(t *testing.T) {
+	template := &escv1alpha2.Template{ObjectMeta: metav1.ObjectMeta{Name: "vscode", Namespace: "esc"}}
+	spec := &escv1alpha2.TemplateSpec{
+		Template: corev1.PodTemplateSpec{
+			Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "init", Image: "busybox"}},
+				Containers:     []corev1.Container{{Name: "code-server", Image: "codercom/code-server"}, {Name: "sidecar", Image: "busybox"}},
+			},
+		},
+	}
+
+	daemonSet := PrePullDaemonSet(template, spec, "")
+	var images []string
+	for _, c := range daemonSet.Spec.Template.Spec.InitContainers {
+		images = append(images, c.Image)
+	}
+	if !reflect.DeepEqual(images, []string{"busybox", "codercom/code-server"}) {
+		t.Errorf("images = %v, want each image once", images)
+	}
+	if got := daemonSet.Spec.Template.Spec.Containers[0].Image; got != DefaultPauseImage {
+		t.Errorf("pause image = %q, want %q", got, DefaultPauseImage)
+	}
+}
+
+func TestRenderCertificate(t *testing.T) {
+	userland := &escv1alpha2.Userland{
+		ObjectMeta: metav1.ObjectMeta{Name: "koba1t", Namespace: "esc"},
+		Spec:       escv1alpha2.UserlandSpec{TemplateName: "vscode"},
+	}
+	spec := &escv1alpha2.TemplateSpec{
+		Certificate: &escv1alpha2.CertificateSpec{
+			IssuerRef: escv1alpha2.CertificateIssuerRef{Name: "letsencrypt", Kind: "ClusterIssuer"},
+			DNSNames:  []string{"$(NAME).$(NAMESPACE).example.com"},
+		},
+	}
+
+	objects, err := Render(userland, spec, naming.Namer{})
+	if err != nil {
+		t.Fatalf("Render returned error: %v", err)
+	}
+
+	cert := objects.Certificate
+	if cert == nil || cert.GetName() != "vscode-koba1t-tls" || objects.Names.Certificate != cert.GetName() {
+		t.Fatalf("Certificate should be rendered with the name in ResourceNames, got %v", cert)
+	}
+	dnsNames, _, _ := unstructured.NestedStringSlice(cert.Object, "spec", "dnsNames")
+	if !reflect.DeepEqual(dnsNames, []string{"koba1t.esc.example.com"}) {
+		t.Errorf("dnsNames = %v, want [koba1t.esc.example.com]", dnsNames)
+	}
+
+	spec.Certificate.DNSNames = []string{"$(NAME)_invalid"}
+	if _, err := Render(userland, spec, naming.Namer{}); err == nil {
+		t.Errorf("Render should return error for an invalid DNS name")
+	}
+}
+
+func TestRenderRoute(t *testing.T) {
+	userland := &escv1alpha2.Userland{
+		ObjectMeta: metav1.ObjectMeta{Name: "koba1t", Namespace: "esc"},
+		Spec:       escv1alpha2.UserlandSpec{TemplateName: "vscode"},
+	}
+	spec := &escv1alpha2.TemplateSpec{
+		ServiceSpec: corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 8080}}},
+		Route: &escv1alpha2.RouteSpec{
+			ParentRefs: []escv1alpha2.RouteParentRef{{Name: "gateway", Namespace: "infra"}},
+			Hostnames:  []string{"ide.example.com"},
+			Path:       "/$(USER)",
+		},
+	}
+
+	objects, err := Render(userland, spec, naming.Namer{})
+	if err != nil {
+		t.Fatalf("Render returned error: %v", err)
+	}
+
+	route := objects.Route
+	if route == nil || route.GetName() != objects.Names.Route {
+		t.Fatalf("HTTPRoute should be rendered with the name in ResourceNames, got %v", route)
+	}
+	rules, _, _ := unstructured.NestedSlice(route.Object, "spec", "rules")
+	rule := rules[0].(map[string]interface{})
+	match := rule["matches"].([]interface{})[0].(map[string]interface{})["path"].(map[string]interface{})
+	if match["value"] != "/koba1t" {
+		t.Errorf("path = %v, want /koba1t", match["value"])
+	}
+	backend := rule["backendRefs"].([]interface{})[0].(map[string]interface{})
+	if backend["name"] != objects.Names.Service || backend["port"] != int64(8080) {
+		t.Errorf("backend = %v, want the first port of the Service", backend)
+	}
+
+	spec.ServiceSpec.Ports = nil
+	if _, err := Render(userland, spec, naming.Namer{}); err == nil {
+		t.Errorf("Render should return error when the port is unknown")
+	}
+}
+
+func TestRenderBootstrap(t *testing.T) {
+	userland := &escv1alpha2.Userland{
+		ObjectMeta: metav1.ObjectMeta{Name: "koba1t", Namespace: "esc"},
+		Spec: escv1alpha2.UserlandSpec{
+			TemplateName: "vscode",
+			Bootstrap: &escv1alpha2.BootstrapSpec{
+				Repository:            "https://github.com/koba1t/ESC.git",
+				CredentialsSecretName: "git",
+			},
+		},
+	}
+	spec := &escv1alpha2.TemplateSpec{
+		Template: corev1.PodTemplateSpec{
+			Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "init"}},
+				Containers: []corev1.Container{{
+					Name:         "code-server",
+					VolumeMounts: []corev1.VolumeMount{{Name: "home", MountPath: "/home/coder"}},
+				}},
+			},
+		},
+		VolumeSpecs: []escv1alpha2.VolumeSpec{{Name: "home"}},
+	}
+
+	if _, err := Render(userland, spec, naming.Namer{}); err == nil {
+		t.Errorf("Render should return error for credentials not allowed by the Template")
+	}
+	spec.Bootstrap = &escv1alpha2.BootstrapPolicy{AllowedCredentialsSecrets: []string{"git"}}
+
+	privileged := true
+	spec.Template.Spec.Containers[0].SecurityContext = &corev1.SecurityContext{Privileged: &privileged, RunAsUser: new(int64)}
+
+	objects, err := Render(userland, spec, naming.Namer{})
+	if err != nil {
+		t.Fatalf("Render returned error: %v", err)
+	}
+
+	podSpec := objects.Deployment.Spec.Template.Spec
+	if len(podSpec.InitContainers) != 2 || podSpec.InitContainers[0].Name != BootstrapContainerName {
+		t.Fatalf("bootstrap should be the first init container, got %v", podSpec.InitContainers)
+	}
+	c := podSpec.InitContainers[0]
+	if c.VolumeMounts[0].Name != "home" || c.VolumeMounts[0].MountPath != "/home/coder" {
+		t.Errorf("volume should be mounted at the same path as the container, got %v", c.VolumeMounts[0])
+	}
+	env := map[string]string{}
+	for _, e := range c.Env {
+		env[e.Name] = e.Value
+	}
+	if env["ESC_PATH"] != "ESC" {
+		t.Errorf("ESC_PATH = %q, want the name of the repository", env["ESC_PATH"])
+	}
+	if len(podSpec.Volumes) != 2 || podSpec.Volumes[1].Secret == nil {
+		t.Errorf("credentials should be mounted, got %v", podSpec.Volumes)
+	}
+	if sc := c.SecurityContext; sc == nil || sc.Privileged == nil || *sc.Privileged || sc.RunAsUser == nil {
+		t.Errorf("bootstrap should run as the user of the container without privileges, got %v", sc)
+	}
+	if c.Image != DefaultBootstrapImage {
+		t.Errorf("image = %q, want %q", c.Image, DefaultBootstrapImage)
+	}
+
+	userland.Spec.Bootstrap.Volume = "unknown"
+	if _, err := Render(userland, spec, naming.Namer{}); err == nil {
+		t.Errorf("Render should return error for an unknown volume")
+	}
+}
+
+func TestMigrationVolumes(t *testing.T) {
+	spec := &escv1alpha2.MigrationSpec{Volumes: []escv1alpha2.VolumeMigration{
+		{From: "user-volume", To: "home"},
+		{FromTemplate: "rstudio", From: "data", To: "data"},
+		{From: "shared", To: "shared"},
+	}}
+	fromClaims := map[string]string{"user-volume": "vscode-koba1t-pvc-user-volume", "data": "vscode-koba1t-pvc-data", "shared": "koba1t-pvc-shared"}
+	toClaims := map[string]string{"home": "jupyter-koba1t-pvc-home", "data": "jupyter-koba1t-pvc-data", "shared": "koba1t-pvc-shared"}
+
+	volumes := MigrationVolumes("vscode", spec, fromClaims, toClaims)
+	want := []escv1alpha2.MigratedVolume{{From: "vscode-koba1t-pvc-user-volume", To: "jupyter-koba1t-pvc-home"}}
+	if !reflect.DeepEqual(volumes, want) {
+		t.Errorf("MigrationVolumes = %v, want %v", volumes, want)
+	}
+
+	job := MigrationJob("vscode-koba1t-migrate", "esc", spec, volumes)
+	podSpec := job.Spec.Template.Spec
+	if len(podSpec.InitContainers) != 1 || podSpec.InitContainers[0].Name != MigrationContainerName(0) {
+		t.Errorf("each volume should be copied by an init container, got %v", podSpec.InitContainers)
+	}
+	if len(podSpec.Volumes) != 2 || !podSpec.Volumes[0].PersistentVolumeClaim.ReadOnly {
+		t.Errorf("the claim copied from should be mounted read only, got %v", podSpec.Volumes)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	source, err := seedDataSource(&escv1alpha2.VolumeSeed{VolumeSnapshot: "golden"})
+	if err != nil || source.Kind != "VolumeSnapshot" || *source.APIGroup != volumeSnapshotGroup {
+		t.Errorf("seedDataSource = %v, %v, want a VolumeSnapshot", source, err)
+	}
+	if _, err := seedDataSource(&escv1alpha2.VolumeSeed{VolumeSnapshot: "golden", Image: "example/seed"}); err == nil {
+		t.Errorf("seedDataSource should reject more than one source")
+	}
+
+	seed := &escv1alpha2.VolumeSeed{ConfigMap: &corev1.ConfigMapKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "workshop"},
+		Key:                  "seed.tar",
+	}}
+	if source, err := seedDataSource(seed); err != nil || source != nil {
+		t.Errorf("seedDataSource = %v, %v, want no data source for a tarball", source, err)
+	}
+	job := SeedJob("esc", "vscode-koba1t-pvc-home", seed)
+	if job.Name != "vscode-koba1t-pvc-home-seed" {
+		t.Errorf("SeedJob name = %q", job.Name)
+	}
+	container := job.Spec.Template.Spec.Containers[0]
+	if container.Image != DefaultSeedImage || container.Command[len(container.Command)-1] != "/seed/seed.tar" {
+		t.Errorf("the tarball in the configMap should be extracted, got %v", container)
+	}
+}
+
+func TestRenderSize(t *testing.T) {
+	userland := &escv1alpha2.Userland{
+		ObjectMeta: metav1.ObjectMeta{Name: "koba1t", Namespace: "esc"},
+		Spec:       escv1alpha2.UserlandSpec{TemplateName: "vscode", Size: "large"},
+	}
+	spec := &escv1alpha2.TemplateSpec{
+		Template: corev1.PodTemplateSpec{
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name:  "code-server",
+				Image: "codercom/code-server",
+				Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("500m"),
+					corev1.ResourceMemory: resource.MustParse("1Gi"),
+				}},
+			}}},
+		},
+		VolumeSpecs: []escv1alpha2.VolumeSpec{{Name: "home", PersistentVolumeClaimSpec: corev1.PersistentVolumeClaimSpec{
+			Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("2Gi")}},
+		}}},
+		Sizes: []escv1alpha2.SizePreset{{
+			Name:      "large",
+			Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")}},
+			Storage:   map[string]resource.Quantity{"home": resource.MustParse("20Gi")},
+		}},
+	}
+
+	objects, err := Render(userland, spec, naming.Namer{})
+	if err != nil {
+		t.Fatalf("Render returned error: %v", err)
+	}
+	requests := objects.Deployment.Spec.Template.Spec.Containers[0].Resources.Requests
+	if cpu, memory := requests[corev1.ResourceCPU], requests[corev1.ResourceMemory]; cpu.String() != "2" || memory.String() != "1Gi" {
+		t.Errorf("the preset should replace only its resources, got %v", requests)
+	}
+	if storage := objects.PersistentVolumeClaims[0].Spec.Resources.Requests[corev1.ResourceStorage]; storage.String() != "20Gi" {
+		t.Errorf("storage = %v, want 20Gi", storage.String())
+	}
+	if cpu := spec.Template.Spec.Containers[0].Resources.Requests[corev1.ResourceCPU]; cpu.String() != "500m" {
+		t.Errorf("Render should not modify the Template")
+	}
+
+	userland.Spec.Size = "huge"
+	if _, err := Render(userland, spec, naming.Namer{}); err == nil {
+		t.Errorf("Render should reject an unknown size")
+	}
+}
+
+func TestResolveTemplate(t *testing.T) {
+	template := func(name, base string, patches ...escv1alpha2.TemplatePatch) *escv1alpha2.Template {
+		return &escv1alpha2.Template{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "esc"},
+			Spec:       escv1alpha2.TemplateSpec{BaseTemplate: base, Patches: patches},
+		}
+	}
+	strategic := func(patch string) escv1alpha2.TemplatePatch {
+		return escv1alpha2.TemplatePatch{Patch: patch}
+	}
+	root := template("root", "")
+	root.Spec.Template = corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"layer": "root"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "code-server", Image: "codercom/code-server:v1"}}},
+	}
+	// the allowed subjects of a derived Template replace those of the base, and are inherited if they are not set
+	restricted := template("restricted", "root")
+	restricted.Spec.AllowedSubjects = []rbacv1.Subject{{APIGroup: rbacv1.GroupName, Kind: rbacv1.GroupKind, Name: "infra"}}
+	root.Spec.AllowedSubjects = []rbacv1.Subject{{APIGroup: rbacv1.GroupName, Kind: rbacv1.GroupKind, Name: "students"}}
+
+	scheme := runtime.NewScheme()
+	_ = escv1alpha2.AddToScheme(scheme)
+	c := fake.NewFakeClientWithScheme(scheme,
+		root,
+		template("middle", "root", strategic(`{"template":{"metadata":{"labels":{"layer":"middle","middle":"true"}}}}`)),
+		template("leaf", "middle",
+			strategic(`{"template":{"metadata":{"labels":{"layer":"leaf"}}}}`),
+			escv1alpha2.TemplatePatch{Type: escv1alpha2.JSONPatchType, Patch: `[{"op":"replace","path":"/template/spec/containers/0/image","value":"codercom/code-server:v2"}]`},
+		),
+		restricted,
+		template("open", "restricted"),
+		template("a", "b"),
+		template("b", "a"),
+		template("orphan", "missing"),
+		template("broken", "root", escv1alpha2.TemplatePatch{Type: escv1alpha2.JSONPatchType, Patch: `[{"op":"replace","path":"/missing/field","value":1}]`}),
+	)
+
+	for _, tc := range []struct {
+		name    string
+		bases   []string
+		reason  string
+		labels  map[string]string
+		image   string
+		allowed string
+	}{
+		{name: "root", image: "codercom/code-server:v1", labels: map[string]string{"layer": "root"}, allowed: "students"},
+		{name: "leaf", bases: []string{"middle", "root"}, image: "codercom/code-server:v2", labels: map[string]string{"layer": "leaf", "middle": "true"}, allowed: "students"},
+		{name: "restricted", bases: []string{"root"}, image: "codercom/code-server:v1", labels: map[string]string{"layer": "root"}, allowed: "infra"},
+		{name: "open", bases: []string{"restricted", "root"}, image: "codercom/code-server:v1", labels: map[string]string{"layer": "root"}, allowed: "infra"},
+		{name: "a", bases: []string{"b"}, reason: "CycleDetected"},
+		{name: "orphan", bases: []string{"missing"}, reason: "BaseTemplateNotFound"},
+		{name: "broken", bases: []string{"root"}, reason: "PatchFailed"},
+	} {
+		var current escv1alpha2.Template
+		if err := c.Get(context.Background(), types.NamespacedName{Namespace: "esc", Name: tc.name}, &current); err != nil {
+			t.Fatal(err)
+		}
+		spec, bases, err := ResolveTemplate(context.Background(), c, &current)
+		if !reflect.DeepEqual(bases, tc.bases) {
+			t.Errorf("%s: bases = %v, want %v", tc.name, bases, tc.bases)
+		}
+		if tc.reason != "" {
+			if rerr, ok := err.(*ResolveError); !ok || rerr.Reason != tc.reason {
+				t.Errorf("%s: err = %v, want reason %s", tc.name, err, tc.reason)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: ResolveTemplate returned error: %v", tc.name, err)
+			continue
+		}
+		if got := spec.Template.Spec.Containers[0].Image; got != tc.image {
+			t.Errorf("%s: image = %q, want %q", tc.name, got, tc.image)
+		}
+		if !reflect.DeepEqual(spec.Template.Labels, tc.labels) {
+			t.Errorf("%s: labels = %v, want %v", tc.name, spec.Template.Labels, tc.labels)
+		}
+		if len(spec.AllowedSubjects) != 1 || spec.AllowedSubjects[0].Name != tc.allowed {
+			t.Errorf("%s: allowedSubjects = %v, want %s", tc.name, spec.AllowedSubjects, tc.allowed)
+		}
+		if spec.BaseTemplate != "" || spec.Patches != nil {
+			t.Errorf("%s: the resolved spec should have no base and patches", tc.name)
+		}
+	}
+}
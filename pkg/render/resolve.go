@@ -0,0 +1,126 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+)
+
+// ResolveError is an error which can't be fixed without changing Templates.
+// Reason is reported in the Resolved condition of the Template.
+type ResolveError struct {
+	Reason string
+	Err    error
+}
+
+func (e *ResolveError) Error() string {
+	return e.Err.Error()
+}
+
+// ResolveTemplate returns the effective spec of the Template and the chain of its base Templates.
+// The spec of the root Template is patched by each derived Template in order, and the allowedSubjects
+// of a derived Template replace those of its bases.
+// Base Templates are read from c, which may be a client of a cluster or of local files.
+func ResolveTemplate(ctx context.Context, c client.Reader, template *escv1alpha2.Template) (*escv1alpha2.TemplateSpec, []string, error) {
+	chain := []*escv1alpha2.Template{template}
+	visited := map[string]bool{template.Name: true}
+	var bases []string
+
+	current := template
+	for current.Spec.BaseTemplate != "" {
+		name := current.Spec.BaseTemplate
+		if visited[name] {
+			return nil, bases, &ResolveError{Reason: "CycleDetected", Err: fmt.Errorf("template %q is a base of itself", name)}
+		}
+		visited[name] = true
+		bases = append(bases, name)
+
+		var base escv1alpha2.Template
+		if err := c.Get(ctx, types.NamespacedName{Namespace: template.Namespace, Name: name}, &base); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, bases, &ResolveError{Reason: "BaseTemplateNotFound", Err: err}
+			}
+			return nil, bases, err
+		}
+		chain = append(chain, &base)
+		current = &base
+	}
+
+	spec := current.Spec.DeepCopy()
+	for i := len(chain) - 2; i >= 0; i-- {
+		patched, err := applyTemplatePatches(spec, chain[i].Spec.Patches)
+		if err != nil {
+			return nil, bases, &ResolveError{Reason: "PatchFailed", Err: fmt.Errorf("template %q: %v", chain[i].Name, err)}
+		}
+		spec = patched
+		// the allowed subjects of a derived Template replace those of its bases, so that it can be restricted
+		if subjects := chain[i].Spec.AllowedSubjects; len(subjects) > 0 {
+			spec.AllowedSubjects = subjects
+		}
+	}
+	spec.BaseTemplate = ""
+	spec.Patches = nil
+
+	return spec, bases, nil
+}
+
+// applyTemplatePatches applies the patches to the spec in order.
+func applyTemplatePatches(spec *escv1alpha2.TemplateSpec, patches []escv1alpha2.TemplatePatch) (*escv1alpha2.TemplateSpec, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, p := range patches {
+		patch, err := yaml.YAMLToJSON([]byte(p.Patch))
+		if err != nil {
+			return nil, fmt.Errorf("patches[%d]: %v", i, err)
+		}
+
+		switch p.Type {
+		case "", escv1alpha2.StrategicMergePatchType:
+			data, err = strategicpatch.StrategicMergePatch(data, patch, escv1alpha2.TemplateSpec{})
+		case escv1alpha2.JSONPatchType:
+			var decoded jsonpatch.Patch
+			decoded, err = jsonpatch.DecodePatch(patch)
+			if err == nil {
+				data, err = decoded.Apply(data)
+			}
+		default:
+			err = fmt.Errorf("unknown patch type %q", p.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("patches[%d]: %v", i, err)
+		}
+	}
+
+	var result escv1alpha2.TemplateSpec
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,96 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/naming"
+)
+
+// HTTPRouteGVK is the kind of Gateway API HTTPRoutes.
+// Gateway API is not a dependency of ESC, so HTTPRoutes are handled as unstructured objects.
+var HTTPRouteGVK = schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "HTTPRoute"}
+
+// route renders the HTTPRoute of the Userland, whose backend is the Service of the Userland.
+func (o *Objects) route(userland *escv1alpha2.Userland, spec *escv1alpha2.RouteSpec, ports []corev1.ServicePort) (*unstructured.Unstructured, error) {
+	var port int64
+	switch {
+	case spec.Port != nil:
+		port = int64(*spec.Port)
+	case len(ports) > 0:
+		port = int64(ports[0].Port)
+	default:
+		return nil, fmt.Errorf("route requires a port of the Service")
+	}
+
+	path := "/"
+	if spec.Path != "" {
+		path = naming.Expand(spec.Path, userland)
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("invalid route path %q generated from pattern %q: must start with /", path, spec.Path)
+	}
+
+	hostnames, err := expandHostnames(userland, spec.Hostnames)
+	if err != nil {
+		return nil, err
+	}
+
+	var parentRefs []interface{}
+	for _, ref := range spec.ParentRefs {
+		parentRef := map[string]interface{}{"name": ref.Name}
+		if ref.Namespace != "" {
+			parentRef["namespace"] = ref.Namespace
+		}
+		if ref.SectionName != "" {
+			parentRef["sectionName"] = ref.SectionName
+		}
+		parentRefs = append(parentRefs, parentRef)
+	}
+
+	routeSpec := map[string]interface{}{
+		"parentRefs": parentRefs,
+		"rules": []interface{}{
+			map[string]interface{}{
+				"matches": []interface{}{
+					map[string]interface{}{
+						"path": map[string]interface{}{"type": "PathPrefix", "value": path},
+					},
+				},
+				"backendRefs": []interface{}{
+					map[string]interface{}{"name": o.Names.Service, "port": port},
+				},
+			},
+		},
+	}
+	if len(hostnames) > 0 {
+		routeSpec["hostnames"] = hostnames
+	}
+
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(HTTPRouteGVK)
+	route.SetName(o.Names.Route)
+	route.SetNamespace(o.Namespace)
+	route.Object["spec"] = routeSpec
+	return route, nil
+}
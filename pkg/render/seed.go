@@ -0,0 +1,123 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/naming"
+)
+
+const (
+	// DefaultSeedImage is the image of the Job which extracts a tarball in a ConfigMap.
+	DefaultSeedImage = DefaultMigrationImage
+
+	// DefaultSeedPath is the default path of the tarball in a seed image.
+	DefaultSeedPath = "/seed.tar"
+
+	volumeSnapshotGroup = "snapshot.storage.k8s.io"
+)
+
+// seedDataSource returns the data source of the claim for a seed from a VolumeSnapshot or a claim,
+// or nil for a seed from a tarball.
+func seedDataSource(seed *escv1alpha2.VolumeSeed) (*corev1.TypedLocalObjectReference, error) {
+	sources := 0
+	for _, set := range []bool{seed.VolumeSnapshot != "", seed.PersistentVolumeClaim != "", seed.ConfigMap != nil, seed.Image != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return nil, fmt.Errorf("seed must have exactly one of volumeSnapshot, persistentVolumeClaim, configMap and image")
+	}
+
+	switch {
+	case seed.VolumeSnapshot != "":
+		group := volumeSnapshotGroup
+		return &corev1.TypedLocalObjectReference{APIGroup: &group, Kind: "VolumeSnapshot", Name: seed.VolumeSnapshot}, nil
+	case seed.PersistentVolumeClaim != "":
+		return &corev1.TypedLocalObjectReference{Kind: "PersistentVolumeClaim", Name: seed.PersistentVolumeClaim}, nil
+	}
+	return nil, nil
+}
+
+// HasTarballSeed returns true if the seed is extracted from a tarball by a Job.
+func HasTarballSeed(seed *escv1alpha2.VolumeSeed) bool {
+	return seed != nil && (seed.ConfigMap != nil || seed.Image != "")
+}
+
+// SeedName returns the name of the Job which seeds the claim.
+func SeedName(claimName string) string {
+	return naming.Truncate(claimName+"-seed", naming.MaxLength)
+}
+
+// SeedJob renders the Job which extracts the tarball of the seed into the claim.
+func SeedJob(namespace, claimName string, seed *escv1alpha2.VolumeSeed) *batchv1.Job {
+	image, path := seed.Image, seed.Path
+	if path == "" {
+		path = DefaultSeedPath
+	}
+	volumes := []corev1.Volume{{
+		Name: "data",
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+		},
+	}}
+	mounts := []corev1.VolumeMount{{Name: "data", MountPath: "/data"}}
+
+	if seed.ConfigMap != nil {
+		image, path = DefaultSeedImage, "/seed/seed.tar"
+		volumes = append(volumes, corev1.Volume{
+			Name: "seed",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: seed.ConfigMap.LocalObjectReference,
+					Items:                []corev1.KeyToPath{{Key: seed.ConfigMap.Key, Path: "seed.tar"}},
+				},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{Name: "seed", MountPath: "/seed", ReadOnly: true})
+	}
+
+	backoffLimit := int32(migrationBackoffLimit)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      SeedName(claimName),
+			Namespace: namespace,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name:                     "seed",
+						Image:                    image,
+						Command:                  []string{"sh", "-c", `tar -xf "$0" -C /data`, path},
+						VolumeMounts:             mounts,
+						TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+					}},
+					Volumes:       volumes,
+					RestartPolicy: corev1.RestartPolicyNever,
+				},
+			},
+		},
+	}
+}
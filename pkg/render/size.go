@@ -0,0 +1,70 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+)
+
+// FindSize returns the size preset of the Template selected by the name, or nil if the name is empty.
+func FindSize(spec *escv1alpha2.TemplateSpec, name string) (*escv1alpha2.SizePreset, error) {
+	if name == "" {
+		return nil, nil
+	}
+	for i := range spec.Sizes {
+		if spec.Sizes[i].Name == name {
+			return &spec.Sizes[i], nil
+		}
+	}
+	return nil, fmt.Errorf("size %q is not found in the Template", name)
+}
+
+// resize applies the resources of the size preset to the first container of the pod.
+func resize(size *escv1alpha2.SizePreset, podSpec *corev1.PodSpec) error {
+	if len(podSpec.Containers) == 0 {
+		return fmt.Errorf("size %q requires a container in the Template", size.Name)
+	}
+	resources := &podSpec.Containers[0].Resources
+	resources.Requests = mergeResources(resources.Requests, size.Resources.Requests)
+	resources.Limits = mergeResources(resources.Limits, size.Resources.Limits)
+	return nil
+}
+
+// resizeClaim applies the storage of the size preset for the volume to the claim.
+func resizeClaim(size *escv1alpha2.SizePreset, volume string, pvc *corev1.PersistentVolumeClaim) {
+	if storage, ok := size.Storage[volume]; ok {
+		pvc.Spec.Resources.Requests = mergeResources(pvc.Spec.Resources.Requests, corev1.ResourceList{corev1.ResourceStorage: storage})
+	}
+}
+
+// mergeResources returns the resources with the values of the overrides.
+func mergeResources(resources, overrides corev1.ResourceList) corev1.ResourceList {
+	if len(overrides) == 0 {
+		return resources
+	}
+	merged := corev1.ResourceList{}
+	for name, q := range resources {
+		merged[name] = q.DeepCopy()
+	}
+	for name, q := range overrides {
+		merged[name] = q.DeepCopy()
+	}
+	return merged
+}
@@ -0,0 +1,61 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/naming"
+)
+
+const (
+	// WarmPoolLabel is set to unclaimed pods in the warm pool of a Template, the value is the name of the Template.
+	WarmPoolLabel = "esc.k06.in/warm-pool"
+
+	// PodSpecHashAnnotation is set to pods in a warm pool, so that pods of an old spec are replaced.
+	PodSpecHashAnnotation = "esc.k06.in/pod-spec-hash"
+)
+
+// WarmPod renders a pod in the warm pool of the Template from its resolved spec.
+// Volumes of the Template are replaced by emptyDir, because the pod is not bound to a user yet.
+func WarmPod(template *escv1alpha2.Template, spec *escv1alpha2.TemplateSpec) *corev1.Pod {
+	podSpec := spec.Template.Spec.DeepCopy()
+	for _, v := range spec.VolumeSpecs {
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name:         v.Name,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+	}
+
+	data, _ := json.Marshal(podSpec)
+	hash := fmt.Sprintf("%x", sha256.Sum256(data))[:16]
+
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: naming.Truncate(template.Name+"-warm", naming.MaxLength-6) + "-",
+			Namespace:    template.Namespace,
+			Labels:       map[string]string{WarmPoolLabel: naming.Truncate(template.Name, naming.MaxLength)},
+			Annotations:  map[string]string{PodSpecHashAnnotation: hash},
+		},
+		Spec: *podSpec,
+	}
+}
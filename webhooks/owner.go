@@ -0,0 +1,117 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/naming"
+)
+
+// DefaultUserlandPath is the path of the mutating webhook of Userlands.
+const DefaultUserlandPath = "/mutate-esc-k06-in-v1alpha2-userland"
+
+// +kubebuilder:webhook:path=/mutate-esc-k06-in-v1alpha2-userland,mutating=true,failurePolicy=fail,groups=esc.k06.in,resources=userlands,verbs=create,versions=v1alpha2,name=muserland.esc.k06.in
+
+// UserlandDefaulter sets spec.owner of new Userlands to the user who creates them.
+type UserlandDefaulter struct {
+	decoder *admission.Decoder
+}
+
+// InjectDecoder injects the decoder of the requests.
+func (d *UserlandDefaulter) InjectDecoder(decoder *admission.Decoder) error {
+	d.decoder = decoder
+	return nil
+}
+
+// Handle sets the owner of the Userland in the request.
+func (d *UserlandDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1beta1.Create {
+		return admission.Allowed("")
+	}
+	var userland escv1alpha2.Userland
+	if err := d.decoder.Decode(req, &userland); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if userland.Spec.Owner != nil {
+		return admission.Allowed("")
+	}
+
+	userland.Spec.Owner = &escv1alpha2.UserlandOwner{User: req.UserInfo.Username, Groups: req.UserInfo.Groups}
+	marshaled, err := json.Marshal(&userland)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// checkOwner returns the reason to deny the request if the owner of the Userland is changed, or if the user
+// sets another user, groups the user isn't a member of, or not all the groups of the user as the owner.
+// old is nil for a new Userland.
+// The owner of a Userland created before the owner was introduced can be set once, by trusted users or by
+// the user who already owns it by the esc.k06.in/user label or the name.
+func checkOwner(userInfo authenticationv1.UserInfo, userland, old *escv1alpha2.Userland, trustedGroups []string) string {
+	owner := userland.Spec.Owner
+	if old != nil && old.Spec.Owner != nil {
+		if !reflect.DeepEqual(old.Spec.Owner, owner) {
+			return "spec.owner is immutable"
+		}
+		return ""
+	}
+	if owner == nil || memberOf(userInfo.Groups, trustedGroups...) {
+		return ""
+	}
+
+	if old != nil && owner.User != naming.User(old) {
+		return fmt.Sprintf("user %q can't set user %q as the owner of a Userland of user %q", userInfo.Username, owner.User, naming.User(old))
+	}
+	if owner.User != userInfo.Username {
+		return fmt.Sprintf("user %q can't set user %q as the owner", userInfo.Username, owner.User)
+	}
+	for _, g := range owner.Groups {
+		if !memberOf(userInfo.Groups, g) {
+			return fmt.Sprintf("user %q can't set group %q of the owner, which the user is not a member of", userInfo.Username, g)
+		}
+	}
+	// the groups select the Userland in UserlandQuotas, which must not be evaded by leaving out a group
+	for _, g := range userInfo.Groups {
+		if !memberOf(owner.Groups, g) {
+			return fmt.Sprintf("user %q must set all of the groups of the user as the groups of the owner, missing %q", userInfo.Username, g)
+		}
+	}
+	return ""
+}
+
+// memberOf returns true if one of the groups is in the groups of a user.
+func memberOf(userGroups []string, groups ...string) bool {
+	for _, ug := range userGroups {
+		for _, g := range groups {
+			if ug == g {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/naming"
+)
+
+func TestUserlandDefaulter(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = escv1alpha2.AddToScheme(scheme)
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &UserlandDefaulter{}
+	if err := d.InjectDecoder(decoder); err != nil {
+		t.Fatal(err)
+	}
+
+	req := userlandRequest(t, &escv1alpha2.Userland{
+		ObjectMeta: metav1.ObjectMeta{Name: "alice", Namespace: "esc"},
+		Spec:       escv1alpha2.UserlandSpec{TemplateName: "vscode"},
+	})
+	req.UserInfo = authenticationv1.UserInfo{Username: "alice@example.com", Groups: []string{"students"}}
+	resp := d.Handle(context.Background(), req)
+	if !resp.Allowed || len(resp.Patches) != 1 || resp.Patches[0].Path != "/spec/owner" {
+		t.Fatalf("the owner should be set, got %v", resp.Patches)
+	}
+	owner, _ := json.Marshal(resp.Patches[0].Value)
+	if string(owner) != `{"groups":["students"],"user":"alice@example.com"}` {
+		t.Errorf("owner = %s", owner)
+	}
+}
+
+func TestCheckOwner(t *testing.T) {
+	alice := authenticationv1.UserInfo{Username: "alice", Groups: []string{"students"}}
+	admin := authenticationv1.UserInfo{Username: "admin", Groups: []string{"system:masters"}}
+	trusted := []string{"system:masters"}
+	owned := func(user string, groups ...string) *escv1alpha2.Userland {
+		return &escv1alpha2.Userland{Spec: escv1alpha2.UserlandSpec{Owner: &escv1alpha2.UserlandOwner{User: user, Groups: groups}}}
+	}
+	// legacy returns a Userland created before the owner was introduced.
+	legacy := func(name string, labels map[string]string) *escv1alpha2.Userland {
+		return &escv1alpha2.Userland{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+
+	for _, tc := range []struct {
+		name     string
+		userInfo authenticationv1.UserInfo
+		userland *escv1alpha2.Userland
+		old      *escv1alpha2.Userland
+		allowed  bool
+	}{
+		{name: "own", userInfo: alice, userland: owned("alice", "students"), allowed: true},
+		{name: "on behalf of others", userInfo: alice, userland: owned("bob"), allowed: false},
+		{name: "other groups", userInfo: alice, userland: owned("alice", "mentors"), allowed: false},
+		{name: "groups left out", userInfo: alice, userland: owned("alice"), allowed: false},
+		{name: "trusted", userInfo: admin, userland: owned("bob"), allowed: true},
+		{name: "unchanged", userInfo: alice, userland: owned("bob"), old: owned("bob"), allowed: true},
+		{name: "changed", userInfo: admin, userland: owned("alice"), old: owned("bob"), allowed: false},
+		{name: "removed", userInfo: admin, userland: &escv1alpha2.Userland{}, old: owned("bob"), allowed: false},
+		{name: "set once", userInfo: alice, userland: owned("alice", "students"), old: legacy("alice", nil), allowed: true},
+		{name: "set once by label", userInfo: alice, userland: owned("alice", "students"), old: legacy("workspace", map[string]string{naming.UserLabel: "alice"}), allowed: true},
+		{name: "set once for others", userInfo: alice, userland: owned("alice"), old: legacy("bob", nil), allowed: false},
+		{name: "set once by trusted", userInfo: admin, userland: owned("bob"), old: legacy("workspace", nil), allowed: true},
+	} {
+		reason := checkOwner(tc.userInfo, tc.userland, tc.old, trusted)
+		if (reason == "") != tc.allowed {
+			t.Errorf("%s: reason = %q, want allowed %v", tc.name, reason, tc.allowed)
+		}
+	}
+}
+
+func TestUserlandValidatorOwner(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = escv1alpha2.AddToScheme(scheme)
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &UserlandValidator{}
+	if err := v.InjectDecoder(decoder); err != nil {
+		t.Fatal(err)
+	}
+
+	old := &escv1alpha2.Userland{
+		ObjectMeta: metav1.ObjectMeta{Name: "alice", Namespace: "esc"},
+		Spec:       escv1alpha2.UserlandSpec{TemplateName: "vscode", Owner: &escv1alpha2.UserlandOwner{User: "alice"}},
+	}
+	userland := old.DeepCopy()
+	userland.Spec.Owner.User = "bob"
+	req := userlandRequest(t, userland)
+	req.Operation = admissionv1beta1.Update
+	raw, err := json.Marshal(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.OldObject = runtime.RawExtension{Raw: raw}
+	if resp := v.Handle(context.Background(), req); resp.Allowed {
+		t.Errorf("the change of the owner should be denied")
+	}
+}
@@ -0,0 +1,179 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-logr/logr"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/access"
+	"github.com/koba1t/ESC/pkg/naming"
+	"github.com/koba1t/ESC/pkg/quota"
+	"github.com/koba1t/ESC/pkg/render"
+)
+
+// ValidateUserlandPath is the path of the validating webhook of Userlands.
+const ValidateUserlandPath = "/validate-esc-k06-in-v1alpha2-userland"
+
+// +kubebuilder:webhook:path=/validate-esc-k06-in-v1alpha2-userland,mutating=false,failurePolicy=fail,groups=esc.k06.in,resources=userlands,verbs=create;update,versions=v1alpha2,name=vuserland.esc.k06.in
+
+// UserlandValidator rejects Userlands created on behalf of others by untrusted users, changes of the owner,
+// Userlands of Templates which don't allow the user, Userlands which select a size which is not found in
+// their Template, and Userlands which exceed a UserlandQuota.
+// Untrusted users can't select a Template which is not found or can't be resolved, because its allowed subjects
+// are unknown. Trusted users can, because the Template may be created later.
+type UserlandValidator struct {
+	Client client.Client
+	Log    logr.Logger
+	Namer  naming.Namer
+
+	// TrustedGroups are the groups whose members can create Userlands on behalf of others,
+	// and Userlands of any Template.
+	TrustedGroups []string
+
+	decoder *admission.Decoder
+}
+
+// InjectDecoder injects the decoder of the requests.
+func (v *UserlandValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
+
+// Handle validates the Userland in the request.
+func (v *UserlandValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var userland escv1alpha2.Userland
+	if err := v.decoder.Decode(req, &userland); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	var old *escv1alpha2.Userland
+	if req.Operation == admissionv1beta1.Update {
+		old = &escv1alpha2.Userland{}
+		if err := v.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+
+	if reason := checkOwner(req.UserInfo, &userland, old, v.TrustedGroups); reason != "" {
+		return admission.Denied(reason)
+	}
+
+	spec, err := templateSpec(ctx, v.Client, userland.Namespace, userland.Spec.TemplateName)
+	if err != nil {
+		v.Log.Error(err, "unable to get Template", "template", userland.Spec.TemplateName)
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	// the Template is checked when it is selected, so that a Userland can still be updated after its Template is restricted
+	if (old == nil || old.Spec.TemplateName != userland.Spec.TemplateName) && !memberOf(req.UserInfo.Groups, v.TrustedGroups...) {
+		if spec == nil {
+			return admission.Denied(fmt.Sprintf("Template %q is not found or can't be resolved", userland.Spec.TemplateName))
+		}
+		if !access.SubjectsAllow(access.AllowedSubjects(spec, userland.Namespace), req.UserInfo.Username, req.UserInfo.Groups) {
+			return admission.Denied(fmt.Sprintf("user %q is not allowed to use Template %q", req.UserInfo.Username, userland.Spec.TemplateName))
+		}
+	}
+	if spec != nil {
+		if _, err := render.FindSize(spec, userland.Spec.Size); err != nil {
+			return admission.Denied(err.Error())
+		}
+	}
+
+	messages, err := v.checkQuotas(ctx, &userland, old, spec)
+	if err != nil {
+		v.Log.Error(err, "unable to check UserlandQuotas")
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if len(messages) > 0 {
+		return admission.Denied(strings.Join(messages, "; "))
+	}
+	return admission.Allowed("")
+}
+
+// checkQuotas returns the UserlandQuotas exceeded by the Userland. Only the resources increased by
+// the request are checked, so that a Userland which exceeds a lowered quota can still be updated.
+func (v *UserlandValidator) checkQuotas(ctx context.Context, userland, old *escv1alpha2.Userland, spec *escv1alpha2.TemplateSpec) ([]string, error) {
+	var quotas escv1alpha2.UserlandQuotaList
+	if err := v.Client.List(ctx, &quotas, client.InNamespace(userland.Namespace)); err != nil {
+		return nil, err
+	}
+	if len(quotas.Items) == 0 {
+		return nil, nil
+	}
+	var userlands escv1alpha2.UserlandList
+	if err := v.Client.List(ctx, &userlands, client.InNamespace(userland.Namespace)); err != nil {
+		return nil, err
+	}
+
+	usage := v.usage(userland, spec)
+	previous := corev1.ResourceList{}
+	if old != nil {
+		oldSpec := spec
+		if old.Spec.TemplateName != userland.Spec.TemplateName {
+			var err error
+			if oldSpec, err = templateSpec(ctx, v.Client, old.Namespace, old.Spec.TemplateName); err != nil {
+				return nil, err
+			}
+		}
+		previous = v.usage(old, oldSpec)
+	}
+
+	return quota.Check(quotas.Items, userlands.Items, userland, usage, func(name corev1.ResourceName) bool {
+		q, p := usage[name], previous[name]
+		return q.Cmp(p) > 0
+	})
+}
+
+// usage returns the usage of the Userland rendered from the spec of its Template.
+// Only the number of the Userlands and the running ones are counted if the Userland can't be rendered.
+func (v *UserlandValidator) usage(userland *escv1alpha2.Userland, spec *escv1alpha2.TemplateSpec) corev1.ResourceList {
+	if spec != nil {
+		if objects, err := render.Render(userland, spec, v.Namer); err == nil {
+			deploy := objects.Deployment
+			running := deploy.Spec.Replicas == nil || *deploy.Spec.Replicas > 0
+			return quota.ObjectsUsage(&deploy.Spec.Template.Spec, objects.PersistentVolumeClaims, running)
+		}
+	}
+	running := userland.Spec.Enabled == nil || *userland.Spec.Enabled
+	return quota.ObjectsUsage(&corev1.PodSpec{}, nil, running)
+}
+
+// templateSpec returns the resolved spec of the Template, or nil if it is not found or can't be resolved.
+// Errors of Templates are reported by the Template controller.
+func templateSpec(ctx context.Context, c client.Client, namespace, name string) (*escv1alpha2.TemplateSpec, error) {
+	var template escv1alpha2.Template
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &template); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	spec, _, err := render.ResolveTemplate(ctx, c, &template)
+	if _, ok := err.(*render.ResolveError); ok {
+		return nil, nil
+	}
+	return spec, err
+}
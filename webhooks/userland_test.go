@@ -0,0 +1,174 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+)
+
+func userlandRequest(t *testing.T, userland *escv1alpha2.Userland) admission.Request {
+	raw, err := json.Marshal(userland)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{
+		Operation: admissionv1beta1.Create,
+		Namespace: userland.Namespace,
+		Object:    runtime.RawExtension{Raw: raw},
+		UserInfo:  authenticationv1.UserInfo{Username: userland.Name, Groups: []string{"system:authenticated"}},
+	}}
+}
+
+func TestUserlandValidatorSize(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = escv1alpha2.AddToScheme(scheme)
+	template := &escv1alpha2.Template{
+		ObjectMeta: metav1.ObjectMeta{Name: "vscode", Namespace: "esc"},
+		Spec:       escv1alpha2.TemplateSpec{Sizes: []escv1alpha2.SizePreset{{Name: "small"}, {Name: "large"}}},
+	}
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &UserlandValidator{Client: fake.NewFakeClientWithScheme(scheme, template), Log: log.NullLogger{}, TrustedGroups: []string{"system:masters"}}
+	if err := v.InjectDecoder(decoder); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		template, size string
+		trusted        bool
+		allowed        bool
+	}{
+		{template: "vscode", size: "", allowed: true},
+		{template: "vscode", size: "large", allowed: true},
+		{template: "vscode", size: "huge", allowed: false},
+		{template: "missing", size: "huge", trusted: true, allowed: true},
+		{template: "missing", size: "", allowed: false},
+	} {
+		userland := &escv1alpha2.Userland{
+			ObjectMeta: metav1.ObjectMeta{Name: "koba1t", Namespace: "esc"},
+			Spec:       escv1alpha2.UserlandSpec{TemplateName: tc.template, Size: tc.size},
+		}
+		req := userlandRequest(t, userland)
+		if tc.trusted {
+			req.UserInfo.Groups = append(req.UserInfo.Groups, "system:masters")
+		}
+		resp := v.Handle(context.Background(), req)
+		if resp.Allowed != tc.allowed {
+			t.Errorf("template %q size %q: allowed = %v, want %v (%v)", tc.template, tc.size, resp.Allowed, tc.allowed, resp.Result)
+		}
+	}
+}
+
+func TestUserlandValidatorQuota(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = escv1alpha2.AddToScheme(scheme)
+	template := &escv1alpha2.Template{
+		ObjectMeta: metav1.ObjectMeta{Name: "vscode", Namespace: "esc"},
+		Spec: escv1alpha2.TemplateSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name:      "code-server",
+			Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}},
+		}}}}},
+	}
+	q := &escv1alpha2.UserlandQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "team", Namespace: "esc"},
+		Spec:       escv1alpha2.UserlandQuotaSpec{Hard: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("2")}},
+	}
+	existing := &escv1alpha2.Userland{
+		ObjectMeta: metav1.ObjectMeta{Name: "alice", Namespace: "esc"},
+		Spec:       escv1alpha2.UserlandSpec{TemplateName: "vscode"},
+		Status: escv1alpha2.UserlandStatus{QuotaUsage: corev1.ResourceList{
+			escv1alpha2.ResourceRunning: resource.MustParse("1"),
+			corev1.ResourceRequestsCPU:  resource.MustParse("1500m"),
+		}},
+	}
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &UserlandValidator{Client: fake.NewFakeClientWithScheme(scheme, template, q, existing), Log: log.NullLogger{}}
+	if err := v.InjectDecoder(decoder); err != nil {
+		t.Fatal(err)
+	}
+
+	userland := &escv1alpha2.Userland{
+		ObjectMeta: metav1.ObjectMeta{Name: "bob", Namespace: "esc"},
+		Spec:       escv1alpha2.UserlandSpec{TemplateName: "vscode"},
+	}
+	if resp := v.Handle(context.Background(), userlandRequest(t, userland)); resp.Allowed {
+		t.Errorf("a running userland exceeding the cpu of the quota should be denied")
+	}
+
+	enabled := false
+	userland.Spec.Enabled = &enabled
+	if resp := v.Handle(context.Background(), userlandRequest(t, userland)); !resp.Allowed {
+		t.Errorf("a disabled userland should be allowed, got %v", resp.Result)
+	}
+}
+
+func TestUserlandValidatorAllowedSubjects(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = escv1alpha2.AddToScheme(scheme)
+	template := &escv1alpha2.Template{
+		ObjectMeta: metav1.ObjectMeta{Name: "privileged", Namespace: "esc"},
+		Spec: escv1alpha2.TemplateSpec{AllowedSubjects: []rbacv1.Subject{
+			{APIGroup: rbacv1.GroupName, Kind: rbacv1.GroupKind, Name: "infra"},
+		}},
+	}
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &UserlandValidator{Client: fake.NewFakeClientWithScheme(scheme, template), Log: log.NullLogger{}, TrustedGroups: []string{"system:masters"}}
+	if err := v.InjectDecoder(decoder); err != nil {
+		t.Fatal(err)
+	}
+
+	userland := &escv1alpha2.Userland{
+		ObjectMeta: metav1.ObjectMeta{Name: "alice", Namespace: "esc"},
+		Spec:       escv1alpha2.UserlandSpec{TemplateName: "privileged"},
+	}
+	for _, tc := range []struct {
+		groups  []string
+		allowed bool
+	}{
+		{groups: []string{"system:authenticated"}, allowed: false},
+		{groups: []string{"system:authenticated", "infra"}, allowed: true},
+		{groups: []string{"system:masters"}, allowed: true},
+	} {
+		req := userlandRequest(t, userland)
+		req.UserInfo.Groups = tc.groups
+		if resp := v.Handle(context.Background(), req); resp.Allowed != tc.allowed {
+			t.Errorf("groups %v: allowed = %v, want %v (%v)", tc.groups, resp.Allowed, tc.allowed, resp.Result)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/go-logr/logr"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+	"github.com/koba1t/ESC/pkg/access"
+)
+
+// ValidateUserlandSetPath is the path of the validating webhook of UserlandSets.
+const ValidateUserlandSetPath = "/validate-esc-k06-in-v1alpha2-userlandset"
+
+// +kubebuilder:webhook:path=/validate-esc-k06-in-v1alpha2-userlandset,mutating=false,failurePolicy=fail,groups=esc.k06.in,resources=userlandsets,verbs=create;update,versions=v1alpha2,name=vuserlandset.esc.k06.in
+
+// UserlandSetValidator applies the checks of the owner and the Template of Userlands to UserlandSets, because their
+// Userlands are created by the manager, which is trusted. Untrusted users can only list themselves in spec.users,
+// can't use spec.userRegistry, and can only select Templates which exist and allow them.
+type UserlandSetValidator struct {
+	Client client.Client
+	Log    logr.Logger
+
+	// TrustedGroups are the groups whose members can create UserlandSets for others, of any Template.
+	TrustedGroups []string
+
+	decoder *admission.Decoder
+}
+
+// InjectDecoder injects the decoder of the requests.
+func (v *UserlandSetValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
+
+// Handle validates the UserlandSet in the request.
+func (v *UserlandSetValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var set escv1alpha2.UserlandSet
+	if err := v.decoder.Decode(req, &set); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	old := &escv1alpha2.UserlandSet{}
+	if req.Operation == admissionv1beta1.Update {
+		if err := v.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+	if memberOf(req.UserInfo.Groups, v.TrustedGroups...) {
+		return admission.Allowed("")
+	}
+
+	// only the added users are checked, so that a UserlandSet created by a trusted user can still be updated
+	listed := map[string]bool{}
+	for _, user := range old.Spec.Users {
+		listed[user] = true
+	}
+	for _, user := range set.Spec.Users {
+		if !listed[user] && user != req.UserInfo.Username {
+			return admission.Denied(fmt.Sprintf("user %q can't create a Userland on behalf of user %q", req.UserInfo.Username, user))
+		}
+	}
+	if set.Spec.UserRegistry != nil && !reflect.DeepEqual(set.Spec.UserRegistry, old.Spec.UserRegistry) {
+		return admission.Denied(fmt.Sprintf("user %q can't select users by spec.userRegistry", req.UserInfo.Username))
+	}
+
+	// the Template is checked when it is selected, like the Template of a Userland
+	if set.Spec.TemplateName == old.Spec.TemplateName {
+		return admission.Allowed("")
+	}
+	spec, err := templateSpec(ctx, v.Client, set.Namespace, set.Spec.TemplateName)
+	if err != nil {
+		v.Log.Error(err, "unable to get Template", "template", set.Spec.TemplateName)
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if spec == nil {
+		return admission.Denied(fmt.Sprintf("Template %q is not found or can't be resolved", set.Spec.TemplateName))
+	}
+	if !access.SubjectsAllow(access.AllowedSubjects(spec, set.Namespace), req.UserInfo.Username, req.UserInfo.Groups) {
+		return admission.Denied(fmt.Sprintf("user %q is not allowed to use Template %q", req.UserInfo.Username, set.Spec.TemplateName))
+	}
+	return admission.Allowed("")
+}
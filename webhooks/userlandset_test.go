@@ -0,0 +1,107 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	escv1alpha2 "github.com/koba1t/ESC/api/v1alpha2"
+)
+
+func TestUserlandSetValidator(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = escv1alpha2.AddToScheme(scheme)
+	templates := []runtime.Object{
+		&escv1alpha2.Template{ObjectMeta: metav1.ObjectMeta{Name: "vscode", Namespace: "esc"}},
+		&escv1alpha2.Template{
+			ObjectMeta: metav1.ObjectMeta{Name: "privileged", Namespace: "esc"},
+			Spec: escv1alpha2.TemplateSpec{AllowedSubjects: []rbacv1.Subject{
+				{APIGroup: rbacv1.GroupName, Kind: rbacv1.GroupKind, Name: "infra"},
+			}},
+		},
+	}
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &UserlandSetValidator{Client: fake.NewFakeClientWithScheme(scheme, templates...), Log: log.NullLogger{}, TrustedGroups: []string{"system:masters"}}
+	if err := v.InjectDecoder(decoder); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := &escv1alpha2.UserRegistry{}
+	for _, tc := range []struct {
+		name    string
+		groups  []string
+		spec    escv1alpha2.UserlandSetSpec
+		old     *escv1alpha2.UserlandSetSpec
+		allowed bool
+	}{
+		{name: "self", spec: escv1alpha2.UserlandSetSpec{TemplateName: "vscode", Users: []string{"alice"}}, allowed: true},
+		{name: "others", spec: escv1alpha2.UserlandSetSpec{TemplateName: "vscode", Users: []string{"alice", "bob"}}, allowed: false},
+		{name: "registry", spec: escv1alpha2.UserlandSetSpec{TemplateName: "vscode", UserRegistry: registry}, allowed: false},
+		{name: "missing template", spec: escv1alpha2.UserlandSetSpec{TemplateName: "missing", Users: []string{"alice"}}, allowed: false},
+		{name: "not allowed template", spec: escv1alpha2.UserlandSetSpec{TemplateName: "privileged", Users: []string{"alice"}}, allowed: false},
+		{name: "allowed template", groups: []string{"infra"}, spec: escv1alpha2.UserlandSetSpec{TemplateName: "privileged", Users: []string{"alice"}}, allowed: true},
+		{name: "trusted", groups: []string{"system:masters"}, spec: escv1alpha2.UserlandSetSpec{TemplateName: "missing", Users: []string{"bob"}, UserRegistry: registry}, allowed: true},
+		{
+			name:    "existing users",
+			spec:    escv1alpha2.UserlandSetSpec{TemplateName: "privileged", Users: []string{"bob"}, UserRegistry: registry},
+			old:     &escv1alpha2.UserlandSetSpec{TemplateName: "privileged", Users: []string{"bob", "carol"}, UserRegistry: registry},
+			allowed: true,
+		},
+		{
+			name:    "added user",
+			spec:    escv1alpha2.UserlandSetSpec{TemplateName: "vscode", Users: []string{"bob", "carol"}},
+			old:     &escv1alpha2.UserlandSetSpec{TemplateName: "vscode", Users: []string{"bob"}},
+			allowed: false,
+		},
+	} {
+		set := &escv1alpha2.UserlandSet{ObjectMeta: metav1.ObjectMeta{Name: "workshop", Namespace: "esc"}, Spec: tc.spec}
+		req := admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{
+			Operation: admissionv1beta1.Create,
+			Namespace: "esc",
+			Object:    runtime.RawExtension{Raw: marshal(t, set)},
+			UserInfo:  authenticationv1.UserInfo{Username: "alice", Groups: append([]string{"system:authenticated"}, tc.groups...)},
+		}}
+		if tc.old != nil {
+			req.Operation = admissionv1beta1.Update
+			req.OldObject = runtime.RawExtension{Raw: marshal(t, &escv1alpha2.UserlandSet{ObjectMeta: set.ObjectMeta, Spec: *tc.old})}
+		}
+		if resp := v.Handle(context.Background(), req); resp.Allowed != tc.allowed {
+			t.Errorf("%s: allowed = %v, want %v (%v)", tc.name, resp.Allowed, tc.allowed, resp.Result)
+		}
+	}
+}
+
+func marshal(t *testing.T, obj interface{}) []byte {
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
@@ -0,0 +1,55 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package webhooks implements the admission webhooks of Templates, Userlands and UserlandSets,
+// which check the resources against other resources in the cluster.
+package webhooks
+
+import (
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	"github.com/koba1t/ESC/pkg/naming"
+)
+
+// Options configures the webhooks.
+type Options struct {
+	// Namer must be the same as the one of the Userland controller.
+	Namer naming.Namer
+
+	// TrustedGroups are the groups whose members can create Userlands and UserlandSets on behalf of others,
+	// of any Template.
+	TrustedGroups []string
+}
+
+// SetupWithManager registers the webhooks to the webhook server of the manager.
+func SetupWithManager(mgr ctrl.Manager, opts Options) error {
+	server := mgr.GetWebhookServer()
+	server.Register(DefaultUserlandPath, &webhook.Admission{Handler: &UserlandDefaulter{}})
+	server.Register(ValidateUserlandPath, &webhook.Admission{Handler: &UserlandValidator{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("webhooks").WithName("Userland"),
+		Namer:  opts.Namer,
+
+		TrustedGroups: opts.TrustedGroups,
+	}})
+	server.Register(ValidateUserlandSetPath, &webhook.Admission{Handler: &UserlandSetValidator{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("webhooks").WithName("UserlandSet"),
+
+		TrustedGroups: opts.TrustedGroups,
+	}})
+	return nil
+}